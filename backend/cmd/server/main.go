@@ -1,56 +1,37 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
+	"net/http"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/torbenconto/spooler/config"
+	"github.com/torbenconto/spooler/internal/database"
 	"github.com/torbenconto/spooler/internal/models"
-	"gorm.io/driver/postgres"
-	"gorm.io/gorm"
+	"github.com/torbenconto/spooler/internal/services"
+	"github.com/torbenconto/spooler/internal/slicer"
+	"github.com/torbenconto/spooler/internal/storage"
+	"github.com/torbenconto/spooler/internal/types"
+	"github.com/torbenconto/spooler/internal/worker"
 )
 
-func validateSupabaseConfig(cfg *config.Config) error {
-	if cfg.Supabase.User == "" {
-		return errors.New("supabase user is empty")
-	}
-	if cfg.Supabase.Password == "" {
-		return errors.New("supabase password is empty")
-	}
-	if cfg.Supabase.Host == "" {
-		return errors.New("supabase host is empty")
-	}
-	if cfg.Supabase.Port <= 0 || cfg.Supabase.Port > 65535 {
-		return fmt.Errorf("supabase port is invalid: %d", cfg.Supabase.Port)
-	}
-	if cfg.Supabase.Database == "" {
-		return errors.New("supabase database name is empty")
-	}
-	return nil
-}
-
 func main() {
 	_, err := config.LoadConfig(".")
 	if err != nil {
 		log.Fatalf("failed to load config: %v", err)
 	}
 
-	if err := validateSupabaseConfig(config.Cfg); err != nil {
-		log.Fatalf("invalid supabase config: %v", err)
-	}
-
-	uri := fmt.Sprintf(
-		"postgres://%s:%s@%s:%d/%s?sslmode=require",
-		config.Cfg.Supabase.User, config.Cfg.Supabase.Password, config.Cfg.Supabase.Host, config.Cfg.Supabase.Port, config.Cfg.Supabase.Database,
-	)
-
-	db, err := gorm.Open(postgres.Open(uri), &gorm.Config{})
+	db, err := database.Open(config.Cfg)
 	if err != nil {
-		log.Fatalf("error connecting to db: %v", err)
+		log.Fatalf("%v", err)
 	}
 
-	db.AutoMigrate(models.OTP{}, models.User{}, models.Print{}, models.EmailWhitelist{})
+	db.AutoMigrate(models.OTP{}, models.User{}, models.Print{}, models.Blob{}, models.PrintObject{}, models.PrintStatusEvent{}, models.SliceJob{}, models.Printer{}, models.Material{}, models.Filament{}, models.QuotaOverride{}, models.Upload{}, models.UploadChunk{}, models.EmailWhitelist{})
 
 	var admin models.User
 	result := db.Where("email = ?", config.Cfg.Admin.Email).First(&admin)
@@ -78,12 +59,93 @@ func main() {
 		}
 	}
 
-	r, err := SetupRoutes(db)
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	storageClient, err := storage.NewStorageClient(ctx, config.Cfg)
 	if err != nil {
-		log.Fatalf("error setting up routes: %v", err)
+		log.Fatalf("error setting up storage: %v", err)
+	}
+
+	var slicerCLI *slicer.Slicer
+	if config.Cfg.Slicer.Kind != types.SlicerNone {
+		slicerCLI, err = slicer.New(config.Cfg.Slicer.Kind, config.Cfg.Slicer.Command, config.Cfg.Slicer.Timeout)
+		if err != nil {
+			log.Fatalf("error setting up slicer: %v", err)
+		}
+	}
+
+	if !config.Cfg.Scheduler.Mode.IsValid() {
+		log.Fatalf("invalid scheduler mode: %s", config.Cfg.Scheduler.Mode)
+	}
+
+	printSvc := services.NewPrintService(db)
+	printerSvc := services.NewPrinterService(db)
+	jobSvc := services.NewPrintJobService(printSvc, printerSvc, storageClient)
+	jobSvc.OfflineTimeout = config.Cfg.Printers.OfflineTimeout
+	slicingSvc := services.NewSlicingService(db, printSvc, printerSvc, storageClient, slicerCLI, config.Cfg.Slicer.Concurrency)
+	slicingSvc.DefaultProfile = config.Cfg.Slicer.DefaultProfile
+	jobSvc.Slicing = slicingSvc
+	if err := slicingSvc.FailInterrupted(); err != nil {
+		log.Printf("failed to reset interrupted slicer runs: %v", err)
+	}
+	schedulerSvc := services.NewSchedulerService(printSvc, printerSvc, jobSvc, config.Cfg.Scheduler.Mode)
+	blobSvc := services.NewBlobService(db, storageClient)
+	blobSvc.DeleteOrphans = config.Cfg.Storage.GC.DeleteOrphans
+	blobSvc.OrphanMinAge = config.Cfg.Storage.GC.OrphanMinAge
+	blobSvc.CompletedRetention = time.Duration(config.Cfg.Storage.GC.CompletedRetentionDays) * 24 * time.Hour
+	blobSvc.DeniedRetention = time.Duration(config.Cfg.Storage.GC.DeniedRetentionDays) * 24 * time.Hour
+	uploadSvc := services.NewUploadService(db, storageClient)
+	uploadSvc.Expiry = config.Cfg.Uploads.Resumable.Expiry
+	uploadSvc.MaxChunkSize = config.Cfg.Uploads.Resumable.MaxChunkSize
+
+	// Dispatches and slicer runs started by requests are awaited with the periodic workers and stopped with ctx
+	supervisor := worker.NewSupervisor()
+	background := func(name string, task worker.Task) {
+		supervisor.Go(ctx, name, task)
+	}
+	jobSvc.Background = background
+	slicingSvc.Background = background
+	supervisor.Every(ctx, "printer-poller", config.Cfg.Printers.PollInterval, jobSvc.Reconcile)
+	if config.Cfg.Scheduler.Mode == types.SchedulerAuto {
+		supervisor.Every(ctx, "scheduler", config.Cfg.Scheduler.Interval, schedulerSvc.Run)
 	}
+	supervisor.Every(ctx, "storage-gc", config.Cfg.Storage.GC.Interval, blobSvc.Collect)
+	supervisor.Every(ctx, "upload-expiry", config.Cfg.Uploads.Resumable.CleanupInterval, uploadSvc.ExpireUploads)
+
+	router := SetupRoutes(db, storageClient, sharedServices{
+		Print:     printSvc,
+		Printer:   printerSvc,
+		Blob:      blobSvc,
+		Job:       jobSvc,
+		Scheduler: schedulerSvc,
+		Slicing:   slicingSvc,
+		Upload:    uploadSvc,
+	})
+
+	srv := &http.Server{
+		Addr:    fmt.Sprintf(":%d", config.Cfg.Port),
+		Handler: router,
+	}
+
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("failed to start server %v", err)
+		}
+	}()
+
+	<-ctx.Done()
+	log.Println("shutting down")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("failed to shut down server cleanly: %v", err)
+	}
+
+	supervisor.Wait()
 
-	if err := r.Run(fmt.Sprintf(":%d", config.Cfg.Port)); err != nil {
-		log.Fatalf("failed to start server %v", err)
+	if sqlDB, err := db.DB(); err == nil {
+		sqlDB.Close()
 	}
 }
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"context"
 	"strings"
 	"time"
 
@@ -13,10 +12,22 @@ import (
 	"github.com/torbenconto/spooler/internal/models"
 	"github.com/torbenconto/spooler/internal/services"
 	"github.com/torbenconto/spooler/internal/storage"
+	"github.com/torbenconto/spooler/internal/types"
 	"gorm.io/gorm"
 )
 
-func SetupRoutes(db *gorm.DB) (*gin.Engine, error) {
+// sharedServices are configured by main and shared with the background workers, handlers must use the same instances
+type sharedServices struct {
+	Print     *services.PrintService
+	Printer   *services.PrinterService
+	Blob      *services.BlobService
+	Job       *services.PrintJobService
+	Scheduler *services.SchedulerService
+	Slicing   *services.SlicingService
+	Upload    *services.UploadService
+}
+
+func SetupRoutes(db *gorm.DB, storageClient storage.StorageClient, shared sharedServices) *gin.Engine {
 	r := gin.Default()
 
 	var allowedOrigins []string
@@ -30,22 +41,30 @@ func SetupRoutes(db *gorm.DB) (*gin.Engine, error) {
 
 	r.Use(cors.New(cors.Config{
 		AllowOrigins:     allowedOrigins,
-		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization"},
+		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization", "Upload-Offset"},
 		AllowCredentials: true,
-		ExposeHeaders:    []string{"Content-Length", "Set-Cookie"},
+		ExposeHeaders:    []string{"Content-Length", "Set-Cookie", "Upload-Offset", "Location"},
 		MaxAge:           12 * time.Hour,
 	}))
 
-	storageClient, err := storage.NewStorageClient(context.Background(), config.Cfg)
-	if err != nil {
-		return nil, err
-	}
-
 	userSvc := services.NewUserService(db)
 	otpSvc := services.NewOTPService(db)
-	printSvc := services.NewPrintService(db)
+	printSvc := shared.Print
+	printerSvc := shared.Printer
+	blobSvc := shared.Blob
+	jobSvc := shared.Job
+	schedulerSvc := shared.Scheduler
+	slicingSvc := shared.Slicing
+	uploadSvc := shared.Upload
 	whitelistSvc := services.NewWhitelistService(db)
+	materialSvc := services.NewMaterialService(db)
+	filamentSvc := services.NewFilamentService(db)
+	quotaSvc := services.NewQuotaService(db, map[models.Role]types.Quota{
+		models.RoleUser:    config.Cfg.Quotas.User,
+		models.RoleOfficer: config.Cfg.Quotas.Officer,
+		models.RoleAdmin:   config.Cfg.Quotas.Admin,
+	})
 
 	// Public routes
 	otp := r.Group("/otp")
@@ -54,6 +73,13 @@ func SetupRoutes(db *gorm.DB) (*gin.Engine, error) {
 		otp.POST("/verify", handlers.VerifyOTPHandler(otpSvc, userSvc))
 	}
 	r.POST("/register", handlers.RegisterHandler(userSvc, whitelistSvc))
+	r.GET("/filaments", handlers.ListFilamentsHandler(filamentSvc))
+
+	// Signed URLs of the local storage provider, the signature authorizes the request
+	if local, ok := storageClient.(*storage.LocalStorageClient); ok {
+		r.GET("/files/*path", handlers.SignedFileHandler(local))
+		r.PUT("/files/*path", handlers.SignedFileHandler(local))
+	}
 
 	// Authenticated user routes
 	auth := r.Group("/")
@@ -61,9 +87,23 @@ func SetupRoutes(db *gorm.DB) (*gin.Engine, error) {
 	{
 		auth.GET("/me", handlers.MeHandler())
 		auth.GET("/me/prints", handlers.GetUserPrintsHandler(printSvc))
-		auth.GET("/bucket/:filename", handlers.DownloadPrintFileHandler(storageClient))
+		auth.GET("/me/quota", handlers.MyQuotaHandler(quotaSvc))
+		if config.Cfg.Features.LegacyBucketRoute {
+			auth.GET("/bucket/:filename", handlers.DownloadPrintFileHandler(storageClient))
+		}
 		auth.POST("/preview", handlers.PreviewHandler())
-		auth.POST("/prints/new", handlers.NewPrintHandler(storageClient, printSvc))
+		auth.POST("/prints/new", handlers.NewPrintHandler(storageClient, printSvc, blobSvc, uploadSvc, filamentSvc, quotaSvc))
+		auth.POST("/prints/upload-url", handlers.PrintUploadURLHandler(storageClient, quotaSvc))
+
+		auth.POST("/uploads", handlers.CreateUploadHandler(uploadSvc, quotaSvc))
+		auth.GET("/uploads/:id", handlers.UploadStatusHandler(uploadSvc))
+		auth.PATCH("/uploads/:id", handlers.UploadChunkHandler(uploadSvc))
+		auth.DELETE("/uploads/:id", handlers.DeleteUploadHandler(uploadSvc))
+
+		auth.GET("/prints/:id/history", handlers.PrintHistoryHandler(printSvc))
+		auth.GET("/prints/:id/file", handlers.PrintFileHandler(printSvc, storageClient))
+		auth.GET("/prints/:id/thumbnail", handlers.PrintThumbnailHandler(printSvc, storageClient))
+		auth.GET("/materials", handlers.ListMaterialsHandler(materialSvc))
 	}
 
 	// Admin-only routes
@@ -74,13 +114,49 @@ func SetupRoutes(db *gorm.DB) (*gin.Engine, error) {
 		{
 			prints.GET("/all", handlers.AllPrintsHandler(printSvc))
 
-			prints.DELETE("/:id", handlers.DeletePrintHandler(printSvc, storageClient))
-			prints.PUT("/:id", handlers.UpdatePrintHandler(printSvc))
+			prints.DELETE("/:id", handlers.DeletePrintHandler(printSvc, blobSvc, storageClient))
+			prints.PUT("/:id", handlers.UpdatePrintHandler(printSvc, jobSvc))
+			prints.POST("/:id/slice", handlers.SlicePrintHandler(printSvc, slicingSvc))
+			prints.GET("/:id/slices", handlers.SliceJobsHandler(slicingSvc))
+		}
+
+		printers := admin.Group("/printers")
+		{
+			printers.GET("", handlers.ListPrintersHandler(printerSvc))
+			printers.POST("", handlers.CreatePrinterHandler(printerSvc))
+			printers.GET("/:id", handlers.GetPrinterHandler(printerSvc))
+			printers.PUT("/:id", handlers.UpdatePrinterHandler(printerSvc))
+			printers.DELETE("/:id", handlers.DeletePrinterHandler(printerSvc))
+		}
+
+		materials := admin.Group("/materials")
+		{
+			materials.POST("", handlers.CreateMaterialHandler(materialSvc))
+			materials.PUT("/:id", handlers.UpdateMaterialHandler(materialSvc))
+			materials.DELETE("/:id", handlers.DeleteMaterialHandler(materialSvc))
+		}
+
+		filaments := admin.Group("/filaments")
+		{
+			filaments.GET("/all", handlers.AllFilamentsHandler(filamentSvc))
+			filaments.POST("", handlers.CreateFilamentHandler(filamentSvc))
+			filaments.GET("/:id", handlers.GetFilamentHandler(filamentSvc))
+			filaments.PUT("/:id", handlers.UpdateFilamentHandler(filamentSvc))
+			filaments.DELETE("/:id", handlers.DeleteFilamentHandler(filamentSvc))
+		}
+
+		queue := admin.Group("/queue")
+		{
+			queue.GET("", handlers.QueueHandler(schedulerSvc))
+			queue.POST("/:id/confirm", handlers.ConfirmQueueHandler(schedulerSvc))
 		}
 
 		users := admin.Group("/users")
 		{
 			users.GET("/:id", handlers.GetUserByIDHandler(userSvc))
+			users.GET("/:id/quota", handlers.GetUserQuotaHandler(quotaSvc, userSvc))
+			users.PUT("/:id/quota", handlers.SetUserQuotaHandler(quotaSvc))
+			users.DELETE("/:id/quota", handlers.DeleteUserQuotaHandler(quotaSvc))
 		}
 
 		admin.GET("/whitelist", middleware.WhitelistEnabledMiddleware(), handlers.ListWhitelistHandler(whitelistSvc))
@@ -88,5 +164,5 @@ func SetupRoutes(db *gorm.DB) (*gin.Engine, error) {
 		admin.DELETE("/whitelist", middleware.WhitelistEnabledMiddleware(), handlers.RemoveWhitelistHandler(whitelistSvc))
 	}
 
-	return r, nil
+	return r
 }
@@ -0,0 +1,104 @@
+// Command spooler runs maintenance tasks against the database and storage of a spooler deployment.
+//
+//	spooler storage migrate --from google_cloud --to local
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/torbenconto/spooler/config"
+	"github.com/torbenconto/spooler/internal/database"
+	"github.com/torbenconto/spooler/internal/services"
+	"github.com/torbenconto/spooler/internal/storage"
+	"github.com/torbenconto/spooler/internal/types"
+)
+
+const usage = `usage: spooler <command> [flags]
+
+commands:
+  storage migrate --from <provider> --to <provider> [--state <file>]
+      copy every stored file the database refers to from one storage provider to another
+`
+
+func main() {
+	if len(os.Args) < 3 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	switch os.Args[1] + " " + os.Args[2] {
+	case "storage migrate":
+		storageMigrate(os.Args[3:])
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+}
+
+func storageMigrate(args []string) {
+	flags := flag.NewFlagSet("storage migrate", flag.ExitOnError)
+	from := flags.String("from", "", "provider to copy from (google_cloud, local or s3)")
+	to := flags.String("to", "", "provider to copy to (google_cloud, local or s3)")
+	statePath := flags.String("state", "storage-migrate.state", "file completed copies are recorded in, to resume an interrupted migration")
+	_ = flags.Parse(args)
+
+	fromProvider, toProvider := types.StorageProvider(*from), types.StorageProvider(*to)
+	if !fromProvider.IsValid() || !toProvider.IsValid() || fromProvider == toProvider {
+		log.Fatalf("--from and --to must be two different providers out of google_cloud, local and s3")
+	}
+
+	if _, err := config.LoadConfig("."); err != nil {
+		log.Fatalf("failed to load config: %v", err)
+	}
+
+	db, err := database.Open(config.Cfg)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	source, err := storage.NewStorageClientFor(ctx, config.Cfg, fromProvider)
+	if err != nil {
+		log.Fatalf("error setting up %s storage: %v", fromProvider, err)
+	}
+	destination, err := storage.NewStorageClientFor(ctx, config.Cfg, toProvider)
+	if err != nil {
+		log.Fatalf("error setting up %s storage: %v", toProvider, err)
+	}
+
+	// The blob service is only used to read the file names, its storage client is never touched
+	objects, err := services.NewBlobService(db, source).ReferencedObjects()
+	if err != nil {
+		log.Fatalf("failed to list stored files: %v", err)
+	}
+
+	migration := &storage.Migration{From: source, To: destination, StatePath: *statePath}
+	report, err := migration.Run(ctx, objects)
+	if report != nil {
+		fmt.Printf("copied %d, already copied %d, missing %d, failed %d, orphaned %d\n",
+			len(report.Copied), len(report.Skipped), len(report.Missing), len(report.Failed), len(report.Orphaned))
+		for _, objectPath := range report.Missing {
+			fmt.Printf("missing: %s\n", objectPath)
+		}
+		for objectPath, err := range report.Failed {
+			fmt.Printf("failed: %s: %v\n", objectPath, err)
+		}
+		for _, objectPath := range report.Orphaned {
+			fmt.Printf("orphaned: %s\n", objectPath)
+		}
+	}
+	if err != nil {
+		log.Fatalf("migration stopped, run it again to resume: %v", err)
+	}
+	if len(report.Failed) > 0 {
+		log.Fatalf("%d files failed to copy, run the migration again to retry them", len(report.Failed))
+	}
+}
@@ -2,6 +2,7 @@ package config
 
 import (
 	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 	"github.com/spf13/viper"
@@ -18,6 +19,8 @@ type Config struct {
 
 	Features struct {
 		EmailWhitelistEnabled bool `mapstructure:"email_whitelist_enabled"`
+		// LegacyBucketRoute keeps the deprecated GET /bucket/:filename, which serves any file to any signed in user
+		LegacyBucketRoute bool `mapstructure:"legacy_bucket_route"`
 	} `mapstructure:"features"`
 
 	Supabase struct {
@@ -41,8 +44,61 @@ type Config struct {
 		LastName  string `mapstructure:"last_name"`
 	} `mapstructure:"admin"`
 
+	Printers struct {
+		// How often printers with a driver are polled for job progress
+		PollInterval time.Duration `mapstructure:"poll_interval"`
+		// How long a printer may be unreachable mid print before the print is marked failed
+		OfflineTimeout time.Duration `mapstructure:"offline_timeout"`
+	} `mapstructure:"printers"`
+
+	Scheduler struct {
+		Mode types.SchedulerMode `mapstructure:"mode"`
+		// How often the queue is dispatched in auto mode
+		Interval time.Duration `mapstructure:"interval"`
+	} `mapstructure:"scheduler"`
+
+	Slicer struct {
+		// Kind selects the slicer CLI, empty disables server side slicing
+		Kind    types.SlicerKind `mapstructure:"kind"`
+		Command string           `mapstructure:"command"`
+		// DefaultProfile is used for printers without a slicer profile of their own
+		DefaultProfile string        `mapstructure:"default_profile"`
+		Timeout        time.Duration `mapstructure:"timeout"`
+		// How many slicer processes may run at once
+		Concurrency int `mapstructure:"concurrency"`
+	} `mapstructure:"slicer"`
+
+	// Uploads are the rules print files are validated against before they are analyzed
+	Uploads struct {
+		// AllowedTypes are the accepted file types by extension: stl, 3mf, gcode.3mf and gcode
+		AllowedTypes []string `mapstructure:"allowed_types"`
+		// MaxFileSize caps every upload in bytes, the quota of a user can lower it further
+		MaxFileSize int64 `mapstructure:"max_file_size"`
+		// MaxUncompressedSize and MaxCompressionRatio reject 3MF packages that unpack to far more than they weigh
+		MaxUncompressedSize int64   `mapstructure:"max_uncompressed_size"`
+		MaxCompressionRatio float64 `mapstructure:"max_compression_ratio"`
+		// Resumable configures uploads sent in chunks, see POST /uploads
+		Resumable struct {
+			// MaxChunkSize caps a single chunk in bytes
+			MaxChunkSize int64 `mapstructure:"max_chunk_size"`
+			// Expiry is how long after its last chunk an unfinished upload is deleted
+			Expiry time.Duration `mapstructure:"expiry"`
+			// CleanupInterval is how often expired uploads are looked for
+			CleanupInterval time.Duration `mapstructure:"cleanup_interval"`
+		} `mapstructure:"resumable"`
+	} `mapstructure:"uploads"`
+
+	// Quotas are the submission limits of each role, users can be given their own by an admin
+	Quotas struct {
+		User    types.Quota `mapstructure:"user"`
+		Officer types.Quota `mapstructure:"officer"`
+		Admin   types.Quota `mapstructure:"admin"`
+	} `mapstructure:"quotas"`
+
 	Storage struct {
 		Provider types.StorageProvider `mapstructure:"provider"`
+		// How long signed download and upload URLs stay valid, for providers that support them
+		SignedURLExpiry time.Duration `mapstructure:"signed_url_expiry"`
 
 		GoogleCloud struct {
 			BucketName string `mapstructure:"bucket_name"`
@@ -50,7 +106,36 @@ type Config struct {
 
 		Local struct {
 			BasePath string `mapstructure:"base_path"`
+			// PublicURL is the address of this server that signed file URLs point to, empty makes them relative
+			PublicURL string `mapstructure:"public_url"`
 		} `mapstructure:"local"`
+
+		S3 struct {
+			// Endpoint of any S3 compatible service, empty uses AWS in Region
+			Endpoint string `mapstructure:"endpoint"`
+			Region   string `mapstructure:"region"`
+			Bucket   string `mapstructure:"bucket"`
+			// UsePathStyle puts the bucket in the path instead of the host name, needed for MinIO
+			UsePathStyle    bool   `mapstructure:"use_path_style"`
+			AccessKeyID     string `mapstructure:"access_key_id"`
+			SecretAccessKey string `mapstructure:"secret_access_key"`
+			SessionToken    string `mapstructure:"session_token"`
+			// Files larger than PartSize bytes are uploaded in parts of that size
+			PartSize int64 `mapstructure:"part_size"`
+		} `mapstructure:"s3"`
+
+		GC struct {
+			// How often unreferenced files are deleted and the retention policy is applied, 0 disables both
+			Interval time.Duration `mapstructure:"interval"`
+			// DeleteOrphans deletes every file in the storage spooler does not refer to. Off by default, the bucket or directory
+			// may be shared with other data.
+			DeleteOrphans bool `mapstructure:"delete_orphans"`
+			// OrphanMinAge spares unreferenced files younger than this, they may belong to an upload in progress
+			OrphanMinAge time.Duration `mapstructure:"orphan_min_age"`
+			// Days after a print was completed or denied that its model is deleted, the print itself is kept. 0 keeps it forever.
+			CompletedRetentionDays int `mapstructure:"completed_retention_days"`
+			DeniedRetentionDays    int `mapstructure:"denied_retention_days"`
+		} `mapstructure:"gc"`
 	} `mapstructure:"storage"`
 }
 
@@ -68,6 +153,48 @@ func LoadConfig(path string) (*Config, error) {
 		}
 	}
 
+	viper.SetDefault("features.legacy_bucket_route", false)
+	viper.SetDefault("printers.poll_interval", "10s")
+	viper.SetDefault("printers.offline_timeout", "2m")
+	viper.SetDefault("scheduler.mode", string(types.SchedulerSemiAuto))
+	viper.SetDefault("scheduler.interval", "30s")
+	viper.SetDefault("slicer.timeout", "10m")
+	viper.SetDefault("slicer.concurrency", 1)
+	viper.SetDefault("storage.signed_url_expiry", "15m")
+	viper.SetDefault("storage.local.public_url", "")
+	viper.SetDefault("storage.s3.endpoint", "")
+	viper.SetDefault("storage.s3.region", "us-east-1")
+	viper.SetDefault("storage.s3.bucket", "")
+	viper.SetDefault("storage.s3.use_path_style", false)
+	viper.SetDefault("storage.s3.access_key_id", "")
+	viper.SetDefault("storage.s3.secret_access_key", "")
+	viper.SetDefault("storage.s3.session_token", "")
+	viper.SetDefault("storage.s3.part_size", 16*1024*1024)
+	viper.SetDefault("storage.gc.interval", "24h")
+	viper.SetDefault("storage.gc.delete_orphans", false)
+	viper.SetDefault("storage.gc.orphan_min_age", "24h")
+	viper.SetDefault("storage.gc.completed_retention_days", 0)
+	viper.SetDefault("storage.gc.denied_retention_days", 0)
+	viper.SetDefault("uploads.allowed_types", []string{"stl", "3mf", "gcode.3mf", "gcode"})
+	viper.SetDefault("uploads.max_file_size", 256*1024*1024)
+	viper.SetDefault("uploads.max_uncompressed_size", 1024*1024*1024)
+	viper.SetDefault("uploads.max_compression_ratio", 100)
+	viper.SetDefault("uploads.resumable.max_chunk_size", 8*1024*1024)
+	viper.SetDefault("uploads.resumable.expiry", "24h")
+	viper.SetDefault("uploads.resumable.cleanup_interval", "1h")
+	viper.SetDefault("quotas.user.max_open_prints", 5)
+	viper.SetDefault("quotas.user.max_grams_per_week", 500)
+	viper.SetDefault("quotas.user.max_grams_per_month", 1500)
+	viper.SetDefault("quotas.user.max_file_size", 100*1024*1024)
+	viper.SetDefault("quotas.officer.max_open_prints", 10)
+	viper.SetDefault("quotas.officer.max_grams_per_week", 1000)
+	viper.SetDefault("quotas.officer.max_grams_per_month", 3000)
+	viper.SetDefault("quotas.officer.max_file_size", 100*1024*1024)
+	viper.SetDefault("quotas.admin.max_open_prints", 0)
+	viper.SetDefault("quotas.admin.max_grams_per_week", 0)
+	viper.SetDefault("quotas.admin.max_grams_per_month", 0)
+	viper.SetDefault("quotas.admin.max_file_size", 0)
+
 	var cfg Config
 	if err := viper.Unmarshal(&cfg); err != nil {
 		return nil, err
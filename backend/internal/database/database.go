@@ -0,0 +1,47 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/torbenconto/spooler/config"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func validateSupabaseConfig(cfg *config.Config) error {
+	if cfg.Supabase.User == "" {
+		return errors.New("supabase user is empty")
+	}
+	if cfg.Supabase.Password == "" {
+		return errors.New("supabase password is empty")
+	}
+	if cfg.Supabase.Host == "" {
+		return errors.New("supabase host is empty")
+	}
+	if cfg.Supabase.Port <= 0 || cfg.Supabase.Port > 65535 {
+		return fmt.Errorf("supabase port is invalid: %d", cfg.Supabase.Port)
+	}
+	if cfg.Supabase.Database == "" {
+		return errors.New("supabase database name is empty")
+	}
+	return nil
+}
+
+// Open connects to the Supabase Postgres database of the config
+func Open(cfg *config.Config) (*gorm.DB, error) {
+	if err := validateSupabaseConfig(cfg); err != nil {
+		return nil, fmt.Errorf("invalid supabase config: %w", err)
+	}
+
+	uri := fmt.Sprintf(
+		"postgres://%s:%s@%s:%d/%s?sslmode=require",
+		cfg.Supabase.User, cfg.Supabase.Password, cfg.Supabase.Host, cfg.Supabase.Port, cfg.Supabase.Database,
+	)
+
+	db, err := gorm.Open(postgres.Open(uri), &gorm.Config{})
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to db: %w", err)
+	}
+	return db, nil
+}
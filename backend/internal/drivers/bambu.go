@@ -0,0 +1,265 @@
+package drivers
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+const (
+	bambuUser     = "bblp"
+	bambuFTPSPort = 990
+	bambuMQTTPort = 8883
+)
+
+// BambuDriver talks to a Bambu Lab printer in LAN mode, files are uploaded over implicit FTPS and jobs are controlled over the printer's MQTT broker.
+// A connection is opened for every call, the printer only accepts a handful of concurrent clients.
+type BambuDriver struct {
+	host       string
+	serial     string
+	accessCode string
+	// Bambu printers use self signed certificates
+	tlsConfig *tls.Config
+	// ftpsPort and mqttPort are fixed on the printer, they are fields so tests can point them elsewhere
+	ftpsPort int
+	mqttPort int
+}
+
+func NewBambuDriver(address string, serial string, accessCode string) (*BambuDriver, error) {
+	if address == "" {
+		return nil, fmt.Errorf("bambu address is empty")
+	}
+	if serial == "" {
+		return nil, fmt.Errorf("bambu serial number is empty")
+	}
+	if accessCode == "" {
+		return nil, fmt.Errorf("bambu access code is empty")
+	}
+
+	host := address
+	if h, _, err := net.SplitHostPort(address); err == nil {
+		host = h
+	}
+
+	return &BambuDriver{
+		host:       host,
+		serial:     serial,
+		accessCode: accessCode,
+		tlsConfig: &tls.Config{
+			InsecureSkipVerify: true,
+			ClientSessionCache: tls.NewLRUClientSessionCache(4),
+		},
+		ftpsPort: bambuFTPSPort,
+		mqttPort: bambuMQTTPort,
+	}, nil
+}
+
+func (b *BambuDriver) Upload(ctx context.Context, fileName string, file io.Reader) error {
+	client, err := dialFTPS(ctx, b.host, b.ftpsPort, b.tlsConfig)
+	if err != nil {
+		return fmt.Errorf("ftps connect failed: %w", err)
+	}
+	defer client.Close()
+
+	stop := context.AfterFunc(ctx, func() { _ = client.conn.Close() })
+	defer stop()
+
+	if err := client.Login(bambuUser, b.accessCode); err != nil {
+		return fmt.Errorf("ftps login failed: %w", err)
+	}
+
+	return client.Store(ctx, fileName, file)
+}
+
+func (b *BambuDriver) requestTopic() string {
+	return fmt.Sprintf("device/%s/request", b.serial)
+}
+
+func (b *BambuDriver) reportTopic() string {
+	return fmt.Sprintf("device/%s/report", b.serial)
+}
+
+func (b *BambuDriver) connect(ctx context.Context) (mqtt.Client, error) {
+	opts := mqtt.NewClientOptions().
+		AddBroker(fmt.Sprintf("tls://%s", net.JoinHostPort(b.host, strconv.Itoa(b.mqttPort)))).
+		SetClientID(fmt.Sprintf("spooler-%d", time.Now().UnixNano())).
+		SetUsername(bambuUser).
+		SetPassword(b.accessCode).
+		SetTLSConfig(b.tlsConfig).
+		SetAutoReconnect(false).
+		SetConnectRetry(false)
+
+	client := mqtt.NewClient(opts)
+	if err := waitToken(ctx, client.Connect()); err != nil {
+		return nil, fmt.Errorf("mqtt connect failed: %w", err)
+	}
+
+	return client, nil
+}
+
+func waitToken(ctx context.Context, token mqtt.Token) error {
+	select {
+	case <-token.Done():
+		return token.Error()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func sequenceID() string {
+	return strconv.FormatInt(time.Now().UnixMilli(), 10)
+}
+
+func (b *BambuDriver) publish(ctx context.Context, payload any) error {
+	client, err := b.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Disconnect(250)
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	return waitToken(ctx, client.Publish(b.requestTopic(), 1, false, body))
+}
+
+func (b *BambuDriver) printCommand(ctx context.Context, command string) error {
+	return b.publish(ctx, map[string]any{
+		"print": map[string]string{
+			"sequence_id": sequenceID(),
+			"command":     command,
+		},
+	})
+}
+
+// Start prints the first plate of a sliced project file previously uploaded to the SD card
+func (b *BambuDriver) Start(ctx context.Context, fileName string) error {
+	if !strings.HasSuffix(strings.ToLower(fileName), ".gcode.3mf") {
+		return fmt.Errorf("bambu printers can only print sliced .gcode.3mf files, got %s", fileName)
+	}
+
+	return b.publish(ctx, map[string]any{
+		"print": map[string]any{
+			"sequence_id":    sequenceID(),
+			"command":        "project_file",
+			"param":          "Metadata/plate_1.gcode",
+			"url":            "file:///sdcard/" + fileName,
+			"subtask_name":   fileName,
+			"project_id":     "0",
+			"profile_id":     "0",
+			"task_id":        "0",
+			"subtask_id":     "0",
+			"md5":            "",
+			"bed_type":       "auto",
+			"bed_levelling":  true,
+			"flow_cali":      false,
+			"vibration_cali": true,
+			"layer_inspect":  false,
+			"timelapse":      false,
+			"use_ams":        false,
+		},
+	})
+}
+
+func (b *BambuDriver) Pause(ctx context.Context) error {
+	return b.printCommand(ctx, "pause")
+}
+
+func (b *BambuDriver) Resume(ctx context.Context) error {
+	return b.printCommand(ctx, "resume")
+}
+
+func (b *BambuDriver) Cancel(ctx context.Context) error {
+	return b.printCommand(ctx, "stop")
+}
+
+type bambuReport struct {
+	Print *struct {
+		GCodeState  string `json:"gcode_state"`
+		MCPercent   *int   `json:"mc_percent"`
+		SubtaskName string `json:"subtask_name"`
+		PrintError  int    `json:"print_error"`
+	} `json:"print"`
+}
+
+// Status asks the printer for a full report and maps gcode_state and mc_percent onto a JobStatus
+func (b *BambuDriver) Status(ctx context.Context) (*JobStatus, error) {
+	client, err := b.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Disconnect(250)
+
+	reports := make(chan bambuReport, 1)
+	subscribe := client.Subscribe(b.reportTopic(), 0, func(_ mqtt.Client, msg mqtt.Message) {
+		var report bambuReport
+		// The printer sends partial updates between full reports, only a full report carries gcode_state
+		if err := json.Unmarshal(msg.Payload(), &report); err != nil || report.Print == nil || report.Print.GCodeState == "" {
+			return
+		}
+		select {
+		case reports <- report:
+		default:
+		}
+	})
+	if err := waitToken(ctx, subscribe); err != nil {
+		return nil, fmt.Errorf("mqtt subscribe failed: %w", err)
+	}
+
+	pushAll, err := json.Marshal(map[string]any{
+		"pushing": map[string]string{
+			"sequence_id": sequenceID(),
+			"command":     "pushall",
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := waitToken(ctx, client.Publish(b.requestTopic(), 1, false, pushAll)); err != nil {
+		return nil, err
+	}
+
+	var report bambuReport
+	select {
+	case report = <-reports:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	status := &JobStatus{
+		FileName: report.Print.SubtaskName,
+		Message:  report.Print.GCodeState,
+	}
+	if report.Print.MCPercent != nil {
+		status.Progress = clampProgress(float64(*report.Print.MCPercent))
+	}
+
+	switch report.Print.GCodeState {
+	case "PREPARE", "SLICING", "RUNNING":
+		status.State = JobPrinting
+	case "PAUSE":
+		status.State = JobPaused
+	case "FINISH":
+		status.State = JobCompleted
+		status.Progress = 100
+	case "FAILED":
+		status.State = JobFailed
+		if report.Print.PrintError != 0 {
+			status.Message = fmt.Sprintf("printer reported error %d", report.Print.PrintError)
+		}
+	default:
+		status.State = JobIdle
+	}
+
+	return status, nil
+}
@@ -0,0 +1,261 @@
+package drivers
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+// fakeBroker is the MQTT broker of a Bambu printer in LAN mode: it records requests and answers pushall with a report
+type fakeBroker struct {
+	t        *testing.T
+	serial   string
+	password string
+	listener net.Listener
+
+	mu       sync.Mutex
+	requests []map[string]any
+	// report is published on the report topic for every pushall, after a partial update without gcode_state
+	report string
+}
+
+func newFakeBroker(t *testing.T, serial string, password string) *fakeBroker {
+	t.Helper()
+	b := &fakeBroker{t: t, serial: serial, password: password}
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", testTLSConfig(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.listener = listener
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go b.serve(conn)
+		}
+	}()
+	return b
+}
+
+func (b *fakeBroker) port() int {
+	return b.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (b *fakeBroker) serve(conn net.Conn) {
+	defer conn.Close()
+	subscribed := make(map[string]bool)
+
+	for {
+		packet, err := packets.ReadPacket(conn)
+		if err != nil {
+			return
+		}
+
+		switch p := packet.(type) {
+		case *packets.ConnectPacket:
+			connack := packets.NewControlPacket(packets.Connack).(*packets.ConnackPacket)
+			if p.Username != bambuUser || string(p.Password) != b.password {
+				connack.ReturnCode = packets.ErrRefusedNotAuthorised
+				_ = connack.Write(conn)
+				return
+			}
+			_ = connack.Write(conn)
+		case *packets.SubscribePacket:
+			suback := packets.NewControlPacket(packets.Suback).(*packets.SubackPacket)
+			suback.MessageID = p.MessageID
+			for i, topic := range p.Topics {
+				subscribed[topic] = true
+				suback.ReturnCodes = append(suback.ReturnCodes, p.Qoss[i])
+			}
+			_ = suback.Write(conn)
+		case *packets.PublishPacket:
+			if p.Qos > 0 {
+				puback := packets.NewControlPacket(packets.Puback).(*packets.PubackPacket)
+				puback.MessageID = p.MessageID
+				_ = puback.Write(conn)
+			}
+			if p.TopicName != "device/"+b.serial+"/request" {
+				continue
+			}
+
+			var request map[string]any
+			if err := json.Unmarshal(p.Payload, &request); err != nil {
+				b.t.Errorf("request is not JSON: %s", p.Payload)
+				continue
+			}
+			b.mu.Lock()
+			b.requests = append(b.requests, request)
+			report := b.report
+			b.mu.Unlock()
+
+			reportTopic := "device/" + b.serial + "/report"
+			if _, ok := request["pushing"]; ok && subscribed[reportTopic] {
+				for _, payload := range []string{`{"print":{"mc_percent":1,"sequence_id":"0"}}`, report} {
+					publish := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+					publish.TopicName = reportTopic
+					publish.Payload = []byte(payload)
+					_ = publish.Write(conn)
+				}
+			}
+		case *packets.PingreqPacket:
+			_ = packets.NewControlPacket(packets.Pingresp).Write(conn)
+		case *packets.DisconnectPacket:
+			return
+		}
+	}
+}
+
+func (b *fakeBroker) printRequests() []map[string]any {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var requests []map[string]any
+	for _, request := range b.requests {
+		if print, ok := request["print"].(map[string]any); ok {
+			requests = append(requests, print)
+		}
+	}
+	return requests
+}
+
+func newTestBambuDriver(t *testing.T, accessCode string, broker *fakeBroker, ftps *fakeFTPS) *BambuDriver {
+	t.Helper()
+	driver, err := NewBambuDriver("127.0.0.1", "01S00TEST", accessCode)
+	if err != nil {
+		t.Fatalf("NewBambuDriver: %v", err)
+	}
+	if broker != nil {
+		driver.mqttPort = broker.port()
+	}
+	if ftps != nil {
+		driver.ftpsPort = ftps.port()
+	}
+	return driver
+}
+
+func TestBambuUpload(t *testing.T) {
+	ftps := newFakeFTPS(t, "12345678")
+	driver := newTestBambuDriver(t, "12345678", nil, ftps)
+
+	content := bytes.Repeat([]byte("PK\x03\x04 sliced project "), 10000)
+	if err := driver.Upload(context.Background(), "benchy.gcode.3mf", bytes.NewReader(content)); err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	stored, ok := ftps.file("benchy.gcode.3mf")
+	if !ok || !bytes.Equal(stored, content) {
+		t.Fatalf("stored %d bytes, want the %d uploaded", len(stored), len(content))
+	}
+
+	ftps.mu.Lock()
+	commands := strings.Join(ftps.commands, " ")
+	ftps.mu.Unlock()
+	if !strings.Contains(commands, "PBSZ PROT TYPE PASV STOR") {
+		t.Errorf("commands = %s, want the data channel protected before the transfer", commands)
+	}
+}
+
+func TestBambuUploadWrongAccessCode(t *testing.T) {
+	ftps := newFakeFTPS(t, "12345678")
+	driver := newTestBambuDriver(t, "wrong", nil, ftps)
+
+	err := driver.Upload(context.Background(), "benchy.gcode.3mf", strings.NewReader("x"))
+	if err == nil || !strings.Contains(err.Error(), "login failed") {
+		t.Fatalf("Upload with a wrong access code = %v, want a login error", err)
+	}
+}
+
+func TestBambuJobControl(t *testing.T) {
+	broker := newFakeBroker(t, "01S00TEST", "12345678")
+	driver := newTestBambuDriver(t, "12345678", broker, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := driver.Start(ctx, "benchy.gcode"); err == nil {
+		t.Error("Start of a plain .gcode file succeeded, bambu printers only print .gcode.3mf projects")
+	}
+	if err := driver.Start(ctx, "benchy.gcode.3mf"); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	for name, command := range map[string]func(context.Context) error{
+		"Pause": driver.Pause, "Resume": driver.Resume, "Cancel": driver.Cancel,
+	} {
+		if err := command(ctx); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+	}
+
+	// Publishing returns once the broker acknowledged the request, so every request has been recorded
+	requests := broker.printRequests()
+	if len(requests) != 4 {
+		t.Fatalf("broker received %d print requests, want 4: %v", len(requests), requests)
+	}
+	start := requests[0]
+	if start["command"] != "project_file" || start["url"] != "file:///sdcard/benchy.gcode.3mf" || start["param"] != "Metadata/plate_1.gcode" {
+		t.Errorf("start request = %v", start)
+	}
+	commands := map[string]bool{}
+	for _, request := range requests[1:] {
+		commands[request["command"].(string)] = true
+	}
+	for _, command := range []string{"pause", "resume", "stop"} {
+		if !commands[command] {
+			t.Errorf("no %s request was sent: %v", command, requests[1:])
+		}
+	}
+}
+
+func TestBambuStatus(t *testing.T) {
+	tests := []struct {
+		report string
+		want   JobStatus
+	}{
+		{`{"print":{"gcode_state":"IDLE","mc_percent":0,"subtask_name":""}}`, JobStatus{State: JobIdle, Message: "IDLE"}},
+		{`{"print":{"gcode_state":"PREPARE","mc_percent":0,"subtask_name":"a.gcode.3mf"}}`, JobStatus{State: JobPrinting, FileName: "a.gcode.3mf", Message: "PREPARE"}},
+		{`{"print":{"gcode_state":"RUNNING","mc_percent":42,"subtask_name":"a.gcode.3mf"}}`, JobStatus{State: JobPrinting, Progress: 42, FileName: "a.gcode.3mf", Message: "RUNNING"}},
+		{`{"print":{"gcode_state":"PAUSE","mc_percent":50,"subtask_name":"a.gcode.3mf"}}`, JobStatus{State: JobPaused, Progress: 50, FileName: "a.gcode.3mf", Message: "PAUSE"}},
+		{`{"print":{"gcode_state":"FINISH","mc_percent":99,"subtask_name":"a.gcode.3mf"}}`, JobStatus{State: JobCompleted, Progress: 100, FileName: "a.gcode.3mf", Message: "FINISH"}},
+		{`{"print":{"gcode_state":"FAILED","mc_percent":30,"subtask_name":"a.gcode.3mf","print_error":50348044}}`, JobStatus{State: JobFailed, Progress: 30, FileName: "a.gcode.3mf", Message: "printer reported error 50348044"}},
+		{`{"print":{"gcode_state":"RUNNING","mc_percent":250,"subtask_name":"a.gcode.3mf"}}`, JobStatus{State: JobPrinting, Progress: 100, FileName: "a.gcode.3mf", Message: "RUNNING"}},
+	}
+
+	broker := newFakeBroker(t, "01S00TEST", "12345678")
+	driver := newTestBambuDriver(t, "12345678", broker, nil)
+	for _, tt := range tests {
+		broker.mu.Lock()
+		broker.report = tt.report
+		broker.mu.Unlock()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		status, err := driver.Status(ctx)
+		cancel()
+		if err != nil {
+			t.Fatalf("Status(%s): %v", tt.report, err)
+		}
+		if *status != tt.want {
+			t.Errorf("Status(%s) = %+v, want %+v", tt.report, *status, tt.want)
+		}
+	}
+}
+
+func TestBambuStatusWrongAccessCode(t *testing.T) {
+	broker := newFakeBroker(t, "01S00TEST", "12345678")
+	driver := newTestBambuDriver(t, "wrong", broker, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err := driver.Status(ctx); err == nil || !strings.Contains(err.Error(), "mqtt connect failed") {
+		t.Fatalf("Status with a wrong access code = %v, want a connect error", err)
+	}
+}
@@ -0,0 +1,90 @@
+package drivers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/torbenconto/spooler/internal/models"
+)
+
+type JobState string
+
+const (
+	JobIdle      JobState = "idle"
+	JobPrinting  JobState = "printing"
+	JobPaused    JobState = "paused"
+	JobCompleted JobState = "completed"
+	JobFailed    JobState = "failed"
+	JobCanceled  JobState = "canceled"
+)
+
+// JobStatus is the state of the job currently loaded on a printer as reported by the printer itself
+type JobStatus struct {
+	State JobState
+	// Progress is the completion of the job from 0 to 100
+	Progress int
+	// FileName is the name of the file the printer is working on, empty when the printer does not report it
+	FileName string
+	Message  string
+}
+
+// Driver is implemented by every printer integration spooler can dispatch jobs to
+type Driver interface {
+	Upload(ctx context.Context, fileName string, file io.Reader) error
+	Start(ctx context.Context, fileName string) error
+	Pause(ctx context.Context) error
+	Resume(ctx context.Context) error
+	Cancel(ctx context.Context) error
+	Status(ctx context.Context) (*JobStatus, error)
+}
+
+// NewDriver builds the driver configured for a printer. Printers without a driver are operated manually and return a nil Driver.
+func NewDriver(printer *models.Printer) (Driver, error) {
+	switch printer.Driver {
+	case models.DriverNone:
+		return nil, nil
+	case models.DriverOctoPrint:
+		return NewOctoPrintDriver(printer.Address, printer.APIKey)
+	case models.DriverMoonraker:
+		return NewMoonrakerDriver(printer.Address, printer.APIKey)
+	case models.DriverBambu:
+		return NewBambuDriver(printer.Address, printer.SerialNumber, printer.APIKey)
+	default:
+		return nil, fmt.Errorf("invalid printer driver: %s", printer.Driver)
+	}
+}
+
+// Accepts reports whether printers with the given driver can start a file of this name. Bambu printers only print sliced
+// projects, OctoPrint and Moonraker plain G-code. Printers without a driver are started by hand and accept anything.
+func Accepts(driver models.PrinterDriver, fileName string) bool {
+	name := strings.ToLower(fileName)
+	switch driver {
+	case models.DriverNone:
+		return true
+	case models.DriverOctoPrint, models.DriverMoonraker:
+		return strings.HasSuffix(name, ".gcode")
+	case models.DriverBambu:
+		return strings.HasSuffix(name, ".gcode.3mf")
+	default:
+		return false
+	}
+}
+
+// Printable reports whether a file is sliced and can be sent to a printer as is, raw models have to be sliced first
+func Printable(fileName string) bool {
+	name := strings.ToLower(fileName)
+	return strings.HasSuffix(name, ".gcode") || strings.HasSuffix(name, ".gcode.3mf")
+}
+
+func clampProgress(progress float64) int {
+	switch {
+	case progress < 0:
+		return 0
+	case progress > 100:
+		return 100
+	default:
+		return int(progress)
+	}
+}
@@ -0,0 +1,126 @@
+package drivers
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// ftpsClient is a minimal implicit TLS FTP client, just enough to store a file on a printer's SD card
+type ftpsClient struct {
+	conn      net.Conn
+	text      *textproto.Conn
+	host      string
+	tlsConfig *tls.Config
+}
+
+func dialFTPS(ctx context.Context, host string, port int, tlsConfig *tls.Config) (*ftpsClient, error) {
+	dialer := &tls.Dialer{Config: tlsConfig}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+	if err != nil {
+		return nil, err
+	}
+
+	c := &ftpsClient{
+		conn:      conn,
+		text:      textproto.NewConn(conn),
+		host:      host,
+		tlsConfig: tlsConfig,
+	}
+
+	if _, _, err := c.text.ReadResponse(220); err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (c *ftpsClient) cmd(expectCode int, format string, args ...any) (string, error) {
+	if _, err := c.text.Cmd(format, args...); err != nil {
+		return "", err
+	}
+	_, msg, err := c.text.ReadResponse(expectCode)
+	return msg, err
+}
+
+func (c *ftpsClient) Login(user string, password string) error {
+	if _, err := c.cmd(331, "USER %s", user); err != nil {
+		return err
+	}
+	if _, err := c.cmd(230, "PASS %s", password); err != nil {
+		return err
+	}
+	// Protect the data channel as well, printers refuse plain transfers
+	if _, err := c.cmd(200, "PBSZ 0"); err != nil {
+		return err
+	}
+	if _, err := c.cmd(200, "PROT P"); err != nil {
+		return err
+	}
+	_, err := c.cmd(200, "TYPE I")
+	return err
+}
+
+// pasv opens a passive data connection. The address announced by the server is ignored in favour of the control host since printers often announce an unroutable one.
+func (c *ftpsClient) pasv(ctx context.Context) (net.Conn, error) {
+	msg, err := c.cmd(227, "PASV")
+	if err != nil {
+		return nil, err
+	}
+
+	start, end := strings.Index(msg, "("), strings.Index(msg, ")")
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("invalid PASV response: %s", msg)
+	}
+	parts := strings.Split(msg[start+1:end], ",")
+	if len(parts) != 6 {
+		return nil, fmt.Errorf("invalid PASV response: %s", msg)
+	}
+	hi, err1 := strconv.Atoi(strings.TrimSpace(parts[4]))
+	lo, err2 := strconv.Atoi(strings.TrimSpace(parts[5]))
+	if err1 != nil || err2 != nil {
+		return nil, fmt.Errorf("invalid PASV port: %s", msg)
+	}
+
+	// The data connection must resume the control connection's TLS session, which the shared session cache takes care of
+	dialer := &tls.Dialer{Config: c.tlsConfig}
+	return dialer.DialContext(ctx, "tcp", net.JoinHostPort(c.host, strconv.Itoa(hi<<8|lo)))
+}
+
+func (c *ftpsClient) Store(ctx context.Context, fileName string, file io.Reader) error {
+	data, err := c.pasv(ctx)
+	if err != nil {
+		return err
+	}
+
+	if _, err := c.text.Cmd("STOR %s", fileName); err != nil {
+		data.Close()
+		return err
+	}
+	if _, _, err := c.text.ReadResponse(1); err != nil {
+		data.Close()
+		return err
+	}
+
+	if _, err := io.Copy(data, file); err != nil {
+		data.Close()
+		return err
+	}
+	if err := data.Close(); err != nil {
+		return err
+	}
+
+	_, _, err = c.text.ReadResponse(226)
+	return err
+}
+
+func (c *ftpsClient) Close() error {
+	_, _ = c.text.Cmd("QUIT")
+	return c.conn.Close()
+}
@@ -0,0 +1,168 @@
+package drivers
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testTLSConfig returns a server config with a fresh self signed certificate, like the ones printers use
+func testTLSConfig(t *testing.T) *tls.Config {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "printer"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+}
+
+// fakeFTPS is an implicit TLS FTP server that accepts a single login and keeps stored files in memory
+type fakeFTPS struct {
+	password  string
+	tlsConfig *tls.Config
+	listener  net.Listener
+
+	mu       sync.Mutex
+	files    map[string][]byte
+	commands []string
+}
+
+func newFakeFTPS(t *testing.T, password string) *fakeFTPS {
+	t.Helper()
+	f := &fakeFTPS{password: password, tlsConfig: testTLSConfig(t), files: make(map[string][]byte)}
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", f.tlsConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.listener = listener
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeFTPS) port() int {
+	return f.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (f *fakeFTPS) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	reply := func(format string, args ...any) {
+		fmt.Fprintf(conn, format+"\r\n", args...)
+	}
+
+	// received carries what was sent over the data connection of the last PASV. The connection is accepted right away,
+	// the client completes the TLS handshake before it sends STOR.
+	var received chan []byte
+
+	reply("220 fake printer ready")
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		command, arg, _ := strings.Cut(strings.TrimRight(line, "\r\n"), " ")
+		f.mu.Lock()
+		f.commands = append(f.commands, command)
+		f.mu.Unlock()
+
+		switch command {
+		case "USER":
+			reply("331 password required")
+		case "PASS":
+			if arg != f.password {
+				reply("530 login incorrect")
+				continue
+			}
+			reply("230 logged in")
+		case "PBSZ", "PROT", "TYPE":
+			reply("200 ok")
+		case "PASV":
+			data, err := tls.Listen("tcp", "127.0.0.1:0", f.tlsConfig)
+			if err != nil {
+				reply("425 cannot open data connection")
+				continue
+			}
+			received = make(chan []byte, 1)
+			go func(received chan<- []byte) {
+				defer data.Close()
+				dataConn, err := data.Accept()
+				if err != nil {
+					close(received)
+					return
+				}
+				defer dataConn.Close()
+				content, err := io.ReadAll(dataConn)
+				if err != nil {
+					close(received)
+					return
+				}
+				received <- content
+			}(received)
+			port := data.Addr().(*net.TCPAddr).Port
+			// Printers announce addresses the client cannot reach, the client has to use the control host instead
+			reply("227 Entering Passive Mode (10,255,255,1,%d,%d)", port>>8, port&0xff)
+		case "STOR":
+			if received == nil {
+				reply("425 use PASV first")
+				continue
+			}
+			reply("150 opening data connection")
+			content, ok := <-received
+			received = nil
+			if !ok {
+				reply("426 transfer aborted")
+				continue
+			}
+			f.mu.Lock()
+			f.files[arg] = content
+			f.mu.Unlock()
+			reply("226 transfer complete")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
+
+func (f *fakeFTPS) file(name string) ([]byte, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	content, ok := f.files[name]
+	return content, ok
+}
@@ -0,0 +1,91 @@
+package drivers
+
+import (
+	"context"
+	"io"
+	"strings"
+)
+
+// MoonrakerDriver talks to a Klipper printer through the Moonraker API server
+type MoonrakerDriver struct {
+	api *restClient
+}
+
+func NewMoonrakerDriver(address string, apiKey string) (*MoonrakerDriver, error) {
+	api, err := newRESTClient("moonraker", address, apiKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MoonrakerDriver{api: api}, nil
+}
+
+func (m *MoonrakerDriver) Upload(ctx context.Context, fileName string, file io.Reader) error {
+	return m.api.uploadMultipart(ctx, m.api.endpoint("/server/files/upload"), fileName, file, map[string]string{
+		"root": "gcodes",
+	})
+}
+
+func (m *MoonrakerDriver) Start(ctx context.Context, fileName string) error {
+	return m.api.postJSON(ctx, m.api.endpoint("/printer/print/start"), map[string]string{"filename": fileName})
+}
+
+func (m *MoonrakerDriver) Pause(ctx context.Context) error {
+	return m.api.postJSON(ctx, m.api.endpoint("/printer/print/pause"), nil)
+}
+
+func (m *MoonrakerDriver) Resume(ctx context.Context) error {
+	return m.api.postJSON(ctx, m.api.endpoint("/printer/print/resume"), nil)
+}
+
+func (m *MoonrakerDriver) Cancel(ctx context.Context) error {
+	return m.api.postJSON(ctx, m.api.endpoint("/printer/print/cancel"), nil)
+}
+
+type moonrakerQueryResponse struct {
+	Result struct {
+		Status struct {
+			PrintStats struct {
+				State    string `json:"state"`
+				Filename string `json:"filename"`
+				Message  string `json:"message"`
+			} `json:"print_stats"`
+			VirtualSDCard struct {
+				Progress float64 `json:"progress"`
+			} `json:"virtual_sdcard"`
+		} `json:"status"`
+	} `json:"result"`
+}
+
+func (m *MoonrakerDriver) Status(ctx context.Context) (*JobStatus, error) {
+	var query moonrakerQueryResponse
+	if err := m.api.get(ctx, m.api.endpoint("/printer/objects/query")+"?print_stats&virtual_sdcard", &query); err != nil {
+		return nil, err
+	}
+
+	stats := query.Result.Status.PrintStats
+	status := &JobStatus{
+		// print_stats reports the path relative to the gcodes root
+		FileName: stats.Filename[strings.LastIndex(stats.Filename, "/")+1:],
+		Progress: clampProgress(query.Result.Status.VirtualSDCard.Progress * 100),
+		Message:  stats.Message,
+	}
+
+	switch stats.State {
+	case "printing":
+		status.State = JobPrinting
+	case "paused":
+		status.State = JobPaused
+	case "complete":
+		status.State = JobCompleted
+		status.Progress = 100
+	case "cancelled":
+		status.State = JobCanceled
+	case "error":
+		status.State = JobFailed
+	default:
+		status.State = JobIdle
+	}
+
+	return status, nil
+}
@@ -0,0 +1,157 @@
+package drivers
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeMoonraker serves the parts of the Moonraker API the driver uses
+type fakeMoonraker struct {
+	mu        sync.Mutex
+	files     map[string]string
+	requests  []string
+	started   string
+	queryArgs string
+	status    string
+}
+
+func newFakeMoonraker(t *testing.T) (*fakeMoonraker, *MoonrakerDriver) {
+	t.Helper()
+	fake := &fakeMoonraker{files: make(map[string]string)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /server/files/upload", func(w http.ResponseWriter, r *http.Request) {
+		if root := r.FormValue("root"); root != "gcodes" {
+			http.Error(w, "invalid root "+root, http.StatusBadRequest)
+			return
+		}
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		content, _ := io.ReadAll(file)
+		fake.mu.Lock()
+		fake.files[header.Filename] = string(content)
+		fake.mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+	})
+	mux.HandleFunc("POST /printer/print/start", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Filename string `json:"filename"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+		if _, ok := fake.files[body.Filename]; !ok {
+			http.Error(w, "file not found", http.StatusNotFound)
+			return
+		}
+		fake.started = body.Filename
+		fake.requests = append(fake.requests, r.URL.Path)
+	})
+	for _, path := range []string{"/printer/print/pause", "/printer/print/resume", "/printer/print/cancel"} {
+		mux.HandleFunc("POST "+path, func(w http.ResponseWriter, r *http.Request) {
+			fake.mu.Lock()
+			defer fake.mu.Unlock()
+			fake.requests = append(fake.requests, r.URL.Path)
+		})
+	}
+	mux.HandleFunc("GET /printer/objects/query", func(w http.ResponseWriter, r *http.Request) {
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+		fake.queryArgs = r.URL.RawQuery
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, fake.status)
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	driver, err := NewMoonrakerDriver(server.URL, "")
+	if err != nil {
+		t.Fatalf("NewMoonrakerDriver: %v", err)
+	}
+	return fake, driver
+}
+
+func TestMoonrakerJobControl(t *testing.T) {
+	fake, driver := newFakeMoonraker(t)
+	ctx := context.Background()
+
+	if err := driver.Upload(ctx, "benchy.gcode", strings.NewReader("G28\n")); err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	if got := fake.files["benchy.gcode"]; got != "G28\n" {
+		t.Fatalf("uploaded content = %q, want %q", got, "G28\n")
+	}
+	if err := driver.Start(ctx, "benchy.gcode"); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if fake.started != "benchy.gcode" {
+		t.Errorf("started %q, want benchy.gcode", fake.started)
+	}
+	for name, command := range map[string]func(context.Context) error{
+		"Pause": driver.Pause, "Resume": driver.Resume, "Cancel": driver.Cancel,
+	} {
+		if err := command(ctx); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+	}
+	if len(fake.requests) != 4 {
+		t.Errorf("sent %v, want start, pause, resume and cancel", fake.requests)
+	}
+
+	if err := driver.Start(ctx, "missing.gcode"); err == nil {
+		t.Error("Start of a file that was never uploaded succeeded")
+	}
+}
+
+func TestMoonrakerStatus(t *testing.T) {
+	tests := []struct {
+		state    string
+		filename string
+		progress string
+		want     JobStatus
+	}{
+		{"standby", "", "0", JobStatus{State: JobIdle}},
+		{"printing", "benchy.gcode", "0.425", JobStatus{State: JobPrinting, FileName: "benchy.gcode", Progress: 42}},
+		// print_stats reports the path below the gcodes root, jobs are matched by the uploaded name
+		{"printing", "spooler/queue/benchy.gcode", "0.5", JobStatus{State: JobPrinting, FileName: "benchy.gcode", Progress: 50}},
+		{"paused", "benchy.gcode", "0.5", JobStatus{State: JobPaused, FileName: "benchy.gcode", Progress: 50}},
+		{"complete", "benchy.gcode", "0.998", JobStatus{State: JobCompleted, FileName: "benchy.gcode", Progress: 100}},
+		{"cancelled", "benchy.gcode", "0.3", JobStatus{State: JobCanceled, FileName: "benchy.gcode", Progress: 30}},
+		{"error", "benchy.gcode", "0.3", JobStatus{State: JobFailed, FileName: "benchy.gcode", Progress: 30, Message: "MCU shutdown"}},
+	}
+
+	fake, driver := newFakeMoonraker(t)
+	for _, tt := range tests {
+		message := ""
+		if tt.state == "error" {
+			message = "MCU shutdown"
+		}
+		fake.status = `{"result":{"status":{"print_stats":{"state":"` + tt.state + `","filename":"` + tt.filename +
+			`","message":"` + message + `"},"virtual_sdcard":{"progress":` + tt.progress + `}}}}`
+
+		status, err := driver.Status(context.Background())
+		if err != nil {
+			t.Fatalf("Status(%s): %v", tt.state, err)
+		}
+		if *status != tt.want {
+			t.Errorf("Status(%s, %s) = %+v, want %+v", tt.state, tt.filename, *status, tt.want)
+		}
+	}
+
+	if fake.queryArgs != "print_stats&virtual_sdcard" {
+		t.Errorf("queried %q, want print_stats&virtual_sdcard", fake.queryArgs)
+	}
+}
@@ -0,0 +1,100 @@
+package drivers
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"strings"
+)
+
+// OctoPrintDriver talks to an OctoPrint instance through its REST API
+type OctoPrintDriver struct {
+	api *restClient
+}
+
+func NewOctoPrintDriver(address string, apiKey string) (*OctoPrintDriver, error) {
+	api, err := newRESTClient("octoprint", address, apiKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &OctoPrintDriver{api: api}, nil
+}
+
+func (o *OctoPrintDriver) Upload(ctx context.Context, fileName string, file io.Reader) error {
+	return o.api.uploadMultipart(ctx, o.api.endpoint("/api/files/local"), fileName, file, nil)
+}
+
+func (o *OctoPrintDriver) Start(ctx context.Context, fileName string) error {
+	return o.api.postJSON(ctx, o.api.endpoint("/api/files/local/"+url.PathEscape(fileName)), map[string]any{
+		"command": "select",
+		"print":   true,
+	})
+}
+
+func (o *OctoPrintDriver) Pause(ctx context.Context) error {
+	return o.api.postJSON(ctx, o.api.endpoint("/api/job"), map[string]string{"command": "pause", "action": "pause"})
+}
+
+func (o *OctoPrintDriver) Resume(ctx context.Context) error {
+	return o.api.postJSON(ctx, o.api.endpoint("/api/job"), map[string]string{"command": "pause", "action": "resume"})
+}
+
+func (o *OctoPrintDriver) Cancel(ctx context.Context) error {
+	return o.api.postJSON(ctx, o.api.endpoint("/api/job"), map[string]string{"command": "cancel"})
+}
+
+type octoPrintJobResponse struct {
+	Job struct {
+		File struct {
+			Name string `json:"name"`
+		} `json:"file"`
+	} `json:"job"`
+	Progress struct {
+		Completion *float64 `json:"completion"`
+	} `json:"progress"`
+	State string `json:"state"`
+	Error string `json:"error"`
+}
+
+func (o *OctoPrintDriver) Status(ctx context.Context) (*JobStatus, error) {
+	var job octoPrintJobResponse
+	if err := o.api.get(ctx, o.api.endpoint("/api/job"), &job); err != nil {
+		return nil, err
+	}
+
+	status := &JobStatus{
+		FileName: job.Job.File.Name,
+		Message:  job.State,
+	}
+	if job.Progress.Completion != nil {
+		status.Progress = clampProgress(*job.Progress.Completion)
+	}
+
+	// OctoPrint's state strings are human readable and may carry extra detail, e.g. "Offline after error"
+	state := strings.ToLower(job.State)
+	switch {
+	case strings.HasPrefix(state, "printing"), strings.HasPrefix(state, "starting"), strings.HasPrefix(state, "resuming"), strings.HasPrefix(state, "finishing"):
+		status.State = JobPrinting
+	case strings.HasPrefix(state, "paus"):
+		status.State = JobPaused
+	case strings.HasPrefix(state, "cancelling"):
+		status.State = JobCanceled
+	case strings.Contains(state, "error"):
+		status.State = JobFailed
+		if job.Error != "" {
+			status.Message = job.Error
+		}
+	case strings.HasPrefix(state, "operational"):
+		// A finished job stays selected with full completion once the printer is back to operational
+		if status.FileName != "" && status.Progress >= 100 {
+			status.State = JobCompleted
+		} else {
+			status.State = JobIdle
+		}
+	default:
+		status.State = JobIdle
+	}
+
+	return status, nil
+}
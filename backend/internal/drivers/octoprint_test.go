@@ -0,0 +1,171 @@
+package drivers
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeOctoPrint serves the parts of the OctoPrint REST API the driver uses
+type fakeOctoPrint struct {
+	mu       sync.Mutex
+	files    map[string]string
+	commands []map[string]any
+	job      string
+}
+
+func newFakeOctoPrint(t *testing.T) (*fakeOctoPrint, *OctoPrintDriver) {
+	t.Helper()
+	fake := &fakeOctoPrint{files: make(map[string]string)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/files/local", func(w http.ResponseWriter, r *http.Request) {
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		content, _ := io.ReadAll(file)
+		fake.mu.Lock()
+		fake.files[header.Filename] = string(content)
+		fake.mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+	})
+	mux.HandleFunc("POST /api/files/local/{name}", func(w http.ResponseWriter, r *http.Request) {
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+		if _, ok := fake.files[r.PathValue("name")]; !ok {
+			http.Error(w, "file not found", http.StatusNotFound)
+			return
+		}
+		fake.record(w, r)
+	})
+	mux.HandleFunc("POST /api/job", func(w http.ResponseWriter, r *http.Request) {
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+		fake.record(w, r)
+	})
+	mux.HandleFunc("GET /api/job", func(w http.ResponseWriter, r *http.Request) {
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, fake.job)
+	})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Api-Key") != "secret" {
+			http.Error(w, "invalid api key", http.StatusForbidden)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	driver, err := NewOctoPrintDriver(server.URL, "secret")
+	if err != nil {
+		t.Fatalf("NewOctoPrintDriver: %v", err)
+	}
+	return fake, driver
+}
+
+func (f *fakeOctoPrint) record(w http.ResponseWriter, r *http.Request) {
+	var command map[string]any
+	if err := json.NewDecoder(r.Body).Decode(&command); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	command["path"] = r.URL.Path
+	f.commands = append(f.commands, command)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func TestOctoPrintJobControl(t *testing.T) {
+	fake, driver := newFakeOctoPrint(t)
+	ctx := context.Background()
+
+	if err := driver.Upload(ctx, "benchy print.gcode", strings.NewReader("G28\n")); err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	if got := fake.files["benchy print.gcode"]; got != "G28\n" {
+		t.Fatalf("uploaded content = %q, want %q", got, "G28\n")
+	}
+
+	if err := driver.Start(ctx, "benchy print.gcode"); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if err := driver.Pause(ctx); err != nil {
+		t.Fatalf("Pause: %v", err)
+	}
+	if err := driver.Resume(ctx); err != nil {
+		t.Fatalf("Resume: %v", err)
+	}
+	if err := driver.Cancel(ctx); err != nil {
+		t.Fatalf("Cancel: %v", err)
+	}
+
+	want := []map[string]any{
+		{"path": "/api/files/local/benchy print.gcode", "command": "select", "print": true},
+		{"path": "/api/job", "command": "pause", "action": "pause"},
+		{"path": "/api/job", "command": "pause", "action": "resume"},
+		{"path": "/api/job", "command": "cancel"},
+	}
+	if len(fake.commands) != len(want) {
+		t.Fatalf("sent %d commands, want %d: %v", len(fake.commands), len(want), fake.commands)
+	}
+	for i, command := range want {
+		for key, value := range command {
+			if fake.commands[i][key] != value {
+				t.Errorf("command %d: %s = %v, want %v", i, key, fake.commands[i][key], value)
+			}
+		}
+	}
+
+	if err := driver.Start(ctx, "missing.gcode"); err == nil {
+		t.Error("Start of a file that was never uploaded succeeded")
+	}
+}
+
+func TestOctoPrintRejectedAPIKey(t *testing.T) {
+	_, driver := newFakeOctoPrint(t)
+	driver.api.apiKey = "wrong"
+
+	if _, err := driver.Status(context.Background()); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Fatalf("Status with a wrong api key = %v, want a 403 error", err)
+	}
+}
+
+func TestOctoPrintStatus(t *testing.T) {
+	tests := []struct {
+		job      string
+		state    JobState
+		progress int
+		message  string
+	}{
+		{`{"job":{"file":{"name":null}},"progress":{"completion":null},"state":"Operational"}`, JobIdle, 0, "Operational"},
+		{`{"job":{"file":{"name":"a.gcode"}},"progress":{"completion":42.7},"state":"Printing"}`, JobPrinting, 42, "Printing"},
+		{`{"job":{"file":{"name":"a.gcode"}},"progress":{"completion":10},"state":"Starting print from SD"}`, JobPrinting, 10, "Starting print from SD"},
+		{`{"job":{"file":{"name":"a.gcode"}},"progress":{"completion":50},"state":"Pausing"}`, JobPaused, 50, "Pausing"},
+		{`{"job":{"file":{"name":"a.gcode"}},"progress":{"completion":50},"state":"Paused"}`, JobPaused, 50, "Paused"},
+		{`{"job":{"file":{"name":"a.gcode"}},"progress":{"completion":50},"state":"Cancelling"}`, JobCanceled, 50, "Cancelling"},
+		{`{"job":{"file":{"name":"a.gcode"}},"progress":{"completion":50},"state":"Offline after error","error":"Thermal runaway"}`, JobFailed, 50, "Thermal runaway"},
+		{`{"job":{"file":{"name":"a.gcode"}},"progress":{"completion":100},"state":"Operational"}`, JobCompleted, 100, "Operational"},
+		{`{"job":{"file":{"name":"a.gcode"}},"progress":{"completion":130},"state":"Finishing"}`, JobPrinting, 100, "Finishing"},
+	}
+
+	fake, driver := newFakeOctoPrint(t)
+	for _, tt := range tests {
+		fake.job = tt.job
+		status, err := driver.Status(context.Background())
+		if err != nil {
+			t.Fatalf("Status(%s): %v", tt.job, err)
+		}
+		if status.State != tt.state || status.Progress != tt.progress || status.Message != tt.message {
+			t.Errorf("Status(%s) = %s %d%% %q, want %s %d%% %q", tt.job, status.State, status.Progress, status.Message, tt.state, tt.progress, tt.message)
+		}
+	}
+}
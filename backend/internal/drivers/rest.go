@@ -0,0 +1,138 @@
+package drivers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// restClient holds the HTTP plumbing shared by drivers that talk to a JSON API on the printer host
+type restClient struct {
+	name    string
+	baseURL *url.URL
+	apiKey  string
+	client  *http.Client
+}
+
+func newRESTClient(name string, address string, apiKey string) (*restClient, error) {
+	if address == "" {
+		return nil, fmt.Errorf("%s address is empty", name)
+	}
+	if !strings.Contains(address, "://") {
+		address = "http://" + address
+	}
+
+	baseURL, err := url.Parse(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s address: %w", name, err)
+	}
+
+	return &restClient{
+		name:    name,
+		baseURL: baseURL,
+		apiKey:  apiKey,
+		// No client timeout, uploads can take a long time so deadlines are controlled through the request context
+		client: &http.Client{},
+	}, nil
+}
+
+func (r *restClient) endpoint(path string) string {
+	return r.baseURL.JoinPath(path).String()
+}
+
+func (r *restClient) do(req *http.Request, out any) error {
+	if r.apiKey != "" {
+		req.Header.Set("X-Api-Key", r.apiKey)
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s returned %s: %s", r.name, resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (r *restClient) get(ctx context.Context, path string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return err
+	}
+	return r.do(req, out)
+}
+
+// postJSON posts body encoded as JSON, a nil body sends an empty request
+func (r *restClient) postJSON(ctx context.Context, path string, body any) error {
+	var payload io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		payload = bytes.NewReader(encoded)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, path, payload)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	return r.do(req, nil)
+}
+
+// uploadMultipart streams file as a multipart form upload without buffering it in memory
+func (r *restClient) uploadMultipart(ctx context.Context, path string, fileName string, file io.Reader, fields map[string]string) error {
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+
+	go func() {
+		for key, value := range fields {
+			if err := mw.WriteField(key, value); err != nil {
+				_ = pw.CloseWithError(err)
+				return
+			}
+		}
+
+		part, err := mw.CreateFormFile("file", fileName)
+		if err != nil {
+			_ = pw.CloseWithError(err)
+			return
+		}
+		if _, err := io.Copy(part, file); err != nil {
+			_ = pw.CloseWithError(err)
+			return
+		}
+		_ = pw.CloseWithError(mw.Close())
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, path, pr)
+	if err != nil {
+		_ = pr.Close()
+		return err
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+
+	if err := r.do(req, nil); err != nil {
+		_ = pr.CloseWithError(err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,491 @@
+package gcode
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/torbenconto/spooler/internal/models"
+)
+
+var ErrInvalidGCode = errors.New("invalid gcode file")
+
+const (
+	// Used to turn filament length into grams when the slicer does not report a weight
+	defaultFilamentDiameter = 1.75 // mm
+
+	defaultFeedrate = 3000.0 // mm/min
+	maxLineLength   = 4 * 1024 * 1024
+)
+
+// Stats is what is known about a sliced G-code file. Values the slicer reports in its comments are preferred,
+// anything missing is estimated by simulating the moves in the file.
+type Stats struct {
+	Slicer         string
+	PrinterProfile string
+
+	EstimatedTime  time.Duration
+	FilamentLength float64 // mm
+	FilamentWeight float64 // g
+	LayerHeight    float64 // mm
+	LayerCount     int
+	NozzleTemp     float64 // °C
+	BedTemp        float64 // °C
+
+	// Simulated is set when the print time or filament usage were estimated from the moves rather than read from the slicer
+	Simulated bool
+}
+
+// metadata holds the values read from slicer comments
+type metadata struct {
+	slicer         string
+	printerModel   string
+	printerProfile string
+
+	time             time.Duration
+	filamentLength   float64
+	filamentWeight   float64
+	filamentDiameter float64
+	filamentDensity  float64
+	layerHeight      float64
+	layerCount       int
+	nozzleTemp       float64
+	bedTemp          float64
+}
+
+// Analyze reads a G-code file, collecting the header and footer comments written by PrusaSlicer, OrcaSlicer, Bambu Studio and Cura
+// while simulating the moves in the file
+func Analyze(r io.Reader) (*Stats, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxLineLength)
+
+	var (
+		meta     metadata
+		sim      = newSimulator()
+		commands int
+	)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		code, comment, _ := strings.Cut(line, ";")
+		if comment != "" {
+			meta.parseComment(strings.TrimSpace(comment))
+		}
+
+		code = strings.TrimSpace(code)
+		if code == "" {
+			continue
+		}
+		sim.execute(code)
+		commands++
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if commands == 0 {
+		return nil, fmt.Errorf("%w: file contains no commands", ErrInvalidGCode)
+	}
+
+	return meta.stats(sim), nil
+}
+
+func (m *metadata) stats(sim *simulator) *Stats {
+	stats := &Stats{
+		Slicer:         m.slicer,
+		PrinterProfile: m.printerModel,
+		EstimatedTime:  m.time,
+		FilamentLength: m.filamentLength,
+		FilamentWeight: m.filamentWeight,
+		LayerHeight:    m.layerHeight,
+		LayerCount:     m.layerCount,
+		NozzleTemp:     m.nozzleTemp,
+		BedTemp:        m.bedTemp,
+	}
+	if stats.PrinterProfile == "" {
+		stats.PrinterProfile = m.printerProfile
+	}
+
+	if stats.EstimatedTime == 0 {
+		stats.EstimatedTime = sim.duration()
+		stats.Simulated = true
+	}
+	if stats.FilamentLength == 0 {
+		stats.FilamentLength = math.Max(sim.extruded, 0)
+		stats.Simulated = true
+	}
+	if stats.FilamentWeight == 0 && stats.FilamentLength > 0 {
+		diameter, density := m.filamentDiameter, m.filamentDensity
+		if diameter == 0 {
+			diameter = defaultFilamentDiameter
+		}
+		if density == 0 {
+			density = models.DefaultMaterialDensity
+		}
+		// mm³ to cm³
+		stats.FilamentWeight = stats.FilamentLength * math.Pi * (diameter / 2) * (diameter / 2) / 1000 * density
+	}
+
+	layerHeight, layerCount := sim.layers()
+	if stats.LayerHeight == 0 {
+		stats.LayerHeight = layerHeight
+	}
+	if stats.LayerCount == 0 {
+		stats.LayerCount = layerCount
+	}
+	if stats.NozzleTemp == 0 {
+		stats.NozzleTemp = sim.nozzleTemp
+	}
+	if stats.BedTemp == 0 {
+		stats.BedTemp = sim.bedTemp
+	}
+
+	return stats
+}
+
+// parseComment handles a single comment. Bambu Studio writes several "key: value" pairs on one line separated by semicolons.
+func (m *metadata) parseComment(comment string) {
+	if m.slicer == "" {
+		m.slicer = slicerName(comment)
+	}
+
+	for _, part := range strings.Split(comment, ";") {
+		key, value, ok := splitKeyValue(part)
+		if !ok {
+			continue
+		}
+		m.set(strings.ToLower(key), value)
+	}
+}
+
+func splitKeyValue(s string) (string, string, bool) {
+	sep := strings.IndexAny(s, "=:")
+	if sep <= 0 {
+		return "", "", false
+	}
+	key := strings.TrimSpace(s[:sep])
+	value := strings.TrimSpace(s[sep+1:])
+	if key == "" || value == "" {
+		return "", "", false
+	}
+	return key, value, true
+}
+
+func (m *metadata) set(key string, value string) {
+	switch key {
+	case "estimated printing time (normal mode)", "total estimated time":
+		m.time = firstNonZero(m.time, parseDuration(value))
+	case "time", "print.time":
+		// Cura writes whole seconds
+		if seconds, err := strconv.ParseFloat(value, 64); err == nil && m.time == 0 {
+			m.time = time.Duration(seconds * float64(time.Second))
+		}
+	case "filament used [mm]", "total filament length [mm]":
+		m.filamentLength = firstNonZero(m.filamentLength, sumList(value))
+	case "filament used":
+		// Cura reports meters per extruder, e.g. "1.2345m, 0m"
+		if m.filamentLength == 0 {
+			m.filamentLength = sumList(strings.ReplaceAll(value, "m", "")) * 1000
+		}
+	case "filament used [g]", "total filament weight [g]":
+		m.filamentWeight = firstNonZero(m.filamentWeight, sumList(value))
+	case "filament_diameter":
+		m.filamentDiameter = firstNonZero(m.filamentDiameter, firstOfList(value))
+	case "filament_density":
+		m.filamentDensity = firstNonZero(m.filamentDensity, firstOfList(value))
+	case "layer_height", "layer height":
+		m.layerHeight = firstNonZero(m.layerHeight, firstOfList(value))
+	case "total layer number", "total layers count", "layer_count":
+		if count, err := strconv.Atoi(value); err == nil && m.layerCount == 0 {
+			m.layerCount = count
+		}
+	case "temperature", "nozzle_temperature", "extruder_train.0.initial_temperature":
+		m.nozzleTemp = firstNonZero(m.nozzleTemp, firstOfList(value))
+	case "bed_temperature", "hot_plate_temp", "build_plate.initial_temperature":
+		m.bedTemp = firstNonZero(m.bedTemp, firstOfList(value))
+	case "printer_model", "target_machine.name":
+		if m.printerModel == "" {
+			m.printerModel = unquote(value)
+		}
+	case "printer_settings_id":
+		if m.printerProfile == "" {
+			m.printerProfile = unquote(value)
+		}
+	}
+}
+
+// slicerName recognizes the "generated by" line slicers put at the top of their output
+func slicerName(comment string) string {
+	lower := strings.ToLower(comment)
+	for _, prefix := range []string{"generated by ", "generated with ", "bambustudio"} {
+		if !strings.HasPrefix(lower, prefix) {
+			continue
+		}
+		name := strings.TrimSpace(comment[len(prefix):])
+		if prefix == "bambustudio" {
+			name = comment
+		}
+		if on := strings.Index(name, " on "); on > 0 {
+			name = name[:on]
+		}
+		return name
+	}
+	return ""
+}
+
+// parseDuration parses slicer durations such as "1d 2h 3m 4s" or "2h 5m"
+func parseDuration(s string) time.Duration {
+	var total time.Duration
+	for _, field := range strings.Fields(s) {
+		if len(field) < 2 {
+			continue
+		}
+		value, err := strconv.ParseFloat(field[:len(field)-1], 64)
+		if err != nil {
+			continue
+		}
+		var unit time.Duration
+		switch field[len(field)-1] {
+		case 'd':
+			unit = 24 * time.Hour
+		case 'h':
+			unit = time.Hour
+		case 'm':
+			unit = time.Minute
+		case 's':
+			unit = time.Second
+		default:
+			continue
+		}
+		total += time.Duration(value * float64(unit))
+	}
+	return total
+}
+
+// sumList adds up a comma separated list of per extruder values
+func sumList(s string) float64 {
+	var total float64
+	for _, field := range strings.Split(s, ",") {
+		if value, err := strconv.ParseFloat(strings.TrimSpace(field), 64); err == nil {
+			total += value
+		}
+	}
+	return total
+}
+
+// firstOfList returns the value for the first extruder of a comma separated list
+func firstOfList(s string) float64 {
+	first, _, _ := strings.Cut(s, ",")
+	value, err := strconv.ParseFloat(strings.TrimSpace(first), 64)
+	if err != nil {
+		return 0
+	}
+	return value
+}
+
+func unquote(s string) string {
+	return strings.Trim(s, `"' `)
+}
+
+func firstNonZero[T comparable](current T, value T) T {
+	var zero T
+	if current != zero {
+		return current
+	}
+	return value
+}
+
+// simulator tracks the toolhead through the file to estimate time and filament usage when the slicer does not report them.
+// Acceleration is ignored, so times are a lower bound.
+type simulator struct {
+	pos       [4]float64 // X Y Z E
+	relative  bool
+	relativeE bool
+	feedrate  float64
+
+	seconds  float64
+	extruded float64
+
+	// layerZs counts extruding moves per Z height
+	layerZs map[float64]int
+
+	nozzleTemp float64
+	bedTemp    float64
+}
+
+func newSimulator() *simulator {
+	return &simulator{
+		feedrate: defaultFeedrate,
+		layerZs:  make(map[float64]int),
+	}
+}
+
+func (s *simulator) duration() time.Duration {
+	return time.Duration(s.seconds * float64(time.Second))
+}
+
+// layers returns the most common distance between the heights extrusion happened at, along with how many heights there were
+func (s *simulator) layers() (float64, int) {
+	heights := make([]float64, 0, len(s.layerZs))
+	for z := range s.layerZs {
+		heights = append(heights, z)
+	}
+	sort.Float64s(heights)
+
+	deltas := make(map[float64]int)
+	for i := 1; i < len(heights); i++ {
+		deltas[round(heights[i]-heights[i-1], 3)]++
+	}
+
+	var height float64
+	var best int
+	for delta, count := range deltas {
+		if count > best || (count == best && delta < height) {
+			height, best = delta, count
+		}
+	}
+	return height, len(heights)
+}
+
+func round(v float64, places int) float64 {
+	scale := math.Pow(10, float64(places))
+	return math.Round(v*scale) / scale
+}
+
+// params holds the lettered parameters of a command
+type params struct {
+	values [26]float64
+	set    uint32
+}
+
+func parseParams(fields []string) params {
+	var p params
+	for _, field := range fields {
+		if len(field) < 2 {
+			continue
+		}
+		letter := upper(field[0])
+		if letter < 'A' || letter > 'Z' {
+			continue
+		}
+		value, err := strconv.ParseFloat(field[1:], 64)
+		if err != nil {
+			continue
+		}
+		p.values[letter-'A'] = value
+		p.set |= 1 << (letter - 'A')
+	}
+	return p
+}
+
+func (p *params) get(letter byte) (float64, bool) {
+	i := letter - 'A'
+	return p.values[i], p.set&(1<<i) != 0
+}
+
+func (s *simulator) execute(code string) {
+	fields := strings.Fields(code)
+	command := strings.ToUpper(fields[0])
+	words := parseParams(fields[1:])
+
+	switch command {
+	case "G0", "G1", "G2", "G3":
+		s.move(&words)
+	case "G4":
+		if ms, ok := words.get('P'); ok {
+			s.seconds += ms / 1000
+		} else if sec, ok := words.get('S'); ok {
+			s.seconds += sec
+		}
+	case "G28":
+		homed := false
+		for axis, letter := range []byte("XYZ") {
+			if _, ok := words.get(letter); ok {
+				s.pos[axis] = 0
+				homed = true
+			}
+		}
+		if !homed {
+			s.pos[0], s.pos[1], s.pos[2] = 0, 0, 0
+		}
+	case "G90":
+		s.relative, s.relativeE = false, false
+	case "G91":
+		s.relative, s.relativeE = true, true
+	case "M82":
+		s.relativeE = false
+	case "M83":
+		s.relativeE = true
+	case "G92":
+		for axis, letter := range []byte("XYZE") {
+			if value, ok := words.get(letter); ok {
+				s.pos[axis] = value
+			}
+		}
+	case "M104", "M109":
+		if temp, ok := words.get('S'); ok && temp > 0 && s.nozzleTemp == 0 {
+			s.nozzleTemp = temp
+		}
+	case "M140", "M190":
+		if temp, ok := words.get('S'); ok && temp > 0 && s.bedTemp == 0 {
+			s.bedTemp = temp
+		}
+	}
+}
+
+// move applies a linear move. Arcs are treated as straight lines between their end points.
+func (s *simulator) move(words *params) {
+	if f, ok := words.get('F'); ok && f > 0 {
+		s.feedrate = f
+	}
+
+	var target [4]float64
+	for axis, letter := range []byte("XYZE") {
+		value, ok := words.get(letter)
+		relative := s.relative
+		if axis == 3 {
+			relative = s.relativeE
+		}
+		switch {
+		case !ok:
+			target[axis] = s.pos[axis]
+		case relative:
+			target[axis] = s.pos[axis] + value
+		default:
+			target[axis] = value
+		}
+	}
+
+	dx, dy, dz := target[0]-s.pos[0], target[1]-s.pos[1], target[2]-s.pos[2]
+	de := target[3] - s.pos[3]
+
+	distance := math.Sqrt(dx*dx + dy*dy + dz*dz)
+	if distance == 0 {
+		distance = math.Abs(de)
+	}
+	s.seconds += distance / (s.feedrate / 60)
+	s.extruded += de
+
+	if de > 0 && (dx != 0 || dy != 0) {
+		s.layerZs[round(target[2], 3)]++
+	}
+
+	s.pos = target
+}
+
+func upper(b byte) byte {
+	if b >= 'a' && b <= 'z' {
+		return b - 'a' + 'A'
+	}
+	return b
+}
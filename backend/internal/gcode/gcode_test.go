@@ -0,0 +1,243 @@
+package gcode
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testMoves are two extruded layers, 0.2mm apart, each taking a second at 10mm/s
+const testMoves = `G90
+M82
+G28
+G1 Z0.2 F600
+G1 X10 E1
+G1 Z0.4
+G1 X0 E2
+`
+
+func TestAnalyze(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		want Stats
+	}{
+		{
+			name: "prusaslicer",
+			file: `; generated by PrusaSlicer 2.7.1+linux-x64-GTK3 on 2024-03-02 at 10:41:07 UTC
+M104 S200
+M140 S50
+` + testMoves + `
+; filament used [mm] = 1234.56
+; filament used [g] = 3.72
+; estimated printing time (normal mode) = 1h 2m 3s
+; estimated printing time (silent mode) = 1h 10m 0s
+; bed_temperature = 60
+; filament_density = 1.24
+; filament_diameter = 1.75
+; layer_height = 0.2
+; printer_model = MK4
+; printer_settings_id = Original Prusa MK4 0.4 nozzle
+; temperature = 215
+`,
+			want: Stats{
+				Slicer:         "PrusaSlicer 2.7.1+linux-x64-GTK3",
+				PrinterProfile: "MK4",
+				EstimatedTime:  time.Hour + 2*time.Minute + 3*time.Second,
+				FilamentLength: 1234.56,
+				FilamentWeight: 3.72,
+				LayerHeight:    0.2,
+				LayerCount:     2,
+				NozzleTemp:     215,
+				BedTemp:        60,
+			},
+		},
+		{
+			name: "orcaslicer",
+			file: `; HEADER_BLOCK_START
+; generated by OrcaSlicer 2.0.0 on 2024-05-01 at 08:00:00
+; total layer number: 50
+; estimated printing time (normal mode) = 45m 30s
+; HEADER_BLOCK_END
+` + testMoves + `
+; filament used [mm] = 2000.5, 0.00
+; filament used [g] = 6.05, 0.00
+; filament_diameter = 1.75,1.75
+; layer_height = 0.16
+; nozzle_temperature = 220,210
+; hot_plate_temp = 55,60
+; printer_settings_id = "Voron 2.4 350 0.4 nozzle"
+`,
+			want: Stats{
+				Slicer:         "OrcaSlicer 2.0.0",
+				PrinterProfile: "Voron 2.4 350 0.4 nozzle",
+				EstimatedTime:  45*time.Minute + 30*time.Second,
+				FilamentLength: 2000.5,
+				FilamentWeight: 6.05,
+				LayerHeight:    0.16,
+				LayerCount:     50,
+				NozzleTemp:     220,
+				BedTemp:        55,
+			},
+		},
+		{
+			name: "bambu studio",
+			file: `; HEADER_BLOCK_START
+; BambuStudio 01.08.04.51
+; model printing time: 1h 5m 10s; total estimated time: 1h 12m 4s
+; total layer number: 120
+; total filament length [mm] : 4500.25
+; total filament weight [g] : 13.42
+; filament_density: 1.24
+; filament_diameter: 1.75
+; max_z_height: 24.00
+; HEADER_BLOCK_END
+` + testMoves + `
+; layer_height = 0.2
+; nozzle_temperature = 220
+; hot_plate_temp = 55
+; printer_model = Bambu Lab X1 Carbon
+`,
+			want: Stats{
+				Slicer:         "BambuStudio 01.08.04.51",
+				PrinterProfile: "Bambu Lab X1 Carbon",
+				EstimatedTime:  time.Hour + 12*time.Minute + 4*time.Second,
+				FilamentLength: 4500.25,
+				FilamentWeight: 13.42,
+				LayerHeight:    0.2,
+				LayerCount:     120,
+				NozzleTemp:     220,
+				BedTemp:        55,
+			},
+		},
+		{
+			name: "cura griffin",
+			file: `;START_OF_HEADER
+;HEADER_VERSION:0.1
+;FLAVOR:Griffin
+;GENERATOR.NAME:Cura_SteamEngine
+;GENERATOR.VERSION:5.6.0
+;TARGET_MACHINE.NAME:Ultimaker S5
+;EXTRUDER_TRAIN.0.INITIAL_TEMPERATURE:210
+;BUILD_PLATE.INITIAL_TEMPERATURE:60
+;PRINT.TIME:3723
+;END_OF_HEADER
+;Generated with Cura_SteamEngine 5.6.0
+;Filament used: 1.23456m
+;Layer height: 0.12
+;LAYER_COUNT:80
+` + testMoves,
+			want: Stats{
+				Slicer:         "Cura_SteamEngine 5.6.0",
+				PrinterProfile: "Ultimaker S5",
+				EstimatedTime:  3723 * time.Second,
+				FilamentLength: 1234.56,
+				// Cura reports no weight, it is derived from the length with the default density
+				FilamentWeight: 3.68,
+				LayerHeight:    0.12,
+				LayerCount:     80,
+				NozzleTemp:     210,
+				BedTemp:        60,
+			},
+		},
+		{
+			name: "cura marlin",
+			file: `;FLAVOR:Marlin
+;TIME:125
+;Filament used: 0.5m, 0m
+;Layer height: 0.2
+;Generated with Cura_SteamEngine 5.6.0
+M140 S65
+M104 S205
+` + testMoves,
+			want: Stats{
+				Slicer:         "Cura_SteamEngine 5.6.0",
+				EstimatedTime:  125 * time.Second,
+				FilamentLength: 500,
+				FilamentWeight: 1.49,
+				LayerHeight:    0.2,
+				LayerCount:     2,
+				NozzleTemp:     205,
+				BedTemp:        65,
+			},
+		},
+		{
+			name: "no slicer comments",
+			file: testMoves,
+			want: Stats{
+				EstimatedTime:  2040 * time.Millisecond,
+				FilamentLength: 2,
+				FilamentWeight: 0.006,
+				LayerHeight:    0.2,
+				LayerCount:     2,
+				Simulated:      true,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Analyze(strings.NewReader(tt.file))
+			if err != nil {
+				t.Fatalf("Analyze: %v", err)
+			}
+
+			if got.Slicer != tt.want.Slicer {
+				t.Errorf("Slicer = %q, want %q", got.Slicer, tt.want.Slicer)
+			}
+			if got.PrinterProfile != tt.want.PrinterProfile {
+				t.Errorf("PrinterProfile = %q, want %q", got.PrinterProfile, tt.want.PrinterProfile)
+			}
+			if got.EstimatedTime.Round(time.Millisecond) != tt.want.EstimatedTime {
+				t.Errorf("EstimatedTime = %v, want %v", got.EstimatedTime, tt.want.EstimatedTime)
+			}
+			if got.LayerCount != tt.want.LayerCount {
+				t.Errorf("LayerCount = %d, want %d", got.LayerCount, tt.want.LayerCount)
+			}
+			if got.Simulated != tt.want.Simulated {
+				t.Errorf("Simulated = %v, want %v", got.Simulated, tt.want.Simulated)
+			}
+			for _, field := range []struct {
+				name      string
+				got, want float64
+			}{
+				{"FilamentLength", got.FilamentLength, tt.want.FilamentLength},
+				{"FilamentWeight", got.FilamentWeight, tt.want.FilamentWeight},
+				{"LayerHeight", got.LayerHeight, tt.want.LayerHeight},
+				{"NozzleTemp", got.NozzleTemp, tt.want.NozzleTemp},
+				{"BedTemp", got.BedTemp, tt.want.BedTemp},
+			} {
+				if math.Abs(field.got-field.want) > 0.01 {
+					t.Errorf("%s = %v, want %v", field.name, field.got, field.want)
+				}
+			}
+		})
+	}
+}
+
+func TestAnalyzeRejectsFilesWithoutCommands(t *testing.T) {
+	file := "; generated by PrusaSlicer 2.7.1\n; filament used [mm] = 10\n\n"
+	if _, err := Analyze(strings.NewReader(file)); !errors.Is(err, ErrInvalidGCode) {
+		t.Fatalf("Analyze = %v, want ErrInvalidGCode", err)
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		in   string
+		want time.Duration
+	}{
+		{"1d 2h 3m 4s", 26*time.Hour + 3*time.Minute + 4*time.Second},
+		{"2h 5m", 2*time.Hour + 5*time.Minute},
+		{"45s", 45 * time.Second},
+		{"soon", 0},
+	}
+
+	for _, tt := range tests {
+		if got := parseDuration(tt.in); got != tt.want {
+			t.Errorf("parseDuration(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/torbenconto/spooler/internal/models"
+	"github.com/torbenconto/spooler/internal/services"
+	"github.com/torbenconto/spooler/internal/util"
+)
+
+type CreateFilamentRequest struct {
+	Material       string  `json:"material" binding:"required"`
+	Color          string  `json:"color" binding:"required"`
+	Brand          string  `json:"brand"`
+	RemainingGrams float64 `json:"remaining_grams" binding:"gte=0"`
+	PrinterID      *uint   `json:"printer_id"`
+}
+
+// UpdateFilamentRequest only updates the fields that are present in the request body, a printer_id of 0 unloads the spool
+type UpdateFilamentRequest struct {
+	Material       *string  `json:"material"`
+	Color          *string  `json:"color"`
+	Brand          *string  `json:"brand"`
+	RemainingGrams *float64 `json:"remaining_grams" binding:"omitempty,gte=0"`
+	PrinterID      *uint    `json:"printer_id"`
+}
+
+// filamentError responds with the status code matching a failed filament change
+func filamentError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, services.ErrFilamentNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "filament not found"})
+	case errors.Is(err, services.ErrPrinterNotFound):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
+
+// ListFilamentsHandler lists the spools with filament left, it is public so the color picker can offer only what is in stock
+func ListFilamentsHandler(filamentSvc *services.FilamentService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		filaments, err := filamentSvc.ListFilaments(true)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch filaments"})
+			return
+		}
+		c.JSON(http.StatusOK, filaments)
+	}
+}
+
+// AllFilamentsHandler lists every spool including empty ones
+func AllFilamentsHandler(filamentSvc *services.FilamentService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		filaments, err := filamentSvc.ListFilaments(false)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch filaments"})
+			return
+		}
+		c.JSON(http.StatusOK, filaments)
+	}
+}
+
+func GetFilamentHandler(filamentSvc *services.FilamentService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		filamentID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid filament id"})
+			return
+		}
+
+		filament, err := filamentSvc.GetFilamentByID(uint(filamentID))
+		if err != nil {
+			filamentError(c, err, "failed to fetch filament")
+			return
+		}
+		c.JSON(http.StatusOK, filament)
+	}
+}
+
+func CreateFilamentHandler(filamentSvc *services.FilamentService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req CreateFilamentRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+			return
+		}
+		if !util.ValidateHexColor(req.Color) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid filament color"})
+			return
+		}
+
+		if req.PrinterID != nil && *req.PrinterID == 0 {
+			req.PrinterID = nil
+		}
+
+		filament := models.Filament{
+			Material:       req.Material,
+			Color:          req.Color,
+			Brand:          req.Brand,
+			RemainingGrams: req.RemainingGrams,
+			PrinterID:      req.PrinterID,
+		}
+		if err := filamentSvc.CreateFilament(&filament); err != nil {
+			filamentError(c, err, "failed to create filament")
+			return
+		}
+
+		c.JSON(http.StatusCreated, filament)
+	}
+}
+
+func UpdateFilamentHandler(filamentSvc *services.FilamentService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		filamentID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid filament id"})
+			return
+		}
+
+		var req UpdateFilamentRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+			return
+		}
+
+		updates := make(map[string]any)
+		if req.Material != nil {
+			if *req.Material == "" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "material cannot be empty"})
+				return
+			}
+			updates["material"] = *req.Material
+		}
+		if req.Color != nil {
+			if !util.ValidateHexColor(*req.Color) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid filament color"})
+				return
+			}
+			updates["color"] = *req.Color
+		}
+		if req.Brand != nil {
+			updates["brand"] = *req.Brand
+		}
+		if req.RemainingGrams != nil {
+			updates["remaining_grams"] = *req.RemainingGrams
+		}
+		if req.PrinterID != nil {
+			if *req.PrinterID == 0 {
+				updates["printer_id"] = nil
+			} else {
+				updates["printer_id"] = *req.PrinterID
+			}
+		}
+
+		if len(updates) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "no fields to update"})
+			return
+		}
+
+		if err := filamentSvc.UpdateFilament(uint(filamentID), updates); err != nil {
+			filamentError(c, err, "failed to update filament")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "filament updated"})
+	}
+}
+
+func DeleteFilamentHandler(filamentSvc *services.FilamentService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		filamentID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid filament id"})
+			return
+		}
+
+		if err := filamentSvc.DeleteFilament(uint(filamentID)); err != nil {
+			filamentError(c, err, "failed to delete filament")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "filament deleted"})
+	}
+}
@@ -0,0 +1,331 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/torbenconto/spooler/config"
+	"github.com/torbenconto/spooler/internal/models"
+	"github.com/torbenconto/spooler/internal/services"
+	"github.com/torbenconto/spooler/internal/storage"
+	"github.com/torbenconto/spooler/internal/upload"
+	"github.com/torbenconto/spooler/internal/util"
+)
+
+// uploadTokenGrace is how long after its upload URL expired a direct upload can still be submitted as a print
+const uploadTokenGrace = time.Hour
+
+// multipartOverhead is what the form fields and part headers of a submission may add to the size of its file
+const multipartOverhead = 1 << 20
+
+// uploadRules are the upload limits of the config
+func uploadRules() *upload.Rules {
+	return &upload.Rules{
+		AllowedTypes:        config.Cfg.Uploads.AllowedTypes,
+		MaxFileSize:         config.Cfg.Uploads.MaxFileSize,
+		MaxUncompressedSize: config.Cfg.Uploads.MaxUncompressedSize,
+		MaxCompressionRatio: config.Cfg.Uploads.MaxCompressionRatio,
+	}
+}
+
+// limitUploadBody stops reading a request once it is larger than any accepted upload, before it is buffered to disk
+func limitUploadBody(c *gin.Context) {
+	if maxSize := config.Cfg.Uploads.MaxFileSize; maxSize > 0 {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxSize+multipartOverhead)
+	}
+}
+
+// uploadError responds to a rejected upload, listing every problem that was found
+func uploadError(c *gin.Context, err error) {
+	var validationErr *upload.ValidationError
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.As(err, &validationErr):
+		c.JSON(400, gin.H{"error": "invalid upload", "problems": validationErr.Problems})
+	case errors.As(err, &maxBytesErr):
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "invalid upload", "problems": []string{
+			fmt.Sprintf("file is larger than the %d bytes allowed", config.Cfg.Uploads.MaxFileSize),
+		}})
+	default:
+		c.JSON(500, gin.H{"error": "failed to read file"})
+	}
+}
+
+type UploadURLRequest struct {
+	FileName string `json:"file_name" binding:"required"`
+	FileSize int64  `json:"file_size" binding:"gte=0"`
+}
+
+// PrintUploadURLHandler hands out a signed URL to upload a model straight to storage. The returned upload_token is then
+// submitted to NewPrintHandler in place of the file.
+func PrintUploadURLHandler(storageClient storage.StorageClient, quotaSvc *services.QuotaService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, exists := c.Get("user")
+		if !exists {
+			c.JSON(401, gin.H{"error": "unauthorized"})
+			return
+		}
+
+		claims, ok := user.(*util.CustomClaims)
+		if !ok {
+			c.JSON(401, gin.H{"error": "invalid token claims"})
+			return
+		}
+
+		signer, ok := storageClient.(storage.URLSigner)
+		if !ok {
+			c.JSON(http.StatusNotImplemented, gin.H{"error": "storage provider does not support direct uploads"})
+			return
+		}
+
+		var req UploadURLRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": "invalid request body"})
+			return
+		}
+
+		// The content is validated once the upload is submitted as a print
+		if err := upload.CheckName(req.FileName, req.FileSize, uploadRules()); err != nil {
+			uploadError(c, err)
+			return
+		}
+
+		quota, err := quotaSvc.GetQuotaStatus(claims.UserID, models.Role(claims.Role))
+		if err != nil {
+			c.JSON(500, gin.H{"error": "failed to check quota"})
+			return
+		}
+		if err := quota.Allows(req.FileSize, 0); err != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+
+		expiry := config.Cfg.Storage.SignedURLExpiry
+		objectPath := uuid.New().String() + modelFileExtension(req.FileName)
+
+		uploadURL, err := signer.SignedURL(c.Request.Context(), objectPath, http.MethodPut, expiry)
+		if err != nil {
+			log.Printf("failed to sign upload url for %s: %v", objectPath, err)
+			c.JSON(500, gin.H{"error": "failed to create upload url"})
+			return
+		}
+
+		token, err := util.GenerateUploadToken(claims.UserID, objectPath, req.FileName, expiry+uploadTokenGrace)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "failed to create upload url"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"upload_url":   uploadURL,
+			"method":       http.MethodPut,
+			"upload_token": token,
+			"expires_at":   time.Now().Add(expiry),
+		})
+	}
+}
+
+// tempFile is a local copy of a stored file, closing it removes the copy
+type tempFile struct {
+	*os.File
+	size int64
+}
+
+func (f *tempFile) Close() error {
+	err := f.File.Close()
+	_ = os.Remove(f.Name())
+	return err
+}
+
+// fetchUpload copies a file uploaded straight to storage to a temporary file, the model parsers need random access to it
+func fetchUpload(ctx context.Context, storageClient storage.StorageClient, objectPath string) (*tempFile, error) {
+	reader, err := storageClient.GetFile(ctx, objectPath)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	f, err := os.CreateTemp("", "spooler-upload-*")
+	if err != nil {
+		return nil, err
+	}
+	tmp := &tempFile{File: f}
+
+	tmp.size, err = io.Copy(f, reader)
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		tmp.Close()
+		return nil, err
+	}
+	return tmp, nil
+}
+
+// assembleUpload joins the chunks of a resumable upload in a temporary file, verifying its checksum
+func assembleUpload(ctx context.Context, uploadSvc *services.UploadService, resumable *models.Upload) (*tempFile, error) {
+	f, err := os.CreateTemp("", "spooler-upload-*")
+	if err != nil {
+		return nil, err
+	}
+	tmp := &tempFile{File: f, size: resumable.FileSize}
+
+	err = uploadSvc.Assemble(ctx, resumable, f)
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		tmp.Close()
+		return nil, err
+	}
+	return tmp, nil
+}
+
+// SignedFileHandler serves downloads from and accepts uploads to the local storage provider for URLs handed out by its SignedURL
+func SignedFileHandler(local *storage.LocalStorageClient) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		objectPath := strings.TrimPrefix(c.Param("path"), "/")
+		if err := local.VerifySignedURL(objectPath, c.Request.Method, c.Query("expires"), c.Query("signature")); err != nil {
+			status := http.StatusForbidden
+			if errors.Is(err, storage.ErrSignatureExpired) {
+				status = http.StatusGone
+			}
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+
+		switch c.Request.Method {
+		case http.MethodPut:
+			// The signature only covers the path, the size the client announced is not enforced by anything else
+			body := c.Request.Body
+			if maxSize := config.Cfg.Uploads.MaxFileSize; maxSize > 0 {
+				body = http.MaxBytesReader(c.Writer, c.Request.Body, maxSize)
+			}
+			err := local.StoreFile(c.Request.Context(), objectPath, body)
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				uploadError(c, err)
+				return
+			}
+			if err != nil {
+				log.Printf("failed to store signed upload %s: %v", objectPath, err)
+				c.JSON(500, gin.H{"error": "failed to store file"})
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{"message": "file stored"})
+		default:
+			serveObject(c, local, objectPath, path.Base(objectPath))
+		}
+	}
+}
+
+// objectReader reads a stored object as the io.ReadSeeker http.ServeContent needs, seeking reopens it at the new offset
+type objectReader struct {
+	ctx           context.Context
+	storageClient storage.StorageClient
+	objectPath    string
+	size          int64
+	offset        int64
+	body          io.ReadCloser
+}
+
+func (r *objectReader) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+	if r.body == nil {
+		body, err := r.storageClient.GetRange(r.ctx, r.objectPath, r.offset, -1)
+		if err != nil {
+			return 0, err
+		}
+		r.body = body
+	}
+
+	n, err := r.body.Read(p)
+	r.offset += int64(n)
+	return n, err
+}
+
+func (r *objectReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	}
+	if offset < 0 {
+		return 0, errors.New("negative offset")
+	}
+
+	if offset != r.offset {
+		r.Close()
+		r.offset = offset
+	}
+	return offset, nil
+}
+
+func (r *objectReader) Close() error {
+	if r.body == nil {
+		return nil
+	}
+	err := r.body.Close()
+	r.body = nil
+	return err
+}
+
+// redirectSigned redirects to a signed URL of an object when the storage provider can create one, reporting whether it did
+func redirectSigned(c *gin.Context, storageClient storage.StorageClient, objectPath string) bool {
+	signer, ok := storageClient.(storage.URLSigner)
+	if !ok {
+		return false
+	}
+
+	url, err := signer.SignedURL(c.Request.Context(), objectPath, http.MethodGet, config.Cfg.Storage.SignedURLExpiry)
+	if err != nil {
+		log.Printf("failed to sign download url for %s, proxying it instead: %v", objectPath, err)
+		return false
+	}
+
+	// Reusing the redirect for a while keeps the URL stable, so the browser can cache and revalidate the file
+	c.Header("Cache-Control", fmt.Sprintf("private, max-age=%d", int(config.Cfg.Storage.SignedURLExpiry/2/time.Second)))
+	c.Redirect(http.StatusTemporaryRedirect, url)
+	return true
+}
+
+// serveObject streams a stored object as an attachment named fileName with its content type, length, ETag and
+// Last-Modified, answering Range and conditional requests
+func serveObject(c *gin.Context, storageClient storage.StorageClient, objectPath string, fileName string) {
+	info, err := storageClient.Stat(c.Request.Context(), objectPath)
+	if err != nil {
+		c.JSON(404, gin.H{"error": "file not found"})
+		return
+	}
+
+	reader := &objectReader{
+		ctx:           c.Request.Context(),
+		storageClient: storageClient,
+		objectPath:    objectPath,
+		size:          info.Size,
+	}
+	defer reader.Close()
+
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": fileName}))
+	c.Header("Content-Type", info.ContentType)
+	// Files never change under their name, but access is per user so only the browser may keep them
+	c.Header("Cache-Control", "private, no-cache")
+	if info.ETag != "" {
+		c.Header("ETag", info.ETag)
+	}
+	http.ServeContent(c.Writer, c.Request, objectPath, info.LastModified, reader)
+}
@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/torbenconto/spooler/internal/models"
+	"github.com/torbenconto/spooler/internal/services"
+)
+
+type CreateMaterialRequest struct {
+	Name         string  `json:"name" binding:"required"`
+	PricePerGram float64 `json:"price_per_gram" binding:"gte=0"`
+	Density      float64 `json:"density" binding:"gte=0"`
+}
+
+// UpdateMaterialRequest only updates the fields that are present in the request body
+type UpdateMaterialRequest struct {
+	Name         *string  `json:"name"`
+	PricePerGram *float64 `json:"price_per_gram" binding:"omitempty,gte=0"`
+	Density      *float64 `json:"density" binding:"omitempty,gt=0"`
+}
+
+func ListMaterialsHandler(materialSvc *services.MaterialService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		materials, err := materialSvc.ListMaterials()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch materials"})
+			return
+		}
+		c.JSON(http.StatusOK, materials)
+	}
+}
+
+func CreateMaterialHandler(materialSvc *services.MaterialService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req CreateMaterialRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+			return
+		}
+
+		// A missing density is left to the column default
+		material := models.Material{
+			Name:         req.Name,
+			PricePerGram: req.PricePerGram,
+			Density:      req.Density,
+		}
+
+		if err := materialSvc.CreateMaterial(&material); err != nil {
+			if errors.Is(err, services.ErrMaterialNameExists) {
+				c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create material"})
+			return
+		}
+
+		c.JSON(http.StatusCreated, material)
+	}
+}
+
+func UpdateMaterialHandler(materialSvc *services.MaterialService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		materialID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid material id"})
+			return
+		}
+
+		var req UpdateMaterialRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+			return
+		}
+
+		updates := make(map[string]any)
+		if req.Name != nil {
+			if *req.Name == "" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "name cannot be empty"})
+				return
+			}
+			updates["name"] = *req.Name
+		}
+		if req.PricePerGram != nil {
+			updates["price_per_gram"] = *req.PricePerGram
+		}
+		if req.Density != nil {
+			updates["density"] = *req.Density
+		}
+
+		if len(updates) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "no fields to update"})
+			return
+		}
+
+		if err := materialSvc.UpdateMaterial(uint(materialID), updates); err != nil {
+			switch {
+			case errors.Is(err, services.ErrMaterialNotFound):
+				c.JSON(http.StatusNotFound, gin.H{"error": "material not found"})
+			case errors.Is(err, services.ErrMaterialNameExists):
+				c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			default:
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update material"})
+			}
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "material updated"})
+	}
+}
+
+func DeleteMaterialHandler(materialSvc *services.MaterialService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		materialID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid material id"})
+			return
+		}
+
+		if err := materialSvc.DeleteMaterial(uint(materialID)); err != nil {
+			if errors.Is(err, services.ErrMaterialNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "material not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete material"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "material deleted"})
+	}
+}
@@ -0,0 +1,251 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/torbenconto/spooler/internal/models"
+	"github.com/torbenconto/spooler/internal/services"
+)
+
+// errLoadedFilament is returned for printer requests that try to set the loaded filament directly
+const errLoadedFilament = "the loaded filament is taken from the spool loaded on the printer, set the spool's printer_id instead"
+
+type CreatePrinterRequest struct {
+	Name                   string  `json:"name" binding:"required"`
+	Make                   string  `json:"make"`
+	Model                  string  `json:"model"`
+	BuildVolumeX           float64 `json:"build_volume_x" binding:"gte=0"`
+	BuildVolumeY           float64 `json:"build_volume_y" binding:"gte=0"`
+	BuildVolumeZ           float64 `json:"build_volume_z" binding:"gte=0"`
+	NozzleSize             float64 `json:"nozzle_size" binding:"gte=0"`
+	LoadedFilamentColor    *string `json:"loaded_filament_color"`
+	LoadedFilamentMaterial *string `json:"loaded_filament_material"`
+	Online                 bool    `json:"online"`
+	Driver                 string  `json:"driver"`
+	Address                string  `json:"address"`
+	APIKey                 string  `json:"api_key"`
+	SerialNumber           string  `json:"serial_number"`
+	SlicerProfile          string  `json:"slicer_profile"`
+}
+
+// UpdatePrinterRequest only updates the fields that are present in the request body
+type UpdatePrinterRequest struct {
+	Name                   *string  `json:"name"`
+	Make                   *string  `json:"make"`
+	Model                  *string  `json:"model"`
+	BuildVolumeX           *float64 `json:"build_volume_x" binding:"omitempty,gte=0"`
+	BuildVolumeY           *float64 `json:"build_volume_y" binding:"omitempty,gte=0"`
+	BuildVolumeZ           *float64 `json:"build_volume_z" binding:"omitempty,gte=0"`
+	NozzleSize             *float64 `json:"nozzle_size" binding:"omitempty,gte=0"`
+	LoadedFilamentColor    *string  `json:"loaded_filament_color"`
+	LoadedFilamentMaterial *string  `json:"loaded_filament_material"`
+	Online                 *bool    `json:"online"`
+	Driver                 *string  `json:"driver"`
+	Address                *string  `json:"address"`
+	APIKey                 *string  `json:"api_key"`
+	SerialNumber           *string  `json:"serial_number"`
+	SlicerProfile          *string  `json:"slicer_profile"`
+}
+
+func ListPrintersHandler(printerSvc *services.PrinterService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		printers, err := printerSvc.ListPrinters()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch printers"})
+			return
+		}
+		c.JSON(http.StatusOK, printers)
+	}
+}
+
+func GetPrinterHandler(printerSvc *services.PrinterService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		printerID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid printer id"})
+			return
+		}
+
+		printer, err := printerSvc.GetPrinterByID(uint(printerID))
+		if err != nil {
+			if errors.Is(err, services.ErrPrinterNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "printer not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch printer"})
+			return
+		}
+		c.JSON(http.StatusOK, printer)
+	}
+}
+
+func CreatePrinterHandler(printerSvc *services.PrinterService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req CreatePrinterRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+			return
+		}
+
+		printer := models.Printer{
+			Name:          req.Name,
+			Make:          req.Make,
+			Model:         req.Model,
+			BuildVolumeX:  req.BuildVolumeX,
+			BuildVolumeY:  req.BuildVolumeY,
+			BuildVolumeZ:  req.BuildVolumeZ,
+			NozzleSize:    req.NozzleSize,
+			Online:        req.Online,
+			Driver:        models.PrinterDriver(req.Driver),
+			Address:       req.Address,
+			APIKey:        req.APIKey,
+			SerialNumber:  req.SerialNumber,
+			SlicerProfile: req.SlicerProfile,
+		}
+		if printer.NozzleSize == 0 {
+			printer.NozzleSize = 0.4
+		}
+		if req.LoadedFilamentColor != nil || req.LoadedFilamentMaterial != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": errLoadedFilament})
+			return
+		}
+		if !printer.Driver.IsValid() {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid printer driver"})
+			return
+		}
+		if printer.Driver != models.DriverNone && printer.Address == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "address is required when a driver is set"})
+			return
+		}
+		if printer.Driver == models.DriverBambu && (printer.SerialNumber == "" || printer.APIKey == "") {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "serial number and access code are required for bambu printers"})
+			return
+		}
+
+		if err := printerSvc.CreatePrinter(&printer); err != nil {
+			if errors.Is(err, services.ErrPrinterNameExists) {
+				c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create printer"})
+			return
+		}
+
+		c.JSON(http.StatusCreated, printer)
+	}
+}
+
+func UpdatePrinterHandler(printerSvc *services.PrinterService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		printerID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid printer id"})
+			return
+		}
+
+		var req UpdatePrinterRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+			return
+		}
+
+		updates := make(map[string]any)
+		if req.Name != nil {
+			if *req.Name == "" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "name cannot be empty"})
+				return
+			}
+			updates["name"] = *req.Name
+		}
+		if req.Make != nil {
+			updates["make"] = *req.Make
+		}
+		if req.Model != nil {
+			updates["model"] = *req.Model
+		}
+		if req.BuildVolumeX != nil {
+			updates["build_volume_x"] = *req.BuildVolumeX
+		}
+		if req.BuildVolumeY != nil {
+			updates["build_volume_y"] = *req.BuildVolumeY
+		}
+		if req.BuildVolumeZ != nil {
+			updates["build_volume_z"] = *req.BuildVolumeZ
+		}
+		if req.NozzleSize != nil {
+			updates["nozzle_size"] = *req.NozzleSize
+		}
+		if req.LoadedFilamentColor != nil || req.LoadedFilamentMaterial != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": errLoadedFilament})
+			return
+		}
+		if req.Online != nil {
+			updates["online"] = *req.Online
+		}
+		if req.Driver != nil {
+			if !models.PrinterDriver(*req.Driver).IsValid() {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid printer driver"})
+				return
+			}
+			updates["driver"] = *req.Driver
+		}
+		if req.Address != nil {
+			updates["address"] = *req.Address
+		}
+		if req.APIKey != nil {
+			updates["api_key"] = *req.APIKey
+		}
+		if req.SerialNumber != nil {
+			updates["serial_number"] = *req.SerialNumber
+		}
+		if req.SlicerProfile != nil {
+			updates["slicer_profile"] = *req.SlicerProfile
+		}
+
+		if len(updates) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "no fields to update"})
+			return
+		}
+
+		if err := printerSvc.UpdatePrinter(uint(printerID), updates); err != nil {
+			switch {
+			case errors.Is(err, services.ErrPrinterNotFound):
+				c.JSON(http.StatusNotFound, gin.H{"error": "printer not found"})
+			case errors.Is(err, services.ErrPrinterNameExists):
+				c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			default:
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update printer"})
+			}
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "printer updated"})
+	}
+}
+
+func DeletePrinterHandler(printerSvc *services.PrinterService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		printerID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid printer id"})
+			return
+		}
+
+		if err := printerSvc.DeletePrinter(uint(printerID)); err != nil {
+			switch {
+			case errors.Is(err, services.ErrPrinterNotFound):
+				c.JSON(http.StatusNotFound, gin.H{"error": "printer not found"})
+			case errors.Is(err, services.ErrPrinterBusy):
+				c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			default:
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete printer"})
+			}
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "printer deleted"})
+	}
+}
@@ -1,29 +1,39 @@
 package handlers
 
 import (
+	"bytes"
 	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
+	"image/color"
 	"io"
 	"log"
+	"mime/multipart"
 	"net/http"
-	"path/filepath"
 	"strconv"
-	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/torbenconto/spooler/internal/gcode"
+	"github.com/torbenconto/spooler/internal/mesh"
 	"github.com/torbenconto/spooler/internal/models"
 	"github.com/torbenconto/spooler/internal/services"
 	"github.com/torbenconto/spooler/internal/storage"
+	"github.com/torbenconto/spooler/internal/upload"
 	"github.com/torbenconto/spooler/internal/util"
 )
 
 type NewPrintRequest struct {
-	FilamentColor string `form:"requested_filament_color" binding:"required"`
+	FilamentColor    string `form:"requested_filament_color" binding:"required"`
+	FilamentMaterial string `form:"requested_filament_material"`
+	// UploadToken replaces the file when it was uploaded straight to storage, see PrintUploadURLHandler
+	UploadToken string `form:"upload_token"`
+	// UploadID replaces the file when it was sent in chunks, see CreateUploadHandler
+	UploadID string `form:"upload_id"`
 }
 
-func NewPrintHandler(storageClient storage.StorageClient, printSvc *services.PrintService) gin.HandlerFunc {
+func NewPrintHandler(storageClient storage.StorageClient, printSvc *services.PrintService, blobSvc *services.BlobService, uploadSvc *services.UploadService, filamentSvc *services.FilamentService, quotaSvc *services.QuotaService) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		user, exists := c.Get("user")
 		if !exists {
@@ -37,71 +47,382 @@ func NewPrintHandler(storageClient storage.StorageClient, printSvc *services.Pri
 			return
 		}
 
+		limitUploadBody(c)
+
 		var req NewPrintRequest
 		if err := c.ShouldBind(&req); err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				uploadError(c, err)
+				return
+			}
 			c.JSON(400, gin.H{"error": "invalid request body"})
 			return
 		}
 
-		file, err := c.FormFile("file")
+		if req.FilamentMaterial == "" {
+			req.FilamentMaterial = "PLA"
+		}
+		if !util.ValidateHexColor(req.FilamentColor) {
+			c.JSON(400, gin.H{"error": "invalid filament color"})
+			return
+		}
+		inStock, err := filamentSvc.InStock(req.FilamentMaterial, req.FilamentColor)
 		if err != nil {
-			c.JSON(400, gin.H{"error": "file is required"})
+			c.JSON(500, gin.H{"error": "failed to check filament stock"})
 			return
 		}
+		if !inStock {
+			c.JSON(400, gin.H{"error": services.ErrFilamentOutOfStock.Error()})
+			return
+		}
+
+		var (
+			fileHandle multipart.File
+			fileName   string
+			fileSize   int64
+			// uploadedObject is the stored file of a direct upload, it is removed once the print is stored by its hash or rejected
+			uploadedObject string
+			// resumable is a chunked upload, it is deleted once the print is created and kept otherwise so it can be submitted again
+			resumable *models.Upload
+		)
+		if req.UploadToken != "" {
+			upload, err := util.ParseUploadToken(req.UploadToken)
+			if err != nil || upload.UserID != claims.UserID {
+				c.JSON(400, gin.H{"error": "invalid upload token"})
+				return
+			}
+
+			uploadedObject = upload.ObjectPath
+			defer func() {
+				if err := storageClient.DeleteFile(context.Background(), uploadedObject); err != nil {
+					log.Printf("failed to delete direct upload %s: %v", uploadedObject, err)
+				}
+			}()
+
+			tmp, err := fetchUpload(c.Request.Context(), storageClient, uploadedObject)
+			if err != nil {
+				c.JSON(400, gin.H{"error": "uploaded file not found"})
+				return
+			}
+			fileHandle, fileName, fileSize = tmp, upload.FileName, tmp.size
+		} else if req.UploadID != "" {
+			resumable, err = uploadSvc.GetUpload(req.UploadID, claims.UserID)
+			if errors.Is(err, services.ErrUploadNotFound) {
+				c.JSON(404, gin.H{"error": err.Error()})
+				return
+			}
+			if err != nil {
+				c.JSON(500, gin.H{"error": "failed to fetch upload"})
+				return
+			}
 
-		const maxFileSize = 100 * 1024 * 1024
-		if file.Size > maxFileSize {
-			c.JSON(400, gin.H{"error": "file too large"})
+			tmp, err := assembleUpload(c.Request.Context(), uploadSvc, resumable)
+			switch {
+			case errors.Is(err, services.ErrUploadIncomplete):
+				c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "offset": resumable.Offset, "file_size": resumable.FileSize})
+				return
+			case errors.Is(err, services.ErrUploadChecksum):
+				// The staged chunks are corrupt, the file has to be uploaded again
+				if err := uploadSvc.DeleteUpload(context.Background(), resumable); err != nil {
+					log.Printf("failed to delete upload %s: %v", resumable.ID, err)
+				}
+				c.JSON(400, gin.H{"error": err.Error()})
+				return
+			case err != nil:
+				log.Printf("failed to assemble upload %s: %v", resumable.ID, err)
+				c.JSON(500, gin.H{"error": "failed to read file"})
+				return
+			}
+			fileHandle, fileName, fileSize = tmp, resumable.FileName, tmp.size
+		} else {
+			file, err := c.FormFile("file")
+			if err != nil {
+				var maxBytesErr *http.MaxBytesError
+				if errors.As(err, &maxBytesErr) {
+					uploadError(c, err)
+					return
+				}
+				c.JSON(400, gin.H{"error": "file is required"})
+				return
+			}
+
+			fileHandle, err = file.Open()
+			if err != nil {
+				c.JSON(500, gin.H{"error": "failed to open file"})
+				return
+			}
+			fileName, fileSize = file.Filename, file.Size
+		}
+
+		if err := upload.Validate(fileHandle, fileSize, fileName, uploadRules()); err != nil {
+			fileHandle.Close()
+			uploadError(c, err)
 			return
 		}
 
-		fileHandle, err := file.Open()
+		quota, err := quotaSvc.GetQuotaStatus(claims.UserID, models.Role(claims.Role))
 		if err != nil {
-			c.JSON(500, gin.H{"error": "failed to open file"})
+			fileHandle.Close()
+			c.JSON(500, gin.H{"error": "failed to check quota"})
+			return
+		}
+		if err := quota.Allows(fileSize, 0); err != nil {
+			fileHandle.Close()
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
 			return
 		}
-		storedFileName := fmt.Sprintf("%s%s", uuid.New().String(), filepath.Ext(file.Filename))
-
-		pr, pw := io.Pipe()
-
-		go func() {
-			defer fileHandle.Close()
-			_, err := io.Copy(pw, fileHandle)
-			_ = pw.CloseWithError(err)
-		}()
 
-		if err := storageClient.StoreFile(c.Request.Context(), storedFileName, pr); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to upload file"})
+		fileHash, err := services.HashFile(fileHandle)
+		if err != nil {
+			fileHandle.Close()
+			c.JSON(500, gin.H{"error": "failed to read file"})
 			return
 		}
 
-		// if err := bucketSvc.UploadFile(c, storedFileName, fileHandle); err != nil {
-		// 	c.JSON(500, gin.H{"error": "failed to upload file to bucket"})
-		// 	return
-		// }
-		// ????? can i use goroutine? we will see
+		// The model is stored by its content, the thumbnail is rendered in the requested color so it is stored per print
+		fileExtension := modelFileExtension(fileName)
+		fileID := uuid.New().String()
+		storedFileName := services.BlobObjectPath(fileHash, fileExtension)
 
 		print := models.Print{
-			UserID:                 claims.UserID,
-			StoredFileName:         storedFileName,
-			UploadedFileName:       file.Filename,
-			RequestedFilamentColor: req.FilamentColor,
+			UserID:                    claims.UserID,
+			StoredFileName:            storedFileName,
+			FileHash:                  fileHash,
+			UploadedFileName:          fileName,
+			RequestedFilamentColor:    req.FilamentColor,
+			RequestedFilamentMaterial: req.FilamentMaterial,
+		}
+
+		var thumbnail []byte
+		switch fileExtension {
+		case ".stl":
+			stats, err := mesh.AnalyzeSTL(fileHandle)
+			if err != nil {
+				fileHandle.Close()
+				c.JSON(400, gin.H{"error": fmt.Sprintf("failed to read model: %v", err)})
+				return
+			}
+			applyMeshStats(&print, stats)
+
+			if _, err := fileHandle.Seek(0, io.SeekStart); err != nil {
+				fileHandle.Close()
+				c.JSON(500, gin.H{"error": "failed to read file"})
+				return
+			}
+			thumbnail, err = renderSTLThumbnail(fileHandle, stats, req.FilamentColor)
+			if err != nil {
+				log.Printf("failed to render thumbnail of %s: %v", storedFileName, err)
+			}
+
+			if _, err := fileHandle.Seek(0, io.SeekStart); err != nil {
+				fileHandle.Close()
+				c.JSON(500, gin.H{"error": "failed to read file"})
+				return
+			}
+		case ".gcode":
+			stats, err := gcode.Analyze(fileHandle)
+			if err != nil {
+				fileHandle.Close()
+				c.JSON(400, gin.H{"error": fmt.Sprintf("failed to read gcode: %v", err)})
+				return
+			}
+			applyGCodeStats(&print, stats)
+
+			if _, err := fileHandle.Seek(0, io.SeekStart); err != nil {
+				fileHandle.Close()
+				c.JSON(500, gin.H{"error": "failed to read file"})
+				return
+			}
+		case ".3mf", ".gcode.3mf":
+			pkg, err := mesh.Read3MF(fileHandle, fileSize)
+			if err != nil {
+				fileHandle.Close()
+				c.JSON(400, gin.H{"error": fmt.Sprintf("failed to read model: %v", err)})
+				return
+			}
+			apply3MFPackage(&print, pkg)
+			// Prefer the thumbnail the slicer embedded, it shows the model as the user arranged it
+			thumbnail = pkg.PreviewImage()
+			if len(thumbnail) == 0 && len(pkg.Items) > 0 {
+				thumbnail, err = render3MFThumbnail(pkg, req.FilamentColor)
+				if err != nil {
+					log.Printf("failed to render thumbnail of %s: %v", storedFileName, err)
+				}
+			}
+
+			// Printers start sliced projects from their first plate
+			if pkg.HasGCode {
+				stats, err := analyzePlateGCode(fileHandle, fileSize, 1)
+				if err != nil {
+					fileHandle.Close()
+					c.JSON(400, gin.H{"error": fmt.Sprintf("failed to read gcode: %v", err)})
+					return
+				}
+				applyGCodeStats(&print, stats)
+			}
+		}
+
+		// The filament limits can only be checked once the model has been analyzed
+		grams, err := quotaSvc.EstimateGrams(&print)
+		if err != nil {
+			fileHandle.Close()
+			c.JSON(500, gin.H{"error": "failed to check quota"})
+			return
+		}
+		if err := quota.Allows(fileSize, grams); err != nil {
+			fileHandle.Close()
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+
+		cost, err := printSvc.EstimateCost(&print)
+		if err != nil {
+			log.Printf("failed to estimate cost of %s: %v", storedFileName, err)
+		}
+		print.EstimatedCost = cost
+
+		if len(thumbnail) > 0 {
+			thumbnailFileName := fileID + ".thumb.png"
+			if err := storageClient.StoreFile(c.Request.Context(), thumbnailFileName, bytes.NewReader(thumbnail)); err != nil {
+				log.Printf("failed to store thumbnail %s: %v", thumbnailFileName, err)
+			} else {
+				print.ThumbnailFileName = thumbnailFileName
+			}
 		}
 
-		if err := printSvc.CreatePrint(&print); err != nil {
+		// Submissions running at the same time all passed the check above, the open prints are counted again under a lock
+		err = blobSvc.CreatePrint(c.Request.Context(), &print, fileHandle, fileSize, quota.Quota.MaxOpenPrints)
+		fileHandle.Close()
+		if errors.Is(err, services.ErrQuotaExceeded) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			log.Printf("failed to create print of %s: %v", storedFileName, err)
 			c.JSON(500, gin.H{"error": "failed to create print"})
 			return
 		}
 
+		if resumable != nil {
+			if err := uploadSvc.DeleteUpload(context.Background(), resumable); err != nil {
+				log.Printf("failed to delete upload %s: %v", resumable.ID, err)
+			}
+		}
+
 		c.JSON(200, gin.H{
-			"message":                  "file uploaded successfully",
-			"file":                     file.Filename,
-			"backend_filename":         storedFileName,
-			"requested_filament_color": req.FilamentColor,
+			"message":                     "file uploaded successfully",
+			"file":                        fileName,
+			"backend_filename":            storedFileName,
+			"requested_filament_color":    req.FilamentColor,
+			"requested_filament_material": req.FilamentMaterial,
+			"estimated_cost":              print.EstimatedCost,
 		})
 	}
 }
 
+func applyMeshStats(print *models.Print, stats *mesh.Stats) {
+	size := stats.Size()
+	print.SizeX = size.X
+	print.SizeY = size.Y
+	print.SizeZ = size.Z
+	print.Volume = stats.Volume
+	print.SurfaceArea = stats.SurfaceArea
+	print.TriangleCount = stats.TriangleCount
+	print.NonManifoldEdges = stats.NonManifoldEdges
+	print.DegenerateTriangles = stats.DegenerateTriangles
+}
+
+// apply3MFPackage copies the geometry of a 3MF project onto a print. Multi plate projects spread their items over several plates,
+// so the size of the largest item is used instead of the combined bounding box.
+func apply3MFPackage(print *models.Print, pkg *mesh.Package) {
+	stats := pkg.Stats()
+	if stats.TriangleCount > 0 {
+		applyMeshStats(print, &stats)
+	}
+	print.PlateCount = pkg.PlateCount
+	if pkg.PlateCount > 1 {
+		print.SizeX, print.SizeY, print.SizeZ = 0, 0, 0
+	}
+
+	for _, item := range pkg.Items {
+		itemStats := item.Stats()
+		size := itemStats.Size()
+		print.Objects = append(print.Objects, models.PrintObject{
+			ObjectID:      item.ObjectID,
+			Name:          item.Name,
+			Transform:     item.Transform.String(),
+			SizeX:         size.X,
+			SizeY:         size.Y,
+			SizeZ:         size.Z,
+			Volume:        itemStats.Volume,
+			TriangleCount: itemStats.TriangleCount,
+		})
+
+		if pkg.PlateCount > 1 {
+			print.SizeX = max(print.SizeX, size.X)
+			print.SizeY = max(print.SizeY, size.Y)
+			print.SizeZ = max(print.SizeZ, size.Z)
+		}
+	}
+}
+
+const thumbnailSize = 256
+
+// thumbnailColor renders models in their requested filament color, lifted so dark filaments stay readable
+func thumbnailColor(filamentColor string) color.RGBA {
+	r, g, b, ok := util.ParseHexColor(filamentColor)
+	if !ok {
+		r, g, b = 0x9a, 0xa5, 0xb1
+	}
+	lift := func(v uint8) uint8 { return 64 + uint8(float64(v)*0.75) }
+	return color.RGBA{R: lift(r), G: lift(g), B: lift(b), A: 255}
+}
+
+func renderSTLThumbnail(r io.Reader, stats *mesh.Stats, filamentColor string) ([]byte, error) {
+	renderer := mesh.NewRenderer(stats.Min, stats.Max, thumbnailSize, thumbnailSize, thumbnailColor(filamentColor))
+	if err := mesh.ReadSTL(r, func(t mesh.Triangle) error {
+		renderer.Add(t)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return renderer.PNG()
+}
+
+func render3MFThumbnail(pkg *mesh.Package, filamentColor string) ([]byte, error) {
+	stats := pkg.Stats()
+	renderer := mesh.NewRenderer(stats.Min, stats.Max, thumbnailSize, thumbnailSize, thumbnailColor(filamentColor))
+	for _, item := range pkg.Items {
+		for _, t := range item.Triangles {
+			renderer.Add(t)
+		}
+	}
+	return renderer.PNG()
+}
+
+func analyzePlateGCode(r io.ReaderAt, size int64, plate int) (*gcode.Stats, error) {
+	plateGCode, err := mesh.OpenPlateGCode(r, size, plate)
+	if err != nil {
+		return nil, err
+	}
+	defer plateGCode.Close()
+
+	return gcode.Analyze(plateGCode)
+}
+
+func applyGCodeStats(print *models.Print, stats *gcode.Stats) {
+	print.Slicer = stats.Slicer
+	print.PrinterProfile = stats.PrinterProfile
+	print.EstimatedPrintSeconds = int(stats.EstimatedTime.Seconds())
+	print.FilamentLength = stats.FilamentLength
+	print.FilamentWeight = stats.FilamentWeight
+	print.LayerHeight = stats.LayerHeight
+	print.LayerCount = stats.LayerCount
+	print.NozzleTemperature = stats.NozzleTemp
+	print.BedTemperature = stats.BedTemp
+}
+
 type FilePreviewType string
 
 const (
@@ -117,10 +438,25 @@ type FilePreview struct {
 	PreviewImage *string `json:"preview_image,omitempty"` // base64 PNG for gcode.3mf
 }
 
+// modelFileExtension returns the lowercased extension of a model file, keeping the double extension of sliced .gcode.3mf projects
+func modelFileExtension(fileName string) string {
+	if fileType := upload.TypeOf(fileName); fileType != "" {
+		return "." + fileType
+	}
+	return ""
+}
+
 func PreviewHandler() gin.HandlerFunc {
 	return func(c *gin.Context) {
+		limitUploadBody(c)
+
 		file, err := c.FormFile("file")
 		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				uploadError(c, err)
+				return
+			}
 			c.JSON(400, gin.H{"error": "file is required"})
 			return
 		}
@@ -132,18 +468,12 @@ func PreviewHandler() gin.HandlerFunc {
 		}
 		defer fileHandle.Close()
 
-		var fileExtensions []string
-		var fileName = file.Filename
-		for {
-			ext := filepath.Ext(fileName)
-			if ext == "" {
-				break
-			}
-			fileExtensions = append(fileExtensions, ext)
-			fileName = strings.TrimSuffix(fileName, ext)
+		if err := upload.Validate(fileHandle, file.Size, file.Filename, uploadRules()); err != nil {
+			uploadError(c, err)
+			return
 		}
 
-		fileExtension := strings.Join(fileExtensions, "")
+		fileExtension := modelFileExtension(file.Filename)
 		switch fileExtension {
 		case ".stl", ".3mf":
 			content, err := io.ReadAll(fileHandle)
@@ -160,7 +490,23 @@ func PreviewHandler() gin.HandlerFunc {
 
 			c.JSON(http.StatusOK, FilePreview{Type: previewType, ModelData: &encoded})
 			return
-			// case ".gcode.3mf":
+		case ".gcode.3mf":
+			pkg, err := mesh.Read3MF(fileHandle, file.Size)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("failed to read provided file: %v", err)})
+				return
+			}
+
+			preview := FilePreview{Type: FileTypeGCode3MF}
+			if image := pkg.PreviewImage(); len(image) > 0 {
+				encoded := base64.StdEncoding.EncodeToString(image)
+				preview.PreviewImage = &encoded
+			}
+
+			c.JSON(http.StatusOK, preview)
+			return
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported file type"})
 		}
 	}
 }
@@ -192,7 +538,11 @@ func GetUserPrintsHandler(printSvc *services.PrintService) gin.HandlerFunc {
 
 func AllPrintsHandler(printSvc *services.PrintService) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		prints, err := printSvc.AllPrints()
+		prints, err := printSvc.AllPrints(c.Query("sort"))
+		if errors.Is(err, services.ErrInvalidSort) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch prints"})
 			return
@@ -202,7 +552,7 @@ func AllPrintsHandler(printSvc *services.PrintService) gin.HandlerFunc {
 	}
 }
 
-func DeletePrintHandler(printSvc *services.PrintService, storageClient storage.StorageClient) gin.HandlerFunc {
+func DeletePrintHandler(printSvc *services.PrintService, blobSvc *services.BlobService, storageClient storage.StorageClient) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		idParam := c.Param("id")
 		printID, err := strconv.ParseUint(idParam, 10, 64)
@@ -217,11 +567,19 @@ func DeletePrintHandler(printSvc *services.PrintService, storageClient storage.S
 			return
 		}
 
-		if err := storageClient.DeleteFile(context.Background(), printItem.StoredFileName); err != nil {
-			log.Printf("failed to delete file: %s", printItem.StoredFileName)
+		if printItem.SlicedFileName != "" {
+			if err := storageClient.DeleteFile(context.Background(), printItem.SlicedFileName); err != nil {
+				log.Printf("failed to delete file: %s", printItem.SlicedFileName)
+			}
+		}
+		if printItem.ThumbnailFileName != "" {
+			if err := storageClient.DeleteFile(context.Background(), printItem.ThumbnailFileName); err != nil {
+				log.Printf("failed to delete file: %s", printItem.ThumbnailFileName)
+			}
 		}
 
-		if err := printSvc.DeletePrint(uint(printID)); err != nil {
+		// The model is only deleted once no other print of the same file is left
+		if err := blobSvc.DeletePrint(context.Background(), printItem); err != nil {
 			c.JSON(500, gin.H{"error": "failed to delete print"})
 			return
 		}
@@ -230,23 +588,84 @@ func DeletePrintHandler(printSvc *services.PrintService, storageClient storage.S
 	}
 }
 
+// DownloadPrintFileHandler serves any stored file by name to any signed in user.
+//
+// Deprecated: use PrintFileHandler, which checks the caller may see the print. Only routed with features.legacy_bucket_route.
 func DownloadPrintFileHandler(storageClient storage.StorageClient) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		filename := c.Param("filename")
-		reader, err := storageClient.GetFile(c, filename)
-		if err != nil {
-			c.JSON(404, gin.H{"error": "file not found"})
+
+		c.Header("Deprecation", "true")
+
+		if redirectSigned(c, storageClient, filename) {
 			return
 		}
-		c.Header("Content-Disposition", "attachment; filename="+filename)
-		c.Header("Content-Type", "application/octet-stream")
-		_, _ = io.Copy(c.Writer, reader)
+		serveObject(c, storageClient, filename, filename)
+	}
+}
+
+// viewablePrint loads the print named by the id parameter if the caller owns it or holds at least minRole. Prints of
+// other users are reported as not found, so their ids cannot be probed.
+func viewablePrint(c *gin.Context, printSvc *services.PrintService, minRole models.Role) (*models.Print, bool) {
+	user, exists := c.Get("user")
+	if !exists {
+		c.JSON(401, gin.H{"error": "unauthorized"})
+		return nil, false
+	}
+
+	claims, ok := user.(*util.CustomClaims)
+	if !ok {
+		c.JSON(401, gin.H{"error": "invalid token claims"})
+		return nil, false
+	}
+
+	printID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "invalid print id"})
+		return nil, false
+	}
+
+	printItem, err := printSvc.GetPrintByID(uint(printID))
+	if err != nil {
+		c.JSON(404, gin.H{"error": "print not found"})
+		return nil, false
+	}
+
+	if printItem.UserID != claims.UserID && models.Role(claims.Role).Permissions() < minRole.Permissions() {
+		c.JSON(404, gin.H{"error": "print not found"})
+		return nil, false
+	}
+	return printItem, true
+}
+
+// PrintFileHandler serves the model of a print to its owner, officers and admins. Providers that sign URLs serve it
+// themselves, otherwise it is proxied under its original file name.
+func PrintFileHandler(printSvc *services.PrintService, storageClient storage.StorageClient) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		printItem, ok := viewablePrint(c, printSvc, models.RoleOfficer)
+		if !ok {
+			return
+		}
+
+		if printItem.FilePurgedAt != nil {
+			c.JSON(http.StatusGone, gin.H{"error": services.ErrPrintFilePurged.Error()})
+			return
+		}
+
+		if redirectSigned(c, storageClient, printItem.StoredFileName) {
+			return
+		}
+		serveObject(c, storageClient, printItem.StoredFileName, printItem.UploadedFileName)
 	}
 }
 
 type UpdatePrintRequest struct {
 	Status       string `json:"status"`
 	DenialReason string `json:"denial_reason"`
+	PrinterID    *uint  `json:"printer_id"`
+	Priority     *int   `json:"priority"`
+	// ActualCost can be recorded when marking a print completed or afterwards
+	ActualCost *float64 `json:"actual_cost" binding:"omitempty,gte=0"`
 }
 
 func isValidPrintStatus(status string) bool {
@@ -265,8 +684,40 @@ func isValidPrintStatus(status string) bool {
 	}
 }
 
-func UpdatePrintHandler(printSvc *services.PrintService) gin.HandlerFunc {
+// statusChangeError responds with the status code matching a failed status change
+func statusChangeError(c *gin.Context, err error) {
+	var transitionErr *services.InvalidTransitionError
+	switch {
+	case errors.As(err, &transitionErr):
+		c.JSON(http.StatusConflict, gin.H{"error": transitionErr.Error()})
+	case errors.Is(err, services.ErrDenialReasonRequired), errors.Is(err, services.ErrDenialReasonNotDenied), errors.Is(err, services.ErrPrinterAssignment),
+		errors.Is(err, services.ErrPrintNotSliced), errors.Is(err, services.ErrPrinterFileType), errors.Is(err, services.ErrCostNotCompleted):
+		c.JSON(400, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrPrinterBusy), errors.Is(err, services.ErrPrintSlicing), errors.Is(err, services.ErrPrintFilePurged):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrPrintNotFound), errors.Is(err, services.ErrPrinterNotFound):
+		c.JSON(404, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrPrinterCommand):
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+	default:
+		c.JSON(500, gin.H{"error": "failed to update print"})
+	}
+}
+
+func UpdatePrintHandler(printSvc *services.PrintService, jobSvc *services.PrintJobService) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		user, exists := c.Get("user")
+		if !exists {
+			c.JSON(401, gin.H{"error": "unauthorized"})
+			return
+		}
+
+		claims, ok := user.(*util.CustomClaims)
+		if !ok {
+			c.JSON(401, gin.H{"error": "invalid token claims"})
+			return
+		}
+
 		idParam := c.Param("id")
 		printID, err := strconv.ParseUint(idParam, 10, 64)
 		if err != nil {
@@ -279,28 +730,121 @@ func UpdatePrintHandler(printSvc *services.PrintService) gin.HandlerFunc {
 			return
 		}
 
-		updates := make(map[string]interface{})
+		if req.PrinterID != nil && req.Status == "" {
+			c.JSON(400, gin.H{"error": services.ErrPrinterAssignment.Error()})
+			return
+		}
+
+		if req.DenialReason != "" && req.Status != "" && req.Status != string(models.StatusDenied) {
+			c.JSON(400, gin.H{"error": services.ErrDenialReasonNotDenied.Error()})
+			return
+		}
+
+		if req.Status == "" && req.DenialReason == "" && req.Priority == nil && req.ActualCost == nil {
+			c.JSON(400, gin.H{"error": "no fields to update"})
+			return
+		}
+
+		// Reject the cost before any status change is made
+		if req.ActualCost != nil && req.Status != string(models.StatusCompleted) {
+			printItem, err := printSvc.GetPrintByID(uint(printID))
+			if err != nil {
+				c.JSON(404, gin.H{"error": "print not found"})
+				return
+			}
+			if printItem.Status != models.StatusCompleted {
+				c.JSON(400, gin.H{"error": services.ErrCostNotCompleted.Error()})
+				return
+			}
+		}
 
 		if req.Status != "" {
 			if !isValidPrintStatus(req.Status) {
 				c.JSON(400, gin.H{"error": "invalid status"})
 				return
 			}
-			updates["status"] = req.Status
+
+			if err := jobSvc.UpdateStatus(c.Request.Context(), uint(printID), services.StatusChange{
+				To:        models.PrintStatus(req.Status),
+				Reason:    req.DenialReason,
+				ActorID:   &claims.UserID,
+				PrinterID: req.PrinterID,
+			}); err != nil {
+				statusChangeError(c, err)
+				return
+			}
 		}
-		if req.DenialReason != "" {
-			updates["denial_reason"] = req.DenialReason
+
+		if req.Status == "" && req.DenialReason != "" {
+			if err := printSvc.SetDenialReason(uint(printID), req.DenialReason); err != nil {
+				statusChangeError(c, err)
+				return
+			}
 		}
 
-		if len(updates) == 0 {
-			c.JSON(400, gin.H{"error": "no fields to update"})
+		updates := make(map[string]any)
+		if req.Priority != nil {
+			updates["priority"] = *req.Priority
+		}
+
+		if len(updates) > 0 {
+			if err := printSvc.UpdatePrint(uint(printID), updates); err != nil {
+				c.JSON(500, gin.H{"error": "failed to update print"})
+				return
+			}
+		}
+
+		if req.ActualCost != nil {
+			if err := printSvc.SetActualCost(uint(printID), *req.ActualCost); err != nil {
+				statusChangeError(c, err)
+				return
+			}
+		}
+
+		c.JSON(200, gin.H{"message": "print updated"})
+	}
+}
+
+// PrintHistoryHandler lists the status changes of a print to its owner and admins
+func PrintHistoryHandler(printSvc *services.PrintService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		printItem, ok := viewablePrint(c, printSvc, models.RoleAdmin)
+		if !ok {
 			return
 		}
 
-		if err := printSvc.UpdatePrint(uint(printID), updates); err != nil {
-			c.JSON(500, gin.H{"error": "failed to update print"})
+		history, err := printSvc.GetPrintHistory(printItem.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch print history"})
 			return
 		}
-		c.JSON(200, gin.H{"message": "print updated"})
+
+		c.JSON(http.StatusOK, history)
+	}
+}
+
+// PrintThumbnailHandler serves the stored preview image of a print to its owner, officers and admins
+func PrintThumbnailHandler(printSvc *services.PrintService, storageClient storage.StorageClient) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		printItem, ok := viewablePrint(c, printSvc, models.RoleOfficer)
+		if !ok {
+			return
+		}
+
+		if printItem.ThumbnailFileName == "" {
+			c.JSON(404, gin.H{"error": "print has no thumbnail"})
+			return
+		}
+
+		reader, err := storageClient.GetFile(c.Request.Context(), printItem.ThumbnailFileName)
+		if err != nil {
+			c.JSON(404, gin.H{"error": "thumbnail not found"})
+			return
+		}
+		defer reader.Close()
+
+		c.Header("Content-Type", "image/png")
+		c.Header("Cache-Control", "private, max-age=86400")
+		_, _ = io.Copy(c.Writer, reader)
 	}
 }
@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/torbenconto/spooler/internal/services"
+	"github.com/torbenconto/spooler/internal/util"
+)
+
+func QueueHandler(schedulerSvc *services.SchedulerService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		queue, err := schedulerSvc.Plan()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to plan queue"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"mode": schedulerSvc.Mode, "queue": queue})
+	}
+}
+
+// ConfirmQueueHandler starts a queued print on the printer the scheduler proposed for it
+func ConfirmQueueHandler(schedulerSvc *services.SchedulerService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, exists := c.Get("user")
+		if !exists {
+			c.JSON(401, gin.H{"error": "unauthorized"})
+			return
+		}
+
+		claims, ok := user.(*util.CustomClaims)
+		if !ok {
+			c.JSON(401, gin.H{"error": "invalid token claims"})
+			return
+		}
+
+		printID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(400, gin.H{"error": "invalid print id"})
+			return
+		}
+
+		if err := schedulerSvc.Confirm(c.Request.Context(), uint(printID), claims.UserID); err != nil {
+			if errors.Is(err, services.ErrNoPrinterProposed) {
+				c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+				return
+			}
+			statusChangeError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "print started"})
+	}
+}
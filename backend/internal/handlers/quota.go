@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/torbenconto/spooler/internal/models"
+	"github.com/torbenconto/spooler/internal/services"
+	"github.com/torbenconto/spooler/internal/util"
+)
+
+// SetQuotaRequest replaces the quota override of a user, omitted limits fall back to the role default and 0 is unlimited
+type SetQuotaRequest struct {
+	MaxOpenPrints    *int     `json:"max_open_prints" binding:"omitempty,gte=0"`
+	MaxGramsPerWeek  *float64 `json:"max_grams_per_week" binding:"omitempty,gte=0"`
+	MaxGramsPerMonth *float64 `json:"max_grams_per_month" binding:"omitempty,gte=0"`
+	MaxFileSize      *int64   `json:"max_file_size" binding:"omitempty,gte=0"`
+}
+
+func MyQuotaHandler(quotaSvc *services.QuotaService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, exists := c.Get("user")
+		if !exists {
+			c.JSON(401, gin.H{"error": "unauthorized"})
+			return
+		}
+
+		claims, ok := user.(*util.CustomClaims)
+		if !ok {
+			c.JSON(401, gin.H{"error": "invalid token claims"})
+			return
+		}
+
+		status, err := quotaSvc.GetQuotaStatus(claims.UserID, models.Role(claims.Role))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch quota"})
+			return
+		}
+
+		c.JSON(http.StatusOK, status)
+	}
+}
+
+func GetUserQuotaHandler(quotaSvc *services.QuotaService, userSvc *services.UserService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"})
+			return
+		}
+
+		user, err := userSvc.GetUserByID(uint(userID))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return
+		}
+
+		status, err := quotaSvc.GetQuotaStatus(user.ID, models.Role(user.Role))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch quota"})
+			return
+		}
+
+		c.JSON(http.StatusOK, status)
+	}
+}
+
+func SetUserQuotaHandler(quotaSvc *services.QuotaService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"})
+			return
+		}
+
+		var req SetQuotaRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+			return
+		}
+
+		override := models.QuotaOverride{
+			UserID:           uint(userID),
+			MaxOpenPrints:    req.MaxOpenPrints,
+			MaxGramsPerWeek:  req.MaxGramsPerWeek,
+			MaxGramsPerMonth: req.MaxGramsPerMonth,
+			MaxFileSize:      req.MaxFileSize,
+		}
+		if err := quotaSvc.SetOverride(&override); err != nil {
+			if errors.Is(err, services.ErrUserNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update quota"})
+			return
+		}
+
+		c.JSON(http.StatusOK, override)
+	}
+}
+
+func DeleteUserQuotaHandler(quotaSvc *services.QuotaService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"})
+			return
+		}
+
+		if err := quotaSvc.DeleteOverride(uint(userID)); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to reset quota"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "quota reset to role default"})
+	}
+}
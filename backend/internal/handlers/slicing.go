@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/torbenconto/spooler/internal/services"
+)
+
+// SlicePrintHandler slices a print again in the background, e.g. after a failed run or a profile change
+func SlicePrintHandler(printSvc *services.PrintService, slicingSvc *services.SlicingService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		printID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(400, gin.H{"error": "invalid print id"})
+			return
+		}
+
+		print, err := printSvc.GetPrintByID(uint(printID))
+		if err != nil {
+			c.JSON(404, gin.H{"error": "print not found"})
+			return
+		}
+
+		if err := slicingSvc.SliceInBackground(print.ID); err != nil {
+			switch {
+			case errors.Is(err, services.ErrSlicingDisabled):
+				c.JSON(http.StatusNotImplemented, gin.H{"error": err.Error()})
+			case errors.Is(err, services.ErrNotSliceable):
+				c.JSON(400, gin.H{"error": err.Error()})
+			case errors.Is(err, services.ErrPrintNotFound):
+				c.JSON(404, gin.H{"error": "print not found"})
+			case errors.Is(err, services.ErrSliceNotQueued), errors.Is(err, services.ErrPrintSlicing):
+				c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			default:
+				c.JSON(500, gin.H{"error": "failed to start slicing"})
+			}
+			return
+		}
+
+		c.JSON(http.StatusAccepted, gin.H{"message": "slicing started"})
+	}
+}
+
+func SliceJobsHandler(slicingSvc *services.SlicingService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		printID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(400, gin.H{"error": "invalid print id"})
+			return
+		}
+
+		jobs, err := slicingSvc.GetSliceJobs(uint(printID))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch slice jobs"})
+			return
+		}
+
+		c.JSON(http.StatusOK, jobs)
+	}
+}
@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/torbenconto/spooler/config"
+	"github.com/torbenconto/spooler/internal/models"
+	"github.com/torbenconto/spooler/internal/services"
+	"github.com/torbenconto/spooler/internal/upload"
+	"github.com/torbenconto/spooler/internal/util"
+)
+
+// uploadOffsetHeader carries the number of bytes an upload has received, as in the tus protocol
+const uploadOffsetHeader = "Upload-Offset"
+
+type CreateUploadRequest struct {
+	FileName string `json:"file_name" binding:"required"`
+	FileSize int64  `json:"file_size" binding:"gt=0"`
+	// SHA256 is the hex checksum of the whole file, the upload is rejected if the received chunks do not match it
+	SHA256 string `json:"sha256" binding:"required,len=64,hexadecimal"`
+}
+
+// uploadResponse describes the progress of an upload
+func uploadResponse(c *gin.Context, status int, resumable *models.Upload) {
+	c.Header(uploadOffsetHeader, strconv.FormatInt(resumable.Offset, 10))
+	c.JSON(status, gin.H{
+		"id":             resumable.ID,
+		"file_name":      resumable.FileName,
+		"file_size":      resumable.FileSize,
+		"offset":         resumable.Offset,
+		"complete":       resumable.Offset == resumable.FileSize,
+		"max_chunk_size": config.Cfg.Uploads.Resumable.MaxChunkSize,
+		"expires_at":     resumable.ExpiresAt,
+	})
+}
+
+// CreateUploadHandler starts a resumable upload. The file is then sent in chunks to UploadChunkHandler and the upload
+// submitted to NewPrintHandler by its id in place of the file.
+func CreateUploadHandler(uploadSvc *services.UploadService, quotaSvc *services.QuotaService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, exists := c.Get("user")
+		if !exists {
+			c.JSON(401, gin.H{"error": "unauthorized"})
+			return
+		}
+
+		claims, ok := user.(*util.CustomClaims)
+		if !ok {
+			c.JSON(401, gin.H{"error": "invalid token claims"})
+			return
+		}
+
+		var req CreateUploadRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": "invalid request body"})
+			return
+		}
+
+		// The content is validated once the upload is submitted as a print
+		if err := upload.CheckName(req.FileName, req.FileSize, uploadRules()); err != nil {
+			uploadError(c, err)
+			return
+		}
+
+		quota, err := quotaSvc.GetQuotaStatus(claims.UserID, models.Role(claims.Role))
+		if err != nil {
+			c.JSON(500, gin.H{"error": "failed to check quota"})
+			return
+		}
+		if err := quota.Allows(req.FileSize, 0); err != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+
+		resumable := models.Upload{
+			UserID:   claims.UserID,
+			FileName: req.FileName,
+			FileSize: req.FileSize,
+			SHA256:   strings.ToLower(req.SHA256),
+		}
+		if err := uploadSvc.CreateUpload(&resumable); err != nil {
+			c.JSON(500, gin.H{"error": "failed to create upload"})
+			return
+		}
+
+		c.Header("Location", "/uploads/"+resumable.ID)
+		uploadResponse(c, http.StatusCreated, &resumable)
+	}
+}
+
+// UploadStatusHandler returns how much of an upload was received, an interrupted upload resumes from its offset
+func UploadStatusHandler(uploadSvc *services.UploadService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, exists := c.Get("user")
+		if !exists {
+			c.JSON(401, gin.H{"error": "unauthorized"})
+			return
+		}
+
+		claims, ok := user.(*util.CustomClaims)
+		if !ok {
+			c.JSON(401, gin.H{"error": "invalid token claims"})
+			return
+		}
+
+		resumable, err := uploadSvc.GetUpload(c.Param("id"), claims.UserID)
+		if errors.Is(err, services.ErrUploadNotFound) {
+			c.JSON(404, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(500, gin.H{"error": "failed to fetch upload"})
+			return
+		}
+
+		uploadResponse(c, http.StatusOK, resumable)
+	}
+}
+
+// UploadChunkHandler appends the request body to an upload. The Upload-Offset header must match the offset of the
+// upload, so a chunk that is sent again after a lost response is not appended twice.
+func UploadChunkHandler(uploadSvc *services.UploadService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, exists := c.Get("user")
+		if !exists {
+			c.JSON(401, gin.H{"error": "unauthorized"})
+			return
+		}
+
+		claims, ok := user.(*util.CustomClaims)
+		if !ok {
+			c.JSON(401, gin.H{"error": "invalid token claims"})
+			return
+		}
+
+		offset, err := strconv.ParseInt(c.GetHeader(uploadOffsetHeader), 10, 64)
+		if err != nil || offset < 0 {
+			c.JSON(400, gin.H{"error": "invalid Upload-Offset header"})
+			return
+		}
+
+		resumable, err := uploadSvc.AppendChunk(c.Request.Context(), c.Param("id"), claims.UserID, offset, c.Request.Body)
+		switch {
+		case errors.Is(err, services.ErrUploadNotFound):
+			c.JSON(404, gin.H{"error": err.Error()})
+			return
+		case errors.Is(err, services.ErrUploadOffset):
+			c.Header(uploadOffsetHeader, strconv.FormatInt(resumable.Offset, 10))
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "offset": resumable.Offset})
+			return
+		case errors.Is(err, services.ErrUploadChunk):
+			c.JSON(400, gin.H{"error": err.Error(), "max_chunk_size": config.Cfg.Uploads.Resumable.MaxChunkSize})
+			return
+		case err != nil:
+			log.Printf("failed to append chunk to upload %s: %v", c.Param("id"), err)
+			c.JSON(500, gin.H{"error": "failed to store chunk"})
+			return
+		}
+
+		uploadResponse(c, http.StatusOK, resumable)
+	}
+}
+
+// DeleteUploadHandler abandons an upload and deletes its chunks
+func DeleteUploadHandler(uploadSvc *services.UploadService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, exists := c.Get("user")
+		if !exists {
+			c.JSON(401, gin.H{"error": "unauthorized"})
+			return
+		}
+
+		claims, ok := user.(*util.CustomClaims)
+		if !ok {
+			c.JSON(401, gin.H{"error": "invalid token claims"})
+			return
+		}
+
+		resumable, err := uploadSvc.GetUpload(c.Param("id"), claims.UserID)
+		if errors.Is(err, services.ErrUploadNotFound) {
+			c.JSON(404, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(500, gin.H{"error": "failed to fetch upload"})
+			return
+		}
+
+		if err := uploadSvc.DeleteUpload(c.Request.Context(), resumable); err != nil {
+			c.JSON(500, gin.H{"error": "failed to delete upload"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "upload deleted"})
+	}
+}
@@ -0,0 +1,125 @@
+package mesh
+
+import "math"
+
+type Vec3 struct {
+	X, Y, Z float64
+}
+
+func (a Vec3) Sub(b Vec3) Vec3 {
+	return Vec3{a.X - b.X, a.Y - b.Y, a.Z - b.Z}
+}
+
+func (a Vec3) Cross(b Vec3) Vec3 {
+	return Vec3{
+		a.Y*b.Z - a.Z*b.Y,
+		a.Z*b.X - a.X*b.Z,
+		a.X*b.Y - a.Y*b.X,
+	}
+}
+
+func (a Vec3) Dot(b Vec3) float64 {
+	return a.X*b.X + a.Y*b.Y + a.Z*b.Z
+}
+
+func (a Vec3) Length() float64 {
+	return math.Sqrt(a.Dot(a))
+}
+
+type Triangle [3]Vec3
+
+// Stats describes the geometry of a mesh, lengths are in the units of the source file which is millimeters for every format spooler accepts
+type Stats struct {
+	TriangleCount int
+	Min           Vec3
+	Max           Vec3
+	Volume        float64
+	SurfaceArea   float64
+	// NonManifoldEdges counts edges not shared by exactly two triangles, a watertight mesh has none
+	NonManifoldEdges int
+	// DegenerateTriangles counts triangles with no area or repeated vertices
+	DegenerateTriangles int
+}
+
+// Size returns the dimensions of the bounding box
+func (s *Stats) Size() Vec3 {
+	if s.TriangleCount == 0 {
+		return Vec3{}
+	}
+	return s.Max.Sub(s.Min)
+}
+
+// Analyzer accumulates statistics over a stream of triangles so large meshes never have to be held in memory
+type Analyzer struct {
+	stats        Stats
+	signedVolume float64
+	vertices     map[Vec3]uint32
+	edges        map[uint64]uint32
+}
+
+func NewAnalyzer() *Analyzer {
+	return &Analyzer{
+		vertices: make(map[Vec3]uint32),
+		edges:    make(map[uint64]uint32),
+		stats: Stats{
+			Min: Vec3{math.Inf(1), math.Inf(1), math.Inf(1)},
+			Max: Vec3{math.Inf(-1), math.Inf(-1), math.Inf(-1)},
+		},
+	}
+}
+
+func (a *Analyzer) vertexIndex(v Vec3) uint32 {
+	if idx, ok := a.vertices[v]; ok {
+		return idx
+	}
+	idx := uint32(len(a.vertices))
+	a.vertices[v] = idx
+	return idx
+}
+
+func edgeKey(i, j uint32) uint64 {
+	if i > j {
+		i, j = j, i
+	}
+	return uint64(i)<<32 | uint64(j)
+}
+
+func (a *Analyzer) Add(t Triangle) {
+	a.stats.TriangleCount++
+
+	for _, v := range t {
+		a.stats.Min = Vec3{math.Min(a.stats.Min.X, v.X), math.Min(a.stats.Min.Y, v.Y), math.Min(a.stats.Min.Z, v.Z)}
+		a.stats.Max = Vec3{math.Max(a.stats.Max.X, v.X), math.Max(a.stats.Max.Y, v.Y), math.Max(a.stats.Max.Z, v.Z)}
+	}
+
+	cross := t[1].Sub(t[0]).Cross(t[2].Sub(t[0]))
+	area := cross.Length() / 2
+	a.stats.SurfaceArea += area
+	// Sum of signed tetrahedron volumes against the origin
+	a.signedVolume += t[0].Dot(t[1].Cross(t[2])) / 6
+
+	i0, i1, i2 := a.vertexIndex(t[0]), a.vertexIndex(t[1]), a.vertexIndex(t[2])
+	if i0 == i1 || i1 == i2 || i0 == i2 || area < 1e-12 {
+		a.stats.DegenerateTriangles++
+	}
+
+	a.edges[edgeKey(i0, i1)]++
+	a.edges[edgeKey(i1, i2)]++
+	a.edges[edgeKey(i2, i0)]++
+}
+
+func (a *Analyzer) Stats() Stats {
+	stats := a.stats
+	stats.Volume = math.Abs(a.signedVolume)
+	if stats.TriangleCount == 0 {
+		stats.Min, stats.Max = Vec3{}, Vec3{}
+	}
+
+	for _, count := range a.edges {
+		if count != 2 {
+			stats.NonManifoldEdges++
+		}
+	}
+
+	return stats
+}
@@ -0,0 +1,193 @@
+package mesh
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+)
+
+const (
+	// Renders are supersampled and box filtered down to smooth edges
+	supersample = 2
+	// Fraction of the image left empty around the model
+	renderMargin = 0.06
+	ambientLight = 0.3
+)
+
+var (
+	// Isometric view from the front right, above the model. right × up = toward the viewer.
+	viewRight  = Vec3{1 / math.Sqrt2, 1 / math.Sqrt2, 0}
+	viewUp     = Vec3{-1 / math.Sqrt(6), 1 / math.Sqrt(6), 2 / math.Sqrt(6)}
+	viewToward = Vec3{1 / math.Sqrt(3), -1 / math.Sqrt(3), 1 / math.Sqrt(3)}
+
+	lightDirection = normalize(Vec3{0.3, -0.5, 1})
+)
+
+func normalize(v Vec3) Vec3 {
+	length := v.Length()
+	if length == 0 {
+		return v
+	}
+	return Vec3{v.X / length, v.Y / length, v.Z / length}
+}
+
+// Renderer draws an isometric, flat shaded image of a mesh with a software z-buffer.
+// Triangles are streamed in one at a time so large meshes never have to be held in memory, the bounds must be known up front.
+type Renderer struct {
+	width, height int
+	color         color.RGBA
+
+	scale            float64
+	offsetX, offsetY float64
+
+	depth []float64
+	shade []float64
+}
+
+// NewRenderer prepares a width by height render of a mesh within the bounding box lo to hi, drawn in c
+func NewRenderer(lo, hi Vec3, width, height int, c color.RGBA) *Renderer {
+	w, h := width*supersample, height*supersample
+	r := &Renderer{
+		width:  w,
+		height: h,
+		color:  c,
+		depth:  make([]float64, w*h),
+		shade:  make([]float64, w*h),
+	}
+	for i := range r.depth {
+		r.depth[i] = math.Inf(-1)
+	}
+
+	// Fit the projected corners of the bounding box into the image
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	for i := 0; i < 8; i++ {
+		corner := lo
+		if i&1 != 0 {
+			corner.X = hi.X
+		}
+		if i&2 != 0 {
+			corner.Y = hi.Y
+		}
+		if i&4 != 0 {
+			corner.Z = hi.Z
+		}
+		x, y := corner.Dot(viewRight), -corner.Dot(viewUp)
+		minX, maxX = math.Min(minX, x), math.Max(maxX, x)
+		minY, maxY = math.Min(minY, y), math.Max(maxY, y)
+	}
+
+	usable := 1 - 2*renderMargin
+	spanX, spanY := maxX-minX, maxY-minY
+	r.scale = math.Inf(1)
+	if spanX > 0 {
+		r.scale = float64(w) * usable / spanX
+	}
+	if spanY > 0 {
+		r.scale = math.Min(r.scale, float64(h)*usable/spanY)
+	}
+	if math.IsInf(r.scale, 1) {
+		r.scale = 1
+	}
+
+	// Center the model
+	r.offsetX = float64(w)/2 - (minX+maxX)/2*r.scale
+	r.offsetY = float64(h)/2 - (minY+maxY)/2*r.scale
+
+	return r
+}
+
+func (r *Renderer) project(v Vec3) (float64, float64, float64) {
+	return v.Dot(viewRight)*r.scale + r.offsetX, -v.Dot(viewUp)*r.scale + r.offsetY, v.Dot(viewToward)
+}
+
+// Add rasterizes a triangle. Faces are lit from both sides since the winding order of uploaded meshes cannot be trusted.
+func (r *Renderer) Add(t Triangle) {
+	normal := normalize(t[1].Sub(t[0]).Cross(t[2].Sub(t[0])))
+	if normal == (Vec3{}) {
+		return
+	}
+	shade := ambientLight + (1-ambientLight)*math.Abs(normal.Dot(lightDirection))
+
+	x0, y0, z0 := r.project(t[0])
+	x1, y1, z1 := r.project(t[1])
+	x2, y2, z2 := r.project(t[2])
+
+	area := (x1-x0)*(y2-y0) - (x2-x0)*(y1-y0)
+	if area == 0 {
+		return
+	}
+
+	minX := max(int(math.Floor(min(x0, x1, x2))), 0)
+	maxX := min(int(math.Ceil(max(x0, x1, x2))), r.width-1)
+	minY := max(int(math.Floor(min(y0, y1, y2))), 0)
+	maxY := min(int(math.Ceil(max(y0, y1, y2))), r.height-1)
+
+	for py := minY; py <= maxY; py++ {
+		cy := float64(py) + 0.5
+		for px := minX; px <= maxX; px++ {
+			cx := float64(px) + 0.5
+
+			// Barycentric weights, all share the sign of the area inside the triangle
+			w0 := ((x1-cx)*(y2-cy) - (x2-cx)*(y1-cy)) / area
+			w1 := ((x2-cx)*(y0-cy) - (x0-cx)*(y2-cy)) / area
+			w2 := 1 - w0 - w1
+			if w0 < 0 || w1 < 0 || w2 < 0 {
+				continue
+			}
+
+			z := w0*z0 + w1*z1 + w2*z2
+			i := py*r.width + px
+			if z > r.depth[i] {
+				r.depth[i] = z
+				r.shade[i] = shade
+			}
+		}
+	}
+}
+
+// Image returns the render on a transparent background
+func (r *Renderer) Image() *image.NRGBA {
+	w, h := r.width/supersample, r.height/supersample
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var shade float64
+			var covered int
+			for sy := 0; sy < supersample; sy++ {
+				for sx := 0; sx < supersample; sx++ {
+					i := (y*supersample+sy)*r.width + x*supersample + sx
+					if !math.IsInf(r.depth[i], -1) {
+						shade += r.shade[i]
+						covered++
+					}
+				}
+			}
+			if covered == 0 {
+				continue
+			}
+
+			shade /= float64(covered)
+			img.SetNRGBA(x, y, color.NRGBA{
+				R: uint8(float64(r.color.R) * shade),
+				G: uint8(float64(r.color.G) * shade),
+				B: uint8(float64(r.color.B) * shade),
+				A: uint8(255 * covered / (supersample * supersample)),
+			})
+		}
+	}
+
+	return img
+}
+
+// PNG encodes the render
+func (r *Renderer) PNG() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, r.Image()); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,164 @@
+package mesh
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+)
+
+var ErrInvalidSTL = errors.New("invalid stl file")
+
+const (
+	stlHeaderSize   = 80
+	stlTriangleSize = 50
+)
+
+// ReadSTL parses an ASCII or binary STL file, calling fn for every triangle in the file
+func ReadSTL(r io.Reader, fn func(Triangle) error) error {
+	br := bufio.NewReaderSize(r, 64*1024)
+
+	ascii, err := isASCIISTL(br)
+	if err != nil {
+		return err
+	}
+
+	if ascii {
+		return readASCIISTL(br, fn)
+	}
+	return readBinarySTL(br, fn)
+}
+
+// AnalyzeSTL computes the geometry statistics of an STL file
+func AnalyzeSTL(r io.Reader) (*Stats, error) {
+	analyzer := NewAnalyzer()
+	if err := ReadSTL(r, func(t Triangle) error {
+		analyzer.Add(t)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	stats := analyzer.Stats()
+	if stats.TriangleCount == 0 {
+		return nil, fmt.Errorf("%w: file contains no triangles", ErrInvalidSTL)
+	}
+	return &stats, nil
+}
+
+// isASCIISTL tells ASCII and binary files apart. Some exporters write binary files whose header starts with "solid",
+// so the first keyword after the solid line must also be an ASCII keyword.
+func isASCIISTL(br *bufio.Reader) (bool, error) {
+	head, err := br.Peek(512)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, bufio.ErrBufferFull) {
+		return false, err
+	}
+
+	trimmed := bytes.TrimLeft(head, " \t\r\n")
+	if !bytes.HasPrefix(trimmed, []byte("solid")) {
+		return false, nil
+	}
+
+	newline := bytes.IndexByte(trimmed, '\n')
+	if newline < 0 {
+		// A single line file cannot be binary, it would be shorter than the header
+		return len(head) < stlHeaderSize+4, nil
+	}
+
+	rest := bytes.TrimLeft(trimmed[newline+1:], " \t\r\n")
+	return bytes.HasPrefix(rest, []byte("facet")) || bytes.HasPrefix(rest, []byte("endsolid")), nil
+}
+
+func readASCIISTL(r io.Reader, fn func(Triangle) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	scanner.Split(bufio.ScanWords)
+
+	var (
+		vertices []Vec3
+		inFacet  bool
+	)
+
+	for scanner.Scan() {
+		switch scanner.Text() {
+		case "facet":
+			if inFacet {
+				return fmt.Errorf("%w: facet not closed", ErrInvalidSTL)
+			}
+			inFacet = true
+			vertices = vertices[:0]
+		case "vertex":
+			if !inFacet {
+				return fmt.Errorf("%w: vertex outside of facet", ErrInvalidSTL)
+			}
+			var coords [3]float64
+			for i := range coords {
+				if !scanner.Scan() {
+					return fmt.Errorf("%w: truncated vertex", ErrInvalidSTL)
+				}
+				value, err := strconv.ParseFloat(scanner.Text(), 64)
+				if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+					return fmt.Errorf("%w: invalid vertex coordinate %q", ErrInvalidSTL, scanner.Text())
+				}
+				coords[i] = value
+			}
+			vertices = append(vertices, Vec3{coords[0], coords[1], coords[2]})
+		case "endfacet":
+			if !inFacet || len(vertices) != 3 {
+				return fmt.Errorf("%w: facet must have exactly 3 vertices", ErrInvalidSTL)
+			}
+			inFacet = false
+			if err := fn(Triangle{vertices[0], vertices[1], vertices[2]}); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if inFacet {
+		return fmt.Errorf("%w: unexpected end of file inside facet", ErrInvalidSTL)
+	}
+	return nil
+}
+
+func readBinarySTL(r io.Reader, fn func(Triangle) error) error {
+	var header [stlHeaderSize + 4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return fmt.Errorf("%w: file shorter than binary header", ErrInvalidSTL)
+	}
+	count := binary.LittleEndian.Uint32(header[stlHeaderSize:])
+
+	var buf [stlTriangleSize]byte
+	for i := uint32(0); i < count; i++ {
+		if _, err := io.ReadFull(r, buf[:]); err != nil {
+			return fmt.Errorf("%w: header declares %d triangles but file ends after %d", ErrInvalidSTL, count, i)
+		}
+
+		var t Triangle
+		// The first 12 bytes are the facet normal which is recomputed from the vertices when needed
+		for v := 0; v < 3; v++ {
+			offset := 12 + v*12
+			t[v] = Vec3{
+				float64(math.Float32frombits(binary.LittleEndian.Uint32(buf[offset:]))),
+				float64(math.Float32frombits(binary.LittleEndian.Uint32(buf[offset+4:]))),
+				float64(math.Float32frombits(binary.LittleEndian.Uint32(buf[offset+8:]))),
+			}
+			if math.IsNaN(t[v].X) || math.IsNaN(t[v].Y) || math.IsNaN(t[v].Z) ||
+				math.IsInf(t[v].X, 0) || math.IsInf(t[v].Y, 0) || math.IsInf(t[v].Z, 0) {
+				return fmt.Errorf("%w: invalid vertex in triangle %d", ErrInvalidSTL, i)
+			}
+		}
+
+		if err := fn(t); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
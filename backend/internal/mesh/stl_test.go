@@ -0,0 +1,115 @@
+package mesh
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+)
+
+// testTetrahedron is a closed tetrahedron with 10mm legs and outward facing triangles
+var testTetrahedron = []Triangle{
+	{{0, 0, 0}, {0, 10, 0}, {10, 0, 0}},
+	{{0, 0, 0}, {10, 0, 0}, {0, 0, 10}},
+	{{0, 0, 0}, {0, 0, 10}, {0, 10, 0}},
+	{{10, 0, 0}, {0, 10, 0}, {0, 0, 10}},
+}
+
+func asciiSTL(triangles []Triangle) string {
+	var b strings.Builder
+	b.WriteString("solid tetrahedron\n")
+	for _, t := range triangles {
+		b.WriteString("  facet normal 0 0 0\n    outer loop\n")
+		for _, v := range t {
+			fmt.Fprintf(&b, "      vertex %g %g %g\n", v.X, v.Y, v.Z)
+		}
+		b.WriteString("    endloop\n  endfacet\n")
+	}
+	b.WriteString("endsolid tetrahedron\n")
+	return b.String()
+}
+
+// binarySTL encodes triangles as a binary STL with the given header, count overrides the declared triangle count when
+// it is not negative
+func binarySTL(header string, triangles []Triangle, count int) string {
+	var buf bytes.Buffer
+	var head [stlHeaderSize]byte
+	copy(head[:], header)
+	buf.Write(head[:])
+
+	if count < 0 {
+		count = len(triangles)
+	}
+	binary.Write(&buf, binary.LittleEndian, uint32(count))
+	for _, t := range triangles {
+		values := make([]float32, 0, 12)
+		values = append(values, 0, 0, 0)
+		for _, v := range t {
+			values = append(values, float32(v.X), float32(v.Y), float32(v.Z))
+		}
+		binary.Write(&buf, binary.LittleEndian, values)
+		buf.Write([]byte{0, 0})
+	}
+	return buf.String()
+}
+
+func TestAnalyzeSTL(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+	}{
+		{"ascii", asciiSTL(testTetrahedron)},
+		{"binary", binarySTL("exported by a slicer", testTetrahedron, -1)},
+		{"binary with solid header", binarySTL("solid tetrahedron", testTetrahedron, -1)},
+		{"binary with solid header line", binarySTL("solid tetrahedron\n", testTetrahedron, -1)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stats, err := AnalyzeSTL(strings.NewReader(tt.file))
+			if err != nil {
+				t.Fatalf("AnalyzeSTL: %v", err)
+			}
+			if stats.TriangleCount != 4 {
+				t.Errorf("TriangleCount = %d, want 4", stats.TriangleCount)
+			}
+			if size := stats.Size(); size != (Vec3{10, 10, 10}) {
+				t.Errorf("Size = %v, want 10x10x10", size)
+			}
+			if want := 1000.0 / 6; math.Abs(stats.Volume-want) > 1e-6 {
+				t.Errorf("Volume = %v, want %v", stats.Volume, want)
+			}
+			if stats.NonManifoldEdges != 0 || stats.DegenerateTriangles != 0 {
+				t.Errorf("NonManifoldEdges = %d, DegenerateTriangles = %d, want a clean mesh", stats.NonManifoldEdges, stats.DegenerateTriangles)
+			}
+		})
+	}
+}
+
+func TestAnalyzeSTLRejectsInvalidFiles(t *testing.T) {
+	nan := math.NaN()
+	tests := []struct {
+		name string
+		file string
+	}{
+		{"empty", ""},
+		{"ascii without facets", "solid empty\nendsolid empty\n"},
+		{"ascii with two vertices", "solid bad\nfacet normal 0 0 0\nouter loop\nvertex 0 0 0\nvertex 1 0 0\nendloop\nendfacet\nendsolid bad\n"},
+		{"ascii with invalid coordinate", "solid bad\nfacet normal 0 0 0\nouter loop\nvertex 0 0 x\n"},
+		{"ascii ending inside facet", "solid bad\nfacet normal 0 0 0\nouter loop\nvertex 0 0 0\n"},
+		{"binary shorter than header", "not an stl"},
+		{"truncated binary", binarySTL("", testTetrahedron, 5)},
+		{"binary with nan vertex", binarySTL("", []Triangle{{{0, 0, 0}, {nan, 0, 0}, {0, 1, 0}}}, -1)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := AnalyzeSTL(strings.NewReader(tt.file)); !errors.Is(err, ErrInvalidSTL) {
+				t.Fatalf("AnalyzeSTL = %v, want ErrInvalidSTL", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,459 @@
+package mesh
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalid3MF = errors.New("invalid 3mf file")
+
+const (
+	defaultModelPath    = "3D/3dmodel.model"
+	modelRelationship   = "http://schemas.microsoft.com/3dmanufacturing/2013/01/3dmodel"
+	maxComponentDepth   = 16
+	max3MFPartSize      = 1 << 30
+	max3MFThumbnailSize = 16 << 20
+)
+
+// max3MFTriangles bounds the triangles all build items resolve to. Components can reference the same object many times
+// over, so a small file can otherwise expand into more triangles than fit in memory. Every component counts as one.
+var max3MFTriangles = 5_000_000
+
+var platePartRegex = regexp.MustCompile(`^Metadata/plate_(\d+)\.(png|gcode|json)$`)
+
+// Matrix is a 3MF affine transform stored row major as m00 m01 m02 m10 m11 m12 m20 m21 m22 m30 m31 m32.
+// Points are row vectors, the last row holds the translation.
+type Matrix [12]float64
+
+var Identity = Matrix{1, 0, 0, 0, 1, 0, 0, 0, 1, 0, 0, 0}
+
+func (m Matrix) Apply(v Vec3) Vec3 {
+	return Vec3{
+		v.X*m[0] + v.Y*m[3] + v.Z*m[6] + m[9],
+		v.X*m[1] + v.Y*m[4] + v.Z*m[7] + m[10],
+		v.X*m[2] + v.Y*m[5] + v.Z*m[8] + m[11],
+	}
+}
+
+// Then returns the transform applying m first and n second
+func (m Matrix) Then(n Matrix) Matrix {
+	var out Matrix
+	for row := 0; row < 4; row++ {
+		for col := 0; col < 3; col++ {
+			var sum float64
+			for k := 0; k < 3; k++ {
+				sum += m[row*3+k] * n[k*3+col]
+			}
+			if row == 3 {
+				sum += n[9+col]
+			}
+			out[row*3+col] = sum
+		}
+	}
+	return out
+}
+
+func (m Matrix) String() string {
+	parts := make([]string, len(m))
+	for i, v := range m {
+		parts[i] = strconv.FormatFloat(v, 'g', -1, 64)
+	}
+	return strings.Join(parts, " ")
+}
+
+func parseMatrix(s string) (Matrix, error) {
+	if strings.TrimSpace(s) == "" {
+		return Identity, nil
+	}
+
+	fields := strings.Fields(s)
+	if len(fields) != 12 {
+		return Matrix{}, fmt.Errorf("%w: transform must have 12 values", ErrInvalid3MF)
+	}
+
+	var m Matrix
+	for i, f := range fields {
+		v, err := strconv.ParseFloat(f, 64)
+		if err != nil {
+			return Matrix{}, fmt.Errorf("%w: invalid transform value %q", ErrInvalid3MF, f)
+		}
+		m[i] = v
+	}
+	return m, nil
+}
+
+// BuildItem is an object placed on the build plate with its transform applied to its triangles
+type BuildItem struct {
+	ObjectID  int
+	Name      string
+	Transform Matrix
+	Triangles []Triangle
+}
+
+func (b *BuildItem) Stats() Stats {
+	analyzer := NewAnalyzer()
+	for _, t := range b.Triangles {
+		analyzer.Add(t)
+	}
+	return analyzer.Stats()
+}
+
+// Package is the content of a 3MF or sliced .gcode.3mf project
+type Package struct {
+	Items      []BuildItem
+	PlateCount int
+	// Thumbnail is the project thumbnail, PlateThumbnails are keyed by plate number
+	Thumbnail       []byte
+	PlateThumbnails map[int][]byte
+	// HasGCode is set for sliced projects that carry plate G-code
+	HasGCode bool
+}
+
+// Stats returns the geometry of every build item combined
+func (p *Package) Stats() Stats {
+	analyzer := NewAnalyzer()
+	for _, item := range p.Items {
+		for _, t := range item.Triangles {
+			analyzer.Add(t)
+		}
+	}
+	return analyzer.Stats()
+}
+
+// PreviewImage returns the project thumbnail, falling back to the first plate's thumbnail
+func (p *Package) PreviewImage() []byte {
+	if len(p.Thumbnail) > 0 {
+		return p.Thumbnail
+	}
+
+	plates := make([]int, 0, len(p.PlateThumbnails))
+	for plate := range p.PlateThumbnails {
+		plates = append(plates, plate)
+	}
+	sort.Ints(plates)
+	if len(plates) == 0 {
+		return nil
+	}
+	return p.PlateThumbnails[plates[0]]
+}
+
+type xmlModel struct {
+	Unit      string `xml:"unit,attr"`
+	Resources struct {
+		Objects []xmlObject `xml:"object"`
+	} `xml:"resources"`
+	Build struct {
+		Items []xmlComponent `xml:"item"`
+	} `xml:"build"`
+}
+
+type xmlObject struct {
+	ID   int    `xml:"id,attr"`
+	Name string `xml:"name,attr"`
+	Mesh *struct {
+		Vertices []struct {
+			X float64 `xml:"x,attr"`
+			Y float64 `xml:"y,attr"`
+			Z float64 `xml:"z,attr"`
+		} `xml:"vertices>vertex"`
+		Triangles []struct {
+			V1 int `xml:"v1,attr"`
+			V2 int `xml:"v2,attr"`
+			V3 int `xml:"v3,attr"`
+		} `xml:"triangles>triangle"`
+	} `xml:"mesh"`
+	Components []xmlComponent `xml:"components>component"`
+}
+
+// xmlComponent is shared by build items and components, both reference an object by id, optionally in another model part
+type xmlComponent struct {
+	ObjectID  int    `xml:"objectid,attr"`
+	Transform string `xml:"transform,attr"`
+	Path      string `xml:"path,attr"`
+}
+
+type xmlRelationships struct {
+	Relationships []struct {
+		Target string `xml:"Target,attr"`
+		Type   string `xml:"Type,attr"`
+	} `xml:"Relationship"`
+}
+
+func unitScale(unit string) (float64, error) {
+	switch unit {
+	case "", "millimeter":
+		return 1, nil
+	case "micron":
+		return 0.001, nil
+	case "centimeter":
+		return 10, nil
+	case "inch":
+		return 25.4, nil
+	case "foot":
+		return 304.8, nil
+	case "meter":
+		return 1000, nil
+	default:
+		return 0, fmt.Errorf("%w: unknown unit %q", ErrInvalid3MF, unit)
+	}
+}
+
+type packageReader struct {
+	files  map[string]*zip.File
+	models map[string]*xmlModel
+	// budget is how many more triangles and components resolve may produce
+	budget int
+}
+
+func normalizePartName(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+func (p *packageReader) readPart(name string, limit int64) ([]byte, error) {
+	f, ok := p.files[normalizePartName(name)]
+	if !ok {
+		return nil, fmt.Errorf("%w: missing part %s", ErrInvalid3MF, name)
+	}
+
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	data, err := io.ReadAll(io.LimitReader(rc, limit+1))
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to read %s: %v", ErrInvalid3MF, name, err)
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("%w: part %s is too large", ErrInvalid3MF, name)
+	}
+	return data, nil
+}
+
+func (p *packageReader) model(name string) (*xmlModel, error) {
+	name = normalizePartName(name)
+	if m, ok := p.models[name]; ok {
+		return m, nil
+	}
+
+	f, ok := p.files[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: missing model part %s", ErrInvalid3MF, name)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	var m xmlModel
+	limited := io.LimitReader(rc, max3MFPartSize)
+	if err := xml.NewDecoder(limited).Decode(&m); err != nil {
+		return nil, fmt.Errorf("%w: failed to parse %s: %v", ErrInvalid3MF, name, err)
+	}
+	// The checksum is only verified once the part is read to the end, which decoding the root element may not do
+	if _, err := io.Copy(io.Discard, limited); err != nil {
+		return nil, fmt.Errorf("%w: failed to read %s: %v", ErrInvalid3MF, name, err)
+	}
+	p.models[name] = &m
+	return &m, nil
+}
+
+func (p *packageReader) startPart() string {
+	data, err := p.readPart("_rels/.rels", max3MFThumbnailSize)
+	if err != nil {
+		return defaultModelPath
+	}
+
+	var rels xmlRelationships
+	if err := xml.Unmarshal(data, &rels); err != nil {
+		return defaultModelPath
+	}
+	for _, rel := range rels.Relationships {
+		if rel.Type == modelRelationship {
+			return normalizePartName(rel.Target)
+		}
+	}
+	return defaultModelPath
+}
+
+// resolve appends the triangles of an object and all of its components to out, transformed into build space
+func (p *packageReader) resolve(modelPath string, objectID int, transform Matrix, depth int, out *[]Triangle) (string, error) {
+	if depth > maxComponentDepth {
+		return "", fmt.Errorf("%w: components nested too deep", ErrInvalid3MF)
+	}
+
+	model, err := p.model(modelPath)
+	if err != nil {
+		return "", err
+	}
+	scale, err := unitScale(model.Unit)
+	if err != nil {
+		return "", err
+	}
+
+	var object *xmlObject
+	for i := range model.Resources.Objects {
+		if model.Resources.Objects[i].ID == objectID {
+			object = &model.Resources.Objects[i]
+			break
+		}
+	}
+	if object == nil {
+		return "", fmt.Errorf("%w: object %d not found in %s", ErrInvalid3MF, objectID, modelPath)
+	}
+
+	p.budget--
+	if object.Mesh != nil {
+		p.budget -= len(object.Mesh.Triangles)
+	}
+	if p.budget < 0 {
+		return "", fmt.Errorf("%w: the build resolves to more than %d triangles", ErrInvalid3MF, max3MFTriangles)
+	}
+
+	if object.Mesh != nil {
+		vertices := object.Mesh.Vertices
+		for _, tri := range object.Mesh.Triangles {
+			if tri.V1 < 0 || tri.V2 < 0 || tri.V3 < 0 || tri.V1 >= len(vertices) || tri.V2 >= len(vertices) || tri.V3 >= len(vertices) {
+				return "", fmt.Errorf("%w: triangle references missing vertex in object %d", ErrInvalid3MF, objectID)
+			}
+
+			var t Triangle
+			for i, idx := range [3]int{tri.V1, tri.V2, tri.V3} {
+				v := vertices[idx]
+				t[i] = transform.Apply(Vec3{v.X * scale, v.Y * scale, v.Z * scale})
+			}
+			*out = append(*out, t)
+		}
+	}
+
+	for _, component := range object.Components {
+		componentTransform, err := parseMatrix(component.Transform)
+		if err != nil {
+			return "", err
+		}
+		componentPath := modelPath
+		if component.Path != "" {
+			componentPath = component.Path
+		}
+		if _, err := p.resolve(componentPath, component.ObjectID, componentTransform.Then(transform), depth+1, out); err != nil {
+			return "", err
+		}
+	}
+
+	return object.Name, nil
+}
+
+// Read3MF parses a 3MF package, resolving every build item into triangles and collecting plate information and thumbnails.
+// Sliced projects without a model part are accepted and simply have no items.
+func Read3MF(r io.ReaderAt, size int64) (*Package, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalid3MF, err)
+	}
+
+	reader := &packageReader{
+		files:  make(map[string]*zip.File, len(zr.File)),
+		models: make(map[string]*xmlModel),
+		budget: max3MFTriangles,
+	}
+	for _, f := range zr.File {
+		reader.files[normalizePartName(f.Name)] = f
+	}
+
+	pkg := &Package{PlateThumbnails: make(map[int][]byte)}
+
+	for name := range reader.files {
+		match := platePartRegex.FindStringSubmatch(name)
+		if match == nil {
+			continue
+		}
+		plate, _ := strconv.Atoi(match[1])
+		if plate > pkg.PlateCount {
+			pkg.PlateCount = plate
+		}
+
+		switch match[2] {
+		case "gcode":
+			pkg.HasGCode = true
+		case "png":
+			data, err := reader.readPart(name, max3MFThumbnailSize)
+			if err != nil {
+				return nil, err
+			}
+			pkg.PlateThumbnails[plate] = data
+		}
+	}
+
+	if _, ok := reader.files["Metadata/thumbnail.png"]; ok {
+		data, err := reader.readPart("Metadata/thumbnail.png", max3MFThumbnailSize)
+		if err != nil {
+			return nil, err
+		}
+		pkg.Thumbnail = data
+	}
+
+	start := reader.startPart()
+	if _, ok := reader.files[start]; !ok {
+		if pkg.HasGCode {
+			return pkg, nil
+		}
+		return nil, fmt.Errorf("%w: missing model part %s", ErrInvalid3MF, start)
+	}
+
+	model, err := reader.model(start)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, item := range model.Build.Items {
+		transform, err := parseMatrix(item.Transform)
+		if err != nil {
+			return nil, err
+		}
+
+		itemPath := start
+		if item.Path != "" {
+			itemPath = item.Path
+		}
+
+		buildItem := BuildItem{ObjectID: item.ObjectID, Transform: transform}
+		name, err := reader.resolve(itemPath, item.ObjectID, transform, 0, &buildItem.Triangles)
+		if err != nil {
+			return nil, err
+		}
+		buildItem.Name = name
+		pkg.Items = append(pkg.Items, buildItem)
+	}
+
+	if pkg.PlateCount == 0 && len(pkg.Items) > 0 {
+		pkg.PlateCount = 1
+	}
+
+	return pkg, nil
+}
+
+// OpenPlateGCode opens the G-code of a single plate of a sliced .gcode.3mf project
+func OpenPlateGCode(r io.ReaderAt, size int64, plate int) (io.ReadCloser, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalid3MF, err)
+	}
+
+	name := fmt.Sprintf("Metadata/plate_%d.gcode", plate)
+	for _, f := range zr.File {
+		if normalizePartName(f.Name) == name {
+			return f.Open()
+		}
+	}
+	return nil, fmt.Errorf("%w: missing part %s", ErrInvalid3MF, name)
+}
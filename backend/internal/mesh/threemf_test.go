@@ -0,0 +1,188 @@
+package mesh
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// build3MF zips the given parts into a 3MF package
+func build3MF(t *testing.T, parts map[string]string) *bytes.Reader {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range parts {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return bytes.NewReader(buf.Bytes())
+}
+
+const testTriangleObject = `<object id="1"><mesh>
+<vertices><vertex x="0" y="0" z="0"/><vertex x="1" y="0" z="0"/><vertex x="0" y="1" z="0"/></vertices>
+<triangles><triangle v1="0" v2="1" v3="2"/></triangles>
+</mesh></object>`
+
+func TestRead3MFRejectsSelfMultiplyingComponents(t *testing.T) {
+	defer func(limit int) { max3MFTriangles = limit }(max3MFTriangles)
+	max3MFTriangles = 10_000
+
+	// Every level references the one below ten times, 8 levels resolve to 10^8 triangles
+	var objects strings.Builder
+	objects.WriteString(testTriangleObject)
+	for id := 2; id <= 9; id++ {
+		fmt.Fprintf(&objects, `<object id="%d"><components>`, id)
+		for i := 0; i < 10; i++ {
+			fmt.Fprintf(&objects, `<component objectid="%d"/>`, id-1)
+		}
+		objects.WriteString(`</components></object>`)
+	}
+
+	r := build3MF(t, map[string]string{
+		"3D/3dmodel.model": `<model unit="millimeter"><resources>` + objects.String() + `</resources><build><item objectid="9"/></build></model>`,
+	})
+	if _, err := Read3MF(r, r.Size()); !errors.Is(err, ErrInvalid3MF) || !strings.Contains(err.Error(), "triangles") {
+		t.Fatalf("Read3MF = %v, want the triangle budget to be exceeded", err)
+	}
+}
+
+func TestRead3MFResolvesComponents(t *testing.T) {
+	// Object 2 places object 1 scaled by two and moved 10mm along x, and the same object from a part in centimeters
+	r := build3MF(t, map[string]string{
+		"3D/3dmodel.model": `<model unit="millimeter"><resources>` + testTriangleObject + `
+<object id="2" name="assembly"><components>
+<component objectid="1" transform="2 0 0 0 2 0 0 0 2 10 0 0"/>
+<component objectid="1" path="/3D/part.model"/>
+</components></object>
+</resources><build><item objectid="2" transform="1 0 0 0 1 0 0 0 1 0 0 5"/></build></model>`,
+		"3D/part.model": `<model unit="centimeter"><resources>` + testTriangleObject + `</resources></model>`,
+	})
+
+	pkg, err := Read3MF(r, r.Size())
+	if err != nil {
+		t.Fatalf("Read3MF: %v", err)
+	}
+	if len(pkg.Items) != 1 {
+		t.Fatalf("read %d build items, want 1", len(pkg.Items))
+	}
+
+	item := pkg.Items[0]
+	if item.Name != "assembly" || item.ObjectID != 2 {
+		t.Errorf("item = %q (%d), want assembly (2)", item.Name, item.ObjectID)
+	}
+	want := []Triangle{
+		{{10, 0, 5}, {12, 0, 5}, {10, 2, 5}},
+		{{0, 0, 5}, {10, 0, 5}, {0, 10, 5}},
+	}
+	if len(item.Triangles) != len(want) {
+		t.Fatalf("resolved %d triangles, want %d", len(item.Triangles), len(want))
+	}
+	for i := range want {
+		if item.Triangles[i] != want[i] {
+			t.Errorf("triangle %d = %v, want %v", i, item.Triangles[i], want[i])
+		}
+	}
+	if pkg.PlateCount != 1 {
+		t.Errorf("PlateCount = %d, want 1", pkg.PlateCount)
+	}
+}
+
+func TestRead3MFScalesUnits(t *testing.T) {
+	tests := []struct {
+		unit string
+		want float64
+	}{
+		{"", 1},
+		{"millimeter", 1},
+		{"micron", 0.001},
+		{"centimeter", 10},
+		{"inch", 25.4},
+		{"foot", 304.8},
+		{"meter", 1000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.unit, func(t *testing.T) {
+			r := build3MF(t, map[string]string{
+				"3D/3dmodel.model": `<model unit="` + tt.unit + `"><resources>` + testTriangleObject + `</resources><build><item objectid="1"/></build></model>`,
+			})
+			pkg, err := Read3MF(r, r.Size())
+			if err != nil {
+				t.Fatalf("Read3MF: %v", err)
+			}
+			stats := pkg.Stats()
+			if size := stats.Size(); size != (Vec3{tt.want, tt.want, 0}) {
+				t.Errorf("Size = %v, want %v x %v", size, tt.want, tt.want)
+			}
+		})
+	}
+
+	r := build3MF(t, map[string]string{
+		"3D/3dmodel.model": `<model unit="parsec"><resources>` + testTriangleObject + `</resources><build><item objectid="1"/></build></model>`,
+	})
+	if _, err := Read3MF(r, r.Size()); !errors.Is(err, ErrInvalid3MF) {
+		t.Errorf("Read3MF with an unknown unit = %v, want ErrInvalid3MF", err)
+	}
+}
+
+const forgedModel = `<model unit="millimeter"><resources>` + testTriangleObject + `</resources><build><item objectid="1"/></build></model>`
+
+// forged3MF zips forgedModel raw under a central directory entry claiming the given size and checksum
+func forged3MF(t *testing.T, size uint64, crc uint32) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.CreateRaw(&zip.FileHeader{
+		Name:               "3D/3dmodel.model",
+		Method:             zip.Store,
+		CRC32:              crc,
+		CompressedSize64:   size,
+		UncompressedSize64: size,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte(forgedModel)); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestRead3MFRejectsForgedZip(t *testing.T) {
+	valid := build3MF(t, map[string]string{"3D/3dmodel.model": forgedModel})
+	data := make([]byte, valid.Size())
+	valid.ReadAt(data, 0)
+
+	tests := []struct {
+		name string
+		file []byte
+	}{
+		{"truncated central directory", data[:len(data)-10]},
+		{"size shorter than the part", forged3MF(t, 40, 0)},
+		{"wrong checksum", forged3MF(t, uint64(len(forgedModel)), 1)},
+		{"not a zip", []byte("solid cube\nendsolid cube\n")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Read3MF(bytes.NewReader(tt.file), int64(len(tt.file))); !errors.Is(err, ErrInvalid3MF) {
+				t.Fatalf("Read3MF = %v, want ErrInvalid3MF", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// Blob is an uploaded model stored under the SHA-256 of its content, shared by every print of the same file
+type Blob struct {
+	// ObjectPath is the stored name, the hash followed by the file extension
+	ObjectPath string `gorm:"primaryKey"`
+	Hash       string `gorm:"index;not null"`
+	Size       int64
+	// RefCount is the number of prints using the blob, it is deleted from storage when the last one is deleted
+	RefCount int `gorm:"not null;default:0"`
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// Filament is a spool of filament in stock
+type Filament struct {
+	ID uint `gorm:"primaryKey"`
+
+	Material string `gorm:"index;not null"`
+	Color    string `gorm:"index;not null"`
+	Brand    string
+
+	// RemainingGrams is lowered automatically as prints using the spool complete
+	RemainingGrams float64 `gorm:"not null;default:0"`
+
+	// PrinterID is the printer the spool is loaded on, a printer holds at most one spool
+	PrinterID *uint `gorm:"index"`
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
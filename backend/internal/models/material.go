@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// DefaultMaterialDensity is the density of PLA in g/cm³. It is used for materials without a density of their own and
+// matches the column default of Material.Density.
+const DefaultMaterialDensity = 1.24
+
+// Material is a filament material with the price charged for it
+type Material struct {
+	ID   uint   `gorm:"primaryKey"`
+	Name string `gorm:"uniqueIndex;not null"`
+
+	PricePerGram float64 `gorm:"not null;default:0"`
+	// Density in g/cm³, used to turn model volume and filament length into a weight
+	Density float64 `gorm:"not null;default:1.24"`
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
@@ -15,6 +15,15 @@ const (
 	StatusPaused          PrintStatus = "paused"
 )
 
+type SliceStatus string
+
+const (
+	SliceNone      SliceStatus = ""
+	SliceRunning   SliceStatus = "slicing"
+	SliceSucceeded SliceStatus = "sliced"
+	SliceFailed    SliceStatus = "failed"
+)
+
 type Print struct {
 	ID     uint `gorm:"primaryKey"`
 	UserID uint `gorm:"index"`
@@ -24,8 +33,129 @@ type Print struct {
 	UploadedFileName       string      `gorm:"not null"`
 	StoredFileName         string      `gorm:"not null"`
 	RequestedFilamentColor string      `gorm:"not null;default:'#000000'"`
-	DenialReason           string
+	// RequestedFilamentMaterial is the name of the Material the print is priced with
+	RequestedFilamentMaterial string `gorm:"not null;default:'PLA'"`
+	DenialReason              string
+
+	// FileHash is the SHA-256 of the uploaded file, StoredFileName is the Blob it is stored as. Empty for prints
+	// uploaded before files were stored by content.
+	FileHash string `gorm:"index"`
+	// FilePurgedAt is when the model and sliced G-code were deleted by the retention policy, the print is kept as a record
+	FilePurgedAt *time.Time
+
+	// PrinterID is the printer the print was assigned to when it started printing
+	PrinterID *uint `gorm:"index"`
+	// Priority moves a print ahead in the queue, higher runs first
+	Priority int `gorm:"not null;default:0"`
+
+	// Model geometry, zero until the model has been analyzed. Lengths are in millimeters.
+	SizeX               float64
+	SizeY               float64
+	SizeZ               float64
+	Volume              float64 // mm³
+	SurfaceArea         float64 // mm²
+	TriangleCount       int
+	NonManifoldEdges    int
+	DegenerateTriangles int
+
+	// PlateCount is the number of build plates in a 3MF project
+	PlateCount int
+	// ThumbnailFileName is the stored preview image of the model, empty when there is none
+	ThumbnailFileName string
+	// Objects are the build items of a 3MF project
+	Objects []PrintObject `gorm:"foreignKey:PrintID"`
+
+	// SliceStatus tracks server side slicing of raw models, SlicedFileName is the stored G-code once slicing succeeded
+	SliceStatus    SliceStatus `gorm:"type:varchar(32);default:''"`
+	SlicedFileName string
+
+	// Slicer output, zero until sliced G-code has been analyzed. Values missing from the slicer comments are estimated from the moves.
+	Slicer                string
+	PrinterProfile        string
+	EstimatedPrintSeconds int     `gorm:"index"`
+	FilamentLength        float64 // mm
+	FilamentWeight        float64 // g
+	LayerHeight           float64 // mm
+	LayerCount            int
+	NozzleTemperature     float64 // °C
+	BedTemperature        float64 // °C
+
+	// EstimatedCost is computed from the material price, nil when the material has no price or nothing is known about the model.
+	// ActualCost is recorded by an admin once the print is completed.
+	EstimatedCost *float64
+	ActualCost    *float64
+
+	// FilamentID is the spool the filament was taken from once the print completed, FilamentUsed is what was deducted from it in grams
+	FilamentID   *uint
+	FilamentUsed float64
+
+	// PreviousDenials are earlier denied prints of the same file, only filled in for reviewers
+	PreviousDenials []PreviousDenial `gorm:"-"`
 
 	CreatedAt time.Time
 	UpdatedAt time.Time
 }
+
+// PreviousDenial is an earlier print of the same file that was denied
+type PreviousDenial struct {
+	PrintID      uint
+	UserID       uint
+	DenialReason string
+	SubmittedAt  time.Time
+}
+
+// JobFileName is the stored file sent to printers, the sliced G-code when the print was sliced on the server
+func (p *Print) JobFileName() string {
+	if p.SlicedFileName != "" {
+		return p.SlicedFileName
+	}
+	return p.StoredFileName
+}
+
+// PrintStatusEvent records a single status transition of a print
+type PrintStatusEvent struct {
+	ID      uint `gorm:"primaryKey"`
+	PrintID uint `gorm:"index;not null"`
+	// ActorID is the user that made the change, nil when the change was made by the system
+	ActorID *uint `gorm:"index"`
+
+	FromStatus PrintStatus `gorm:"type:varchar(32)"`
+	ToStatus   PrintStatus `gorm:"type:varchar(32);not null"`
+	Reason     string
+
+	CreatedAt time.Time `gorm:"index"`
+}
+
+// PrintObject is a single object placed on the build plate of a 3MF project
+type PrintObject struct {
+	ID      uint `gorm:"primaryKey"`
+	PrintID uint `gorm:"index;not null"`
+
+	ObjectID int
+	Name     string
+	// Transform is the 3MF build item transform, 12 space separated values
+	Transform     string
+	SizeX         float64
+	SizeY         float64
+	SizeZ         float64
+	Volume        float64
+	TriangleCount int
+}
+
+// SliceJob records a single slicer run for a print
+type SliceJob struct {
+	ID      uint `gorm:"primaryKey"`
+	PrintID uint `gorm:"index;not null"`
+	// PrinterID is the printer whose profile was used, nil when the default profile was used
+	PrinterID *uint
+
+	Profile        string
+	Status         SliceStatus `gorm:"type:varchar(32);not null"`
+	StoredFileName string
+	// Output is the tail of what the slicer printed
+	Output string `gorm:"type:text"`
+	Error  string
+
+	CreatedAt time.Time `gorm:"index"`
+	UpdatedAt time.Time
+}
@@ -0,0 +1,57 @@
+package models
+
+import "time"
+
+type PrinterDriver string
+
+const (
+	// DriverNone is used for printers that are operated by hand
+	DriverNone      PrinterDriver = ""
+	DriverOctoPrint PrinterDriver = "octoprint"
+	DriverMoonraker PrinterDriver = "moonraker"
+	DriverBambu     PrinterDriver = "bambu"
+)
+
+func (d PrinterDriver) IsValid() bool {
+	switch d {
+	case DriverNone, DriverOctoPrint, DriverMoonraker, DriverBambu:
+		return true
+	default:
+		return false
+	}
+}
+
+type Printer struct {
+	ID   uint   `gorm:"primaryKey"`
+	Name string `gorm:"uniqueIndex;not null"`
+
+	Make  string
+	Model string
+
+	// Build volume in millimeters
+	BuildVolumeX float64 `gorm:"not null;default:0"`
+	BuildVolumeY float64 `gorm:"not null;default:0"`
+	BuildVolumeZ float64 `gorm:"not null;default:0"`
+	// Nozzle diameter in millimeters
+	NozzleSize float64 `gorm:"not null;default:0.4"`
+
+	// LoadedFilamentColor and LoadedFilamentMaterial are those of the spool loaded on the printer, empty when there is none.
+	// They are not stored with the printer, the spool is the only record of what is loaded.
+	LoadedFilamentColor    string `gorm:"-"`
+	LoadedFilamentMaterial string `gorm:"-"`
+
+	Online bool `gorm:"default:false"`
+
+	// Driver selects the integration used to dispatch and monitor jobs, Address and APIKey are passed to it.
+	// Bambu printers use APIKey for their LAN access code and need their SerialNumber.
+	Driver       PrinterDriver `gorm:"type:varchar(32);default:''"`
+	Address      string
+	APIKey       string `json:"-"`
+	SerialNumber string
+
+	// SlicerProfile is the slicer configuration used to slice raw models for this printer, passed to the slicer as is
+	SlicerProfile string
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// QuotaOverride replaces parts of the role quota of a single user, nil fields fall back to the role default
+type QuotaOverride struct {
+	ID     uint `gorm:"primaryKey"`
+	UserID uint `gorm:"uniqueIndex;not null"`
+
+	MaxOpenPrints    *int
+	MaxGramsPerWeek  *float64
+	MaxGramsPerMonth *float64
+	MaxFileSize      *int64
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
@@ -0,0 +1,31 @@
+package models
+
+import "time"
+
+// Upload is a resumable upload of a print file. Its chunks are staged in storage until the upload is submitted as a print.
+type Upload struct {
+	ID       string `gorm:"primaryKey"`
+	UserID   uint   `gorm:"index;not null"`
+	FileName string `gorm:"not null"`
+	FileSize int64  `gorm:"not null"`
+	// SHA256 is the hex checksum of the whole file declared by the client, verified once every chunk has arrived
+	SHA256 string `gorm:"not null"`
+	// Offset is the number of bytes received so far
+	Offset int64 `gorm:"not null;default:0"`
+
+	Chunks []UploadChunk `gorm:"foreignKey:UploadID"`
+
+	// ExpiresAt is pushed back by every chunk, abandoned uploads are deleted once it has passed
+	ExpiresAt time.Time `gorm:"index"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// UploadChunk is a received part of an Upload, stored as its own object
+type UploadChunk struct {
+	ID         uint   `gorm:"primaryKey"`
+	UploadID   string `gorm:"index;not null"`
+	Offset     int64  `gorm:"not null"`
+	Size       int64  `gorm:"not null"`
+	ObjectPath string `gorm:"not null"`
+}
@@ -0,0 +1,366 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"github.com/torbenconto/spooler/internal/models"
+	"github.com/torbenconto/spooler/internal/storage"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// BlobService stores uploaded models under the SHA-256 of their content, so a file that is submitted again is not stored twice
+type BlobService struct {
+	db            *gorm.DB
+	storageClient storage.StorageClient
+
+	// DeleteOrphans makes Collect delete every stored file nothing refers to. Spooler's files are not kept under a prefix
+	// of their own, so this is only safe when the bucket or directory holds nothing else.
+	DeleteOrphans bool
+	// OrphanMinAge spares unreferenced files younger than this from Collect, they may belong to an upload in progress
+	OrphanMinAge time.Duration
+	// CompletedRetention and DeniedRetention are how long after a print was completed or denied Collect purges its files,
+	// 0 keeps them forever
+	CompletedRetention time.Duration
+	DeniedRetention    time.Duration
+}
+
+func NewBlobService(db *gorm.DB, storageClient storage.StorageClient) *BlobService {
+	return &BlobService{db: db, storageClient: storageClient, OrphanMinAge: 24 * time.Hour}
+}
+
+// HashFile returns the hex SHA-256 of a file and rewinds it
+func HashFile(file io.ReadSeeker) (string, error) {
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// BlobObjectPath is the stored name of a file with the given hash and extension
+func BlobObjectPath(hash string, extension string) string {
+	return hash + extension
+}
+
+// errBlobReleased is returned inside CreatePrint when the blob it was going to reference lost its stored file since it was
+// checked, the file has to be stored again
+var errBlobReleased = errors.New("blob was released")
+
+// CreatePrint creates a print along with a reference to the blob named by its StoredFileName. The file is stored before
+// the transaction when no print references the blob yet, so no lock is held while it is uploaded. maxOpen is the number
+// of open prints the user may have, 0 is no limit.
+func (s *BlobService) CreatePrint(ctx context.Context, print *models.Print, file io.ReadSeeker, size int64, maxOpen int) error {
+	if print.FileHash == "" {
+		return errors.New("print has no file hash")
+	}
+
+	// The last print of the blob can be deleted between the check and the transaction, the file is then stored again
+	for attempt := 0; ; attempt++ {
+		err := s.createPrint(ctx, print, file, size, maxOpen, attempt > 0)
+		if !errors.Is(err, errBlobReleased) || attempt >= 2 {
+			return err
+		}
+	}
+}
+
+func (s *BlobService) createPrint(ctx context.Context, print *models.Print, file io.ReadSeeker, size int64, maxOpen int, store bool) error {
+	objectPath := print.StoredFileName
+	if !store {
+		var existing int64
+		if err := s.db.Model(&models.Blob{}).Where("object_path = ?", objectPath).Count(&existing).Error; err != nil {
+			return err
+		}
+		store = existing == 0
+	}
+	if store {
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		if err := s.storageClient.StoreFile(ctx, objectPath, file); err != nil {
+			return fmt.Errorf("failed to store file: %w", err)
+		}
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := lockBlobPath(tx, objectPath); err != nil {
+			return err
+		}
+		if err := checkOpenPrints(tx, print.UserID, maxOpen); err != nil {
+			return err
+		}
+
+		var blobs []models.Blob
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("object_path = ?", objectPath).Limit(1).Find(&blobs).Error; err != nil {
+			return err
+		}
+
+		if len(blobs) > 0 {
+			// Another print of the same file was created meanwhile, whatever was stored has the same content
+			if err := tx.Model(&blobs[0]).Update("ref_count", gorm.Expr("ref_count + 1")).Error; err != nil {
+				return err
+			}
+			return createPrint(tx, print)
+		}
+
+		if !store {
+			return errBlobReleased
+		}
+		// A concurrent CreatePrint of the same file that failed may have deleted what was stored
+		if _, err := s.storageClient.Stat(ctx, objectPath); err != nil {
+			if storage.IsNotExist(err) {
+				return errBlobReleased
+			}
+			return err
+		}
+		if err := tx.Create(&models.Blob{ObjectPath: objectPath, Hash: print.FileHash, Size: size, RefCount: 1}).Error; err != nil {
+			return err
+		}
+		return createPrint(tx, print)
+	})
+	if err != nil && store && !errors.Is(err, errBlobReleased) {
+		s.discardFile(ctx, objectPath)
+	}
+	return err
+}
+
+// lockBlobPath serializes everything that creates or deletes the blob of objectPath until tx ends, the row lock alone
+// does not cover blobs that do not exist yet
+func lockBlobPath(tx *gorm.DB, objectPath string) error {
+	return tx.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", objectPath).Error
+}
+
+// discardFile deletes a stored file that lost its blob or was stored for a print that could not be created, unless a blob
+// refers to it by now. The path stays locked while the file is deleted, so CreatePrint cannot reference it meanwhile.
+func (s *BlobService) discardFile(ctx context.Context, objectPath string) {
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := lockBlobPath(tx, objectPath); err != nil {
+			return err
+		}
+		var existing int64
+		if err := tx.Model(&models.Blob{}).Where("object_path = ?", objectPath).Count(&existing).Error; err != nil {
+			return err
+		}
+		if existing > 0 {
+			return nil
+		}
+		return s.storageClient.DeleteFile(ctx, objectPath)
+	})
+	// Collect removes the file once nothing refers to it if orphans are deleted
+	if err != nil && !storage.IsNotExist(err) {
+		log.Printf("failed to delete file: %s: %v", objectPath, err)
+	}
+}
+
+// DeletePrint deletes a print and drops its reference to its blob, removing the stored file once no print references it.
+// Files of prints uploaded before blobs existed are removed right away.
+func (s *BlobService) DeletePrint(ctx context.Context, print *models.Print) error {
+	var unreferenced bool
+	if err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := deletePrint(tx, print.ID); err != nil {
+			return err
+		}
+		if print.FilePurgedAt != nil {
+			return nil
+		}
+
+		var err error
+		unreferenced, err = releaseBlob(tx, print.StoredFileName)
+		return err
+	}); err != nil {
+		return err
+	}
+
+	if unreferenced {
+		s.discardFile(ctx, print.StoredFileName)
+	}
+	return nil
+}
+
+// releaseBlob drops a reference to a blob, reporting whether it was the last one and the stored file can be deleted.
+// The file is only deleted once the transaction committed, discardFile checks again that no blob refers to it by then.
+func releaseBlob(tx *gorm.DB, objectPath string) (bool, error) {
+	var blobs []models.Blob
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("object_path = ?", objectPath).Limit(1).Find(&blobs).Error; err != nil {
+		return false, err
+	}
+	if len(blobs) > 0 && blobs[0].RefCount > 1 {
+		return false, tx.Model(&blobs[0]).Update("ref_count", gorm.Expr("ref_count - 1")).Error
+	}
+
+	if len(blobs) > 0 {
+		if err := tx.Delete(&blobs[0]).Error; err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// Collect is the periodic storage cleanup: it purges the files of prints past their retention and then, when DeleteOrphans
+// is set, deletes stored files nothing refers to
+func (s *BlobService) Collect(ctx context.Context) error {
+	if err := s.applyRetention(ctx); err != nil {
+		return fmt.Errorf("failed to apply retention policy: %w", err)
+	}
+	if !s.DeleteOrphans {
+		return nil
+	}
+	if err := s.deleteOrphans(ctx); err != nil {
+		return fmt.Errorf("failed to delete orphaned files: %w", err)
+	}
+	return nil
+}
+
+// applyRetention purges the files of prints that were completed or denied longer ago than their retention
+func (s *BlobService) applyRetention(ctx context.Context) error {
+	for status, retention := range map[models.PrintStatus]time.Duration{
+		models.StatusCompleted: s.CompletedRetention,
+		models.StatusDenied:    s.DeniedRetention,
+	} {
+		if retention <= 0 {
+			continue
+		}
+
+		// The time a print reached its status is when its latest event to that status was recorded. Prints older than status
+		// events have none, their last update is used instead.
+		var ids []uint
+		if err := s.db.Model(&models.Print{}).
+			Where("status = ? AND file_purged_at IS NULL", status).
+			Where("COALESCE((SELECT MAX(e.created_at) FROM print_status_events e WHERE e.print_id = prints.id AND e.to_status = prints.status), prints.updated_at) < ?", time.Now().Add(-retention)).
+			Pluck("id", &ids).Error; err != nil {
+			return err
+		}
+
+		for _, id := range ids {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := s.purgeFiles(ctx, id, status); err != nil {
+				return fmt.Errorf("failed to purge files of print %d: %w", id, err)
+			}
+		}
+		if len(ids) > 0 {
+			log.Printf("purged the files of %d %s prints", len(ids), status)
+		}
+	}
+	return nil
+}
+
+// purgeFiles deletes the model and sliced G-code of a print that still has the given status and marks the print purged.
+// The thumbnail is kept so the print still shows up in history. Files are deleted after the print is marked, a file that
+// cannot be deleted then is left to deleteOrphans when it is enabled.
+func (s *BlobService) purgeFiles(ctx context.Context, printID uint, status models.PrintStatus) error {
+	var model, gcode string
+	if err := s.db.Transaction(func(tx *gorm.DB) error {
+		var print models.Print
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&print, printID).Error; err != nil {
+			return err
+		}
+		// The print may have been reopened or purged since it was selected
+		if print.Status != status || print.FilePurgedAt != nil {
+			return nil
+		}
+
+		unreferenced, err := releaseBlob(tx, print.StoredFileName)
+		if err != nil {
+			return err
+		}
+		if err := tx.Model(&print).Update("file_purged_at", time.Now()).Error; err != nil {
+			return err
+		}
+
+		if unreferenced {
+			model = print.StoredFileName
+		}
+		gcode = print.SlicedFileName
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	if model != "" {
+		s.discardFile(ctx, model)
+	}
+	if gcode != "" {
+		if err := s.storageClient.DeleteFile(ctx, gcode); err != nil && !storage.IsNotExist(err) {
+			log.Printf("failed to delete file: %s: %v", gcode, err)
+		}
+	}
+	return nil
+}
+
+// deleteOrphans deletes stored files no print, slice job, blob or upload refers to. Files younger than OrphanMinAge are kept,
+// uploads are stored before the print referring to them is created.
+func (s *BlobService) deleteOrphans(ctx context.Context) error {
+	objects, err := s.ReferencedObjects()
+	if err != nil {
+		return err
+	}
+	referenced := make(map[string]bool, len(objects))
+	for _, objectPath := range objects {
+		referenced[objectPath] = true
+	}
+
+	cutoff := time.Now().Add(-s.OrphanMinAge)
+	var orphans []string
+	if err := s.storageClient.List(ctx, func(info storage.ObjectInfo) error {
+		if !referenced[info.Path] && info.LastModified.Before(cutoff) {
+			orphans = append(orphans, info.Path)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	for _, objectPath := range orphans {
+		if err := s.storageClient.DeleteFile(ctx, objectPath); err != nil && !storage.IsNotExist(err) {
+			log.Printf("failed to delete orphaned file %s: %v", objectPath, err)
+			continue
+		}
+		log.Printf("deleted orphaned file %s", objectPath)
+	}
+	return nil
+}
+
+// ReferencedObjects returns the name of every stored file the database refers to: models, sliced G-code, thumbnails and
+// the chunks of uploads in progress.
+// The model and G-code of purged prints are gone and not included.
+func (s *BlobService) ReferencedObjects() ([]string, error) {
+	var objects []string
+	for _, column := range []struct {
+		model  any
+		column string
+		where  string
+	}{
+		{&models.Print{}, "stored_file_name", "file_purged_at IS NULL"},
+		{&models.Print{}, "sliced_file_name", "file_purged_at IS NULL"},
+		{&models.Print{}, "thumbnail_file_name", ""},
+		{&models.SliceJob{}, "stored_file_name", "print_id IN (SELECT id FROM prints WHERE file_purged_at IS NULL)"},
+		{&models.Blob{}, "object_path", ""},
+		{&models.UploadChunk{}, "object_path", ""},
+	} {
+		query := s.db.Model(column.model).Where(column.column + " <> ''")
+		if column.where != "" {
+			query = query.Where(column.where)
+		}
+
+		var names []string
+		if err := query.Distinct().Pluck(column.column, &names).Error; err != nil {
+			return nil, err
+		}
+		objects = append(objects, names...)
+	}
+	return objects, nil
+}
@@ -0,0 +1,296 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"io/fs"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/torbenconto/spooler/internal/models"
+	"github.com/torbenconto/spooler/internal/storage"
+)
+
+// memStorage is a storage client keeping files in memory, onStore is called before a file is stored
+type memStorage struct {
+	mu      sync.Mutex
+	files   map[string][]byte
+	onStore func(objectPath string)
+}
+
+func newMemStorage() *memStorage {
+	return &memStorage{files: make(map[string][]byte)}
+}
+
+func (m *memStorage) StoreFile(_ context.Context, objectPath string, file io.Reader) error {
+	if m.onStore != nil {
+		m.onStore(objectPath)
+	}
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.files[objectPath] = data
+	return nil
+}
+
+func (m *memStorage) file(objectPath string) ([]byte, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.files[objectPath]
+	return data, ok
+}
+
+func (m *memStorage) GetFile(ctx context.Context, objectPath string) (io.ReadCloser, error) {
+	return m.GetRange(ctx, objectPath, 0, -1)
+}
+
+func (m *memStorage) GetRange(_ context.Context, objectPath string, offset int64, length int64) (io.ReadCloser, error) {
+	data, ok := m.file(objectPath)
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+	data = data[min(offset, int64(len(data))):]
+	if length >= 0 && length < int64(len(data)) {
+		data = data[:length]
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (m *memStorage) DeleteFile(_ context.Context, objectPath string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.files[objectPath]; !ok {
+		return fs.ErrNotExist
+	}
+	delete(m.files, objectPath)
+	return nil
+}
+
+func (m *memStorage) Stat(_ context.Context, objectPath string) (*storage.ObjectInfo, error) {
+	data, ok := m.file(objectPath)
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+	return &storage.ObjectInfo{Path: objectPath, Size: int64(len(data))}, nil
+}
+
+func (m *memStorage) List(_ context.Context, fn func(storage.ObjectInfo) error) error {
+	m.mu.Lock()
+	paths := make([]string, 0, len(m.files))
+	for path := range m.files {
+		paths = append(paths, path)
+	}
+	m.mu.Unlock()
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		data, _ := m.file(path)
+		if err := fn(storage.ObjectInfo{Path: path, Size: int64(len(data))}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newTestPrint() *models.Print {
+	return &models.Print{UserID: 3, StoredFileName: "abc.stl", FileHash: "abc"}
+}
+
+func TestCreatePrintStoresBeforeTransaction(t *testing.T) {
+	db, fake := newFakeDB(t)
+	fake.OnQuery(`SELECT count(*) FROM "blobs"`, []string{"count"}, []driver.Value{int64(0)})
+	storageClient := newMemStorage()
+	storageClient.onStore = func(string) {
+		if begun := fake.Calls("BEGIN"); len(begun) != 0 {
+			t.Error("the file was stored inside the transaction")
+		}
+	}
+
+	if err := NewBlobService(db, storageClient).CreatePrint(context.Background(), newTestPrint(), strings.NewReader("solid model"), 11, 0); err != nil {
+		t.Fatalf("CreatePrint: %v", err)
+	}
+
+	if data, _ := storageClient.file("abc.stl"); string(data) != "solid model" {
+		t.Errorf("stored %q", data)
+	}
+	blobs := fake.Calls(`INSERT INTO "blobs"`)
+	if len(blobs) != 1 {
+		t.Fatalf("created %d blobs, want 1", len(blobs))
+	}
+	if refs, _ := blobs[0].Arg("ref_count"); refs != int64(1) {
+		t.Errorf("ref_count = %v, want 1", refs)
+	}
+	if prints := fake.Calls(`INSERT INTO "prints"`); len(prints) != 1 {
+		t.Errorf("created %d prints, want 1", len(prints))
+	}
+}
+
+func TestCreatePrintReferencesExistingBlob(t *testing.T) {
+	db, fake := newFakeDB(t)
+	fake.OnQuery(`SELECT count(*) FROM "blobs"`, []string{"count"}, []driver.Value{int64(1)})
+	fake.OnQuery(`FROM "blobs"`, []string{"object_path", "hash", "ref_count"}, []driver.Value{"abc.stl", "abc", int64(1)})
+	storageClient := newMemStorage()
+
+	if err := NewBlobService(db, storageClient).CreatePrint(context.Background(), newTestPrint(), strings.NewReader("solid model"), 11, 0); err != nil {
+		t.Fatalf("CreatePrint: %v", err)
+	}
+
+	if _, ok := storageClient.file("abc.stl"); ok {
+		t.Error("the file of an existing blob was stored again")
+	}
+	if updates := fake.Calls(`UPDATE "blobs" SET "ref_count"=ref_count + 1`); len(updates) != 1 {
+		t.Errorf("bumped the blob's ref_count %d times, want once", len(updates))
+	}
+	if blobs := fake.Calls(`INSERT INTO "blobs"`); len(blobs) != 0 {
+		t.Error("created a blob that already exists")
+	}
+}
+
+func TestCreatePrintStoresReleasedBlob(t *testing.T) {
+	db, fake := newFakeDB(t)
+	// The blob existed when it was checked, its last print was deleted before the transaction locked it
+	fake.OnQuery(`SELECT count(*) FROM "blobs"`, []string{"count"}, []driver.Value{int64(1)})
+	storageClient := newMemStorage()
+
+	if err := NewBlobService(db, storageClient).CreatePrint(context.Background(), newTestPrint(), strings.NewReader("solid model"), 11, 0); err != nil {
+		t.Fatalf("CreatePrint: %v", err)
+	}
+
+	if data, _ := storageClient.file("abc.stl"); string(data) != "solid model" {
+		t.Errorf("stored %q, want the file stored again", data)
+	}
+	if blobs := fake.Calls(`INSERT INTO "blobs"`); len(blobs) != 1 {
+		t.Errorf("created %d blobs, want 1", len(blobs))
+	}
+	if prints := fake.Calls(`INSERT INTO "prints"`); len(prints) != 1 {
+		t.Errorf("created %d prints, want 1", len(prints))
+	}
+}
+
+func TestCreatePrintDiscardsStoredFileOnFailure(t *testing.T) {
+	db, fake := newFakeDB(t)
+	fake.OnQuery(`SELECT count(*) FROM "blobs"`, []string{"count"}, []driver.Value{int64(0)})
+	fake.OnQuery(`FROM "users"`, []string{"id"}, []driver.Value{int64(3)})
+	fake.OnQuery(`SELECT count(*) FROM "prints"`, []string{"count"}, []driver.Value{int64(2)})
+	storageClient := newMemStorage()
+
+	err := NewBlobService(db, storageClient).CreatePrint(context.Background(), newTestPrint(), strings.NewReader("solid model"), 11, 2)
+	if !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("CreatePrint = %v, want %v", err, ErrQuotaExceeded)
+	}
+	if _, ok := storageClient.file("abc.stl"); ok {
+		t.Error("the stored file was kept for a print that was not created")
+	}
+	if locks := fake.Calls("pg_advisory_xact_lock"); len(locks) != 2 {
+		t.Errorf("locked the blob path %d times, want for the creation and the cleanup", len(locks))
+	}
+}
+
+func TestApplyRetentionFallsBackToUpdatedAt(t *testing.T) {
+	db, fake := newFakeDB(t)
+	fake.OnQuery(`SELECT "id" FROM "prints"`, []string{"id"}, []driver.Value{int64(7)})
+	fake.OnQuery(`SELECT * FROM "prints"`, []string{"id", "status", "stored_file_name"},
+		[]driver.Value{int64(7), string(models.StatusCompleted), "abc.stl"})
+	storageClient := newMemStorage()
+	if err := storageClient.StoreFile(context.Background(), "abc.stl", strings.NewReader("solid model")); err != nil {
+		t.Fatal(err)
+	}
+
+	blobSvc := NewBlobService(db, storageClient)
+	blobSvc.CompletedRetention = 24 * time.Hour
+	if err := blobSvc.applyRetention(context.Background()); err != nil {
+		t.Fatalf("applyRetention: %v", err)
+	}
+
+	// Prints completed before status events were recorded have none, without a fallback they would never be purged
+	selects := fake.Calls(`SELECT "id" FROM "prints"`)
+	if len(selects) != 1 || !strings.Contains(selects[0].Query, "COALESCE((SELECT MAX(e.created_at)") ||
+		!strings.Contains(selects[0].Query, "prints.updated_at) <") {
+		t.Fatalf("retention query = %v, want the latest status event falling back to updated_at", selects)
+	}
+	if _, ok := storageClient.file("abc.stl"); ok {
+		t.Error("the file of the print past its retention was kept")
+	}
+	if purged := fake.Calls(`SET "file_purged_at"`); len(purged) != 1 {
+		t.Errorf("marked %d prints purged, want 1", len(purged))
+	}
+}
+
+func TestPurgeFilesKeepsFilesWhenTransactionFails(t *testing.T) {
+	db, fake := newFakeDB(t)
+	fake.OnQuery(`FROM "prints"`, []string{"id", "status", "stored_file_name", "sliced_file_name"},
+		[]driver.Value{int64(7), string(models.StatusCompleted), "abc.stl", "abc.7.gcode"})
+	fake.OnExecError(`SET "file_purged_at"`, errors.New("connection reset"))
+	storageClient := newMemStorage()
+	for _, name := range []string{"abc.stl", "abc.7.gcode"} {
+		if err := storageClient.StoreFile(context.Background(), name, strings.NewReader("data")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := NewBlobService(db, storageClient).purgeFiles(context.Background(), 7, models.StatusCompleted); err == nil {
+		t.Fatal("purgeFiles succeeded although the print could not be marked purged")
+	}
+	for _, name := range []string{"abc.stl", "abc.7.gcode"} {
+		if _, ok := storageClient.file(name); !ok {
+			t.Errorf("%s was deleted although the purge was rolled back", name)
+		}
+	}
+}
+
+func TestDeletePrintDeletesFileAfterCommit(t *testing.T) {
+	db, fake := newFakeDB(t)
+	fake.OnQuery(`SELECT count(*) FROM "blobs"`, []string{"count"}, []driver.Value{int64(0)})
+	fake.OnQuery(`FROM "blobs"`, []string{"object_path", "hash", "ref_count"}, []driver.Value{"abc.stl", "abc", int64(1)})
+	storageClient := newMemStorage()
+	if err := storageClient.StoreFile(context.Background(), "abc.stl", strings.NewReader("solid model")); err != nil {
+		t.Fatal(err)
+	}
+
+	print := newTestPrint()
+	print.ID = 7
+	if err := NewBlobService(db, storageClient).DeletePrint(context.Background(), print); err != nil {
+		t.Fatalf("DeletePrint: %v", err)
+	}
+
+	if _, ok := storageClient.file("abc.stl"); ok {
+		t.Error("the file of the last print of the blob was kept")
+	}
+	// The file is deleted under the path lock of a second transaction once the deletion committed
+	if locks := fake.Calls("pg_advisory_xact_lock"); len(locks) != 1 {
+		t.Errorf("locked the blob path %d times, want once for the file deletion", len(locks))
+	}
+	if commits := fake.Calls("COMMIT"); len(commits) != 2 {
+		t.Errorf("committed %d transactions, want the deletion and the file cleanup", len(commits))
+	}
+}
+
+func TestCollectOnlyDeletesOrphansWhenEnabled(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		db, _ := newFakeDB(t)
+		storageClient := newMemStorage()
+		if err := storageClient.StoreFile(context.Background(), "other-app/report.pdf", strings.NewReader("data")); err != nil {
+			t.Fatal(err)
+		}
+
+		blobSvc := NewBlobService(db, storageClient)
+		blobSvc.DeleteOrphans = enabled
+		blobSvc.OrphanMinAge = -time.Hour
+		if err := blobSvc.Collect(context.Background()); err != nil {
+			t.Fatalf("Collect: %v", err)
+		}
+
+		if _, ok := storageClient.file("other-app/report.pdf"); ok == enabled {
+			t.Errorf("with DeleteOrphans %v the unreferenced file was kept = %v", enabled, ok)
+		}
+	}
+}
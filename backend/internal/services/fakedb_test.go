@@ -0,0 +1,212 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// fakeSQL is a scripted database for service tests: queries are answered by the first registered result whose match is
+// part of the query, every statement is recorded
+type fakeSQL struct {
+	mu      sync.Mutex
+	results []fakeResult
+	execs   []fakeExec
+	calls   []fakeCall
+	nextID  int64
+}
+
+type fakeResult struct {
+	match   string
+	columns []string
+	rows    [][]driver.Value
+}
+
+type fakeExec struct {
+	match        string
+	rowsAffected int64
+	err          error
+}
+
+type fakeCall struct {
+	Query string
+	Args  []driver.Value
+}
+
+var (
+	updateColumnRegex = regexp.MustCompile(`"(\w+)"=\$(\d+)`)
+	insertColumnRegex = regexp.MustCompile(`^INSERT INTO "\w+" \(([^)]*)\)`)
+)
+
+// Arg returns the value a recorded INSERT or UPDATE sets a column to
+func (c fakeCall) Arg(column string) (driver.Value, bool) {
+	if m := insertColumnRegex.FindStringSubmatch(c.Query); m != nil {
+		for i, name := range strings.Split(m[1], ",") {
+			if strings.Trim(name, `"`) == column && i < len(c.Args) {
+				return c.Args[i], true
+			}
+		}
+		return nil, false
+	}
+	for _, m := range updateColumnRegex.FindAllStringSubmatch(c.Query, -1) {
+		if m[1] == column {
+			n, _ := strconv.Atoi(m[2])
+			if n > 0 && n <= len(c.Args) {
+				return c.Args[n-1], true
+			}
+		}
+	}
+	return nil, false
+}
+
+// newFakeDB returns a postgres flavoured gorm.DB backed by a fakeSQL
+func newFakeDB(t *testing.T) (*gorm.DB, *fakeSQL) {
+	t.Helper()
+	fake := &fakeSQL{}
+	sqlDB := sql.OpenDB(fake)
+	t.Cleanup(func() { sqlDB.Close() })
+
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sqlDB}), &gorm.Config{
+		DisableAutomaticPing: true,
+		Logger:               logger.Discard,
+	})
+	if err != nil {
+		t.Fatalf("failed to open fake database: %v", err)
+	}
+	return db, fake
+}
+
+// OnQuery answers queries containing match with the given rows
+func (f *fakeSQL) OnQuery(match string, columns []string, rows ...[]driver.Value) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.results = append(f.results, fakeResult{match: match, columns: columns, rows: rows})
+}
+
+// OnExec makes statements containing match report rowsAffected instead of one affected row
+func (f *fakeSQL) OnExec(match string, rowsAffected int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.execs = append(f.execs, fakeExec{match: match, rowsAffected: rowsAffected})
+}
+
+// OnExecError makes statements containing match fail with err
+func (f *fakeSQL) OnExecError(match string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.execs = append(f.execs, fakeExec{match: match, err: err})
+}
+
+// Calls returns the recorded statements containing match
+func (f *fakeSQL) Calls(match string) []fakeCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var calls []fakeCall
+	for _, call := range f.calls {
+		if strings.Contains(call.Query, match) {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+func (f *fakeSQL) record(query string, args []driver.NamedValue) fakeResult {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		values[i] = arg.Value
+	}
+	f.calls = append(f.calls, fakeCall{Query: query, Args: values})
+
+	for _, result := range f.results {
+		if strings.Contains(query, result.match) {
+			return result
+		}
+	}
+	// Inserts return the generated id
+	if strings.HasPrefix(query, "INSERT") && strings.Contains(query, "RETURNING") {
+		f.nextID++
+		return fakeResult{columns: []string{"id"}, rows: [][]driver.Value{{f.nextID}}}
+	}
+	return fakeResult{}
+}
+
+func (f *fakeSQL) Connect(context.Context) (driver.Conn, error) { return &fakeConn{fake: f}, nil }
+func (f *fakeSQL) Driver() driver.Driver                        { return fakeDriver{} }
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(string) (driver.Conn, error) { return nil, driver.ErrSkip }
+
+type fakeConn struct {
+	fake *fakeSQL
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) { return nil, driver.ErrSkip }
+func (c *fakeConn) Close() error                              { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *fakeConn) BeginTx(context.Context, driver.TxOptions) (driver.Tx, error) {
+	c.fake.record("BEGIN", nil)
+	return c, nil
+}
+
+func (c *fakeConn) Commit() error {
+	c.fake.record("COMMIT", nil)
+	return nil
+}
+
+func (c *fakeConn) Rollback() error {
+	c.fake.record("ROLLBACK", nil)
+	return nil
+}
+
+func (c *fakeConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.fake.record(query, args)
+	c.fake.mu.Lock()
+	defer c.fake.mu.Unlock()
+	for _, exec := range c.fake.execs {
+		if strings.Contains(query, exec.match) {
+			if exec.err != nil {
+				return nil, exec.err
+			}
+			return driver.RowsAffected(exec.rowsAffected), nil
+		}
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (c *fakeConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	result := c.fake.record(query, args)
+	return &fakeRows{columns: result.columns, rows: result.rows}, nil
+}
+
+type fakeRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
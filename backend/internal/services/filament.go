@@ -0,0 +1,163 @@
+package services
+
+import (
+	"errors"
+	"log"
+	"math"
+
+	"github.com/torbenconto/spooler/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrFilamentNotFound   = errors.New("filament not found")
+	ErrFilamentOutOfStock = errors.New("requested filament is not in stock")
+)
+
+type FilamentService struct {
+	db *gorm.DB
+}
+
+func NewFilamentService(db *gorm.DB) *FilamentService {
+	return &FilamentService{db: db}
+}
+
+// ListFilaments returns every spool, or only the ones with filament left when inStock is set
+func (s *FilamentService) ListFilaments(inStock bool) ([]models.Filament, error) {
+	query := s.db.Order("material asc, color asc, remaining_grams desc")
+	if inStock {
+		query = query.Where("remaining_grams > 0")
+	}
+
+	var filaments []models.Filament
+	if err := query.Find(&filaments).Error; err != nil {
+		return nil, err
+	}
+	return filaments, nil
+}
+
+func (s *FilamentService) GetFilamentByID(id uint) (*models.Filament, error) {
+	var filament models.Filament
+	if err := s.db.First(&filament, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrFilamentNotFound
+		}
+		return nil, err
+	}
+	return &filament, nil
+}
+
+// InStock reports whether any spool of a material and color has filament left
+func (s *FilamentService) InStock(material, color string) (bool, error) {
+	var count int64
+	if err := s.db.Model(&models.Filament{}).
+		Where("LOWER(material) = LOWER(?) AND LOWER(color) = LOWER(?) AND remaining_grams > 0", material, color).
+		Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func (s *FilamentService) CreateFilament(filament *models.Filament) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(filament).Error; err != nil {
+			return err
+		}
+		return loadOnPrinter(tx, filament)
+	})
+}
+
+// UpdateFilament applies column updates to a spool. A printer_id of nil unloads the spool.
+func (s *FilamentService) UpdateFilament(id uint, updates map[string]any) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		var filament models.Filament
+		if err := tx.First(&filament, id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrFilamentNotFound
+			}
+			return err
+		}
+
+		if err := tx.Model(&filament).Updates(updates).Error; err != nil {
+			return err
+		}
+		if err := tx.First(&filament, id).Error; err != nil {
+			return err
+		}
+		return loadOnPrinter(tx, &filament)
+	})
+}
+
+func (s *FilamentService) DeleteFilament(id uint) error {
+	result := s.db.Delete(&models.Filament{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrFilamentNotFound
+	}
+	return nil
+}
+
+// loadOnPrinter makes a spool the only one loaded on its printer. The scheduler matches prints against the material and
+// color of the loaded spool.
+func loadOnPrinter(tx *gorm.DB, filament *models.Filament) error {
+	if filament.PrinterID == nil {
+		return nil
+	}
+
+	if err := tx.First(&models.Printer{}, *filament.PrinterID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrPrinterNotFound
+		}
+		return err
+	}
+
+	return tx.Model(&models.Filament{}).
+		Where("printer_id = ? AND id <> ?", *filament.PrinterID, filament.ID).
+		Update("printer_id", nil).Error
+}
+
+// deductFilament takes the filament a completed print used from a spool of its requested material and color,
+// the one loaded on the printer it ran on when there is one, otherwise the emptiest spool that has filament left
+func deductFilament(tx *gorm.DB, print *models.Print) error {
+	grams, err := printWeight(tx, print)
+	if err != nil {
+		return err
+	}
+	if grams <= 0 {
+		return nil
+	}
+
+	query := func() *gorm.DB {
+		return tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("LOWER(material) = LOWER(?) AND LOWER(color) = LOWER(?)", print.RequestedFilamentMaterial, print.RequestedFilamentColor)
+	}
+
+	var spools []models.Filament
+	if print.PrinterID != nil {
+		if err := query().Where("printer_id = ?", *print.PrinterID).Limit(1).Find(&spools).Error; err != nil {
+			return err
+		}
+	}
+	if len(spools) == 0 {
+		if err := query().Where("remaining_grams > 0").Order("remaining_grams asc, id asc").Limit(1).Find(&spools).Error; err != nil {
+			return err
+		}
+	}
+	if len(spools) == 0 {
+		log.Printf("no %s %s spool to deduct %.1fg for print %d from", print.RequestedFilamentMaterial, print.RequestedFilamentColor, grams, print.ID)
+		return nil
+	}
+
+	spool := spools[0]
+	if err := tx.Model(&spool).Update("remaining_grams", math.Max(spool.RemainingGrams-grams, 0)).Error; err != nil {
+		return err
+	}
+
+	return tx.Model(print).Updates(map[string]any{
+		"filament_id":   spool.ID,
+		"filament_used": grams,
+	}).Error
+}
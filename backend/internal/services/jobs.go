@@ -0,0 +1,347 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/torbenconto/spooler/internal/drivers"
+	"github.com/torbenconto/spooler/internal/models"
+	"github.com/torbenconto/spooler/internal/storage"
+	"github.com/torbenconto/spooler/internal/worker"
+)
+
+var ErrPrinterCommand = errors.New("printer rejected command")
+
+const (
+	dispatchTimeout = 30 * time.Minute
+	commandTimeout  = 15 * time.Second
+)
+
+// PrintJobService connects print status changes to the printers running them.
+// Prints on printers without a driver are left to be operated by hand.
+type PrintJobService struct {
+	prints        *PrintService
+	printers      *PrinterService
+	storageClient storage.StorageClient
+
+	// Slicing slices raw models once they are approved, nil when server side slicing is disabled
+	Slicing *SlicingService
+
+	// Background runs the uploads to printers, main hands it the worker supervisor so they are awaited on shutdown
+	Background func(name string, task worker.Task)
+
+	// OfflineTimeout is how long a printer may be unreachable while running a print before the print is failed
+	OfflineTimeout time.Duration
+
+	mu sync.Mutex
+	// dispatching holds the prints currently being uploaded to their printer, the printer cannot report on them yet
+	dispatching map[uint]bool
+	// unreachableSince holds when each printer started failing to respond
+	unreachableSince map[uint]time.Time
+}
+
+func NewPrintJobService(printSvc *PrintService, printerSvc *PrinterService, storageClient storage.StorageClient) *PrintJobService {
+	return &PrintJobService{
+		prints:           printSvc,
+		printers:         printerSvc,
+		storageClient:    storageClient,
+		Background:       runDetached,
+		OfflineTimeout:   2 * time.Minute,
+		dispatching:      make(map[uint]bool),
+		unreachableSince: make(map[uint]time.Time),
+	}
+}
+
+func (s *PrintJobService) driverFor(printerID *uint) (drivers.Driver, error) {
+	if printerID == nil {
+		return nil, nil
+	}
+
+	printer, err := s.printers.GetPrinterByID(*printerID)
+	if err != nil {
+		return nil, err
+	}
+
+	return drivers.NewDriver(printer)
+}
+
+// UpdateStatus applies a status change to a print. Pause, resume and cancel are forwarded to the printer once every check
+// passed, the change is rolled back when the printer does not take the command.
+// Moving a print from pending_print to printing on a printer with a driver dispatches the file to it in the background,
+// approving a raw model starts slicing it when slicing is enabled.
+func (s *PrintJobService) UpdateStatus(ctx context.Context, printID uint, change StatusChange) error {
+	var startsJob, startsSlicing bool
+	err := s.prints.updateStatus(printID, change, func(print models.Print) error {
+		startsJob = change.To == models.StatusPrinting && print.Status == models.StatusPendingPrint
+		if startsJob && print.SliceStatus == models.SliceRunning {
+			return ErrPrintSlicing
+		}
+		startsSlicing = change.To == models.StatusPendingPrint && print.Status == models.StatusApprovalPending && s.Slicing.NeedsSlicing(&print)
+
+		if command := printerCommand(print.Status, change.To); command != nil {
+			driver, err := s.driverFor(print.PrinterID)
+			if err != nil {
+				return err
+			}
+			if driver != nil {
+				commandCtx, cancel := context.WithTimeout(ctx, commandTimeout)
+				defer cancel()
+				if err := command(driver, commandCtx); err != nil {
+					return fmt.Errorf("%w: %v", ErrPrinterCommand, err)
+				}
+			}
+		}
+
+		// Mark the print before it becomes visible as printing so the reconciler does not fail it while the file is still uploading
+		if startsJob {
+			s.setDispatching(printID, true)
+		}
+		return nil
+	})
+	if err != nil {
+		if startsJob {
+			s.setDispatching(printID, false)
+		}
+		return err
+	}
+
+	if startsJob {
+		s.Background(fmt.Sprintf("dispatch of print %d", printID), func(ctx context.Context) error {
+			s.dispatch(ctx, printID)
+			return nil
+		})
+	}
+	if startsSlicing {
+		if err := s.Slicing.SliceInBackground(printID); err != nil {
+			log.Printf("failed to start slicing print %d: %v", printID, err)
+		}
+	}
+
+	return nil
+}
+
+// printerCommand returns the driver call a status change has to make on the printer running the print, nil if there is none
+func printerCommand(from, to models.PrintStatus) func(drivers.Driver, context.Context) error {
+	switch {
+	case to == models.StatusPaused:
+		return drivers.Driver.Pause
+	case to == models.StatusPrinting && from == models.StatusPaused:
+		return drivers.Driver.Resume
+	case to == models.StatusCanceled && (from == models.StatusPrinting || from == models.StatusPaused):
+		return drivers.Driver.Cancel
+	default:
+		return nil
+	}
+}
+
+// dispatch uploads the print's file to its printer and starts the job, failing the print if any step does not succeed
+func (s *PrintJobService) dispatch(ctx context.Context, printID uint) {
+	ctx, cancel := context.WithTimeout(ctx, dispatchTimeout)
+	defer cancel()
+	defer s.setDispatching(printID, false)
+
+	if err := s.Dispatch(ctx, printID); err != nil {
+		log.Printf("failed to dispatch print %d: %v", printID, err)
+		if err := s.prints.UpdateStatus(printID, StatusChange{
+			To:     models.StatusFailed,
+			Reason: fmt.Sprintf("dispatch failed: %v", err),
+		}); err != nil {
+			log.Printf("failed to mark print %d as failed: %v", printID, err)
+		}
+	}
+}
+
+// Dispatch streams the stored file of a print to its assigned printer and starts the job
+func (s *PrintJobService) Dispatch(ctx context.Context, printID uint) error {
+	print, err := s.prints.GetPrintByID(printID)
+	if err != nil {
+		return ErrPrintNotFound
+	}
+
+	driver, err := s.driverFor(print.PrinterID)
+	if err != nil {
+		return err
+	}
+	if driver == nil {
+		return nil
+	}
+
+	fileName := print.JobFileName()
+	file, err := s.storageClient.GetFile(ctx, fileName)
+	if err != nil {
+		return fmt.Errorf("failed to open stored file: %w", err)
+	}
+	defer file.Close()
+
+	if err := driver.Upload(ctx, fileName, file); err != nil {
+		return fmt.Errorf("upload failed: %w", err)
+	}
+
+	if err := driver.Start(ctx, fileName); err != nil {
+		return fmt.Errorf("start failed: %w", err)
+	}
+
+	return nil
+}
+
+// runDetached runs a task in a plain goroutine, services use it until they are handed a supervisor
+func runDetached(name string, task worker.Task) {
+	go func() {
+		if err := task(context.Background()); err != nil {
+			log.Printf("%s failed: %v", name, err)
+		}
+	}()
+}
+
+func (s *PrintJobService) setDispatching(printID uint, dispatching bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if dispatching {
+		s.dispatching[printID] = true
+	} else {
+		delete(s.dispatching, printID)
+	}
+}
+
+func (s *PrintJobService) isDispatching(printID uint) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dispatching[printID]
+}
+
+// Reconcile polls every printer that has a driver and brings the prints running on them in line with what the printers report.
+// A print is failed when its printer stops running it or stays unreachable for longer than OfflineTimeout.
+func (s *PrintJobService) Reconcile(ctx context.Context) error {
+	printers, err := s.printers.ListPrinters()
+	if err != nil {
+		return err
+	}
+
+	for i := range printers {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if printers[i].Driver == models.DriverNone {
+			continue
+		}
+		if err := s.reconcilePrinter(ctx, &printers[i]); err != nil {
+			log.Printf("failed to reconcile printer %s: %v", printers[i].Name, err)
+		}
+	}
+
+	return nil
+}
+
+func (s *PrintJobService) reconcilePrinter(ctx context.Context, printer *models.Printer) error {
+	print, err := s.prints.GetActivePrintForPrinter(printer.ID)
+	if err != nil {
+		return err
+	}
+	if print != nil && s.isDispatching(print.ID) {
+		return nil
+	}
+
+	driver, err := drivers.NewDriver(printer)
+	if err != nil {
+		return err
+	}
+
+	pollCtx, cancel := context.WithTimeout(ctx, commandTimeout)
+	status, err := driver.Status(pollCtx)
+	cancel()
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return s.printerUnreachable(printer, print, err)
+	}
+
+	s.mu.Lock()
+	delete(s.unreachableSince, printer.ID)
+	s.mu.Unlock()
+	if !printer.Online {
+		if err := s.printers.UpdatePrinter(printer.ID, map[string]any{"online": true}); err != nil {
+			return err
+		}
+	}
+
+	if print == nil {
+		return nil
+	}
+
+	// The printer is idle or working on another file, the job was lost on the printer side
+	if status.State == drivers.JobIdle || (status.FileName != "" && status.FileName != print.JobFileName()) {
+		return s.prints.UpdateStatus(print.ID, StatusChange{
+			To:     models.StatusFailed,
+			Reason: fmt.Sprintf("print is no longer running on printer %s", printer.Name),
+		})
+	}
+
+	return s.applyJobStatus(print, status)
+}
+
+func (s *PrintJobService) printerUnreachable(printer *models.Printer, print *models.Print, pollErr error) error {
+	s.mu.Lock()
+	since, ok := s.unreachableSince[printer.ID]
+	if !ok {
+		since = time.Now()
+		s.unreachableSince[printer.ID] = since
+	}
+	s.mu.Unlock()
+
+	if printer.Online {
+		log.Printf("printer %s went offline: %v", printer.Name, pollErr)
+		if err := s.printers.UpdatePrinter(printer.ID, map[string]any{"online": false}); err != nil {
+			return err
+		}
+	}
+
+	if print == nil || time.Since(since) < s.OfflineTimeout {
+		return nil
+	}
+
+	return s.prints.UpdateStatus(print.ID, StatusChange{
+		To:     models.StatusFailed,
+		Reason: fmt.Sprintf("printer %s went offline during the print", printer.Name),
+	})
+}
+
+func (s *PrintJobService) applyJobStatus(print *models.Print, status *drivers.JobStatus) error {
+	if status.Progress != print.Progress && status.State != drivers.JobIdle {
+		if err := s.prints.UpdatePrint(print.ID, map[string]any{"progress": status.Progress}); err != nil {
+			return err
+		}
+	}
+
+	var to models.PrintStatus
+	switch status.State {
+	case drivers.JobPrinting:
+		to = models.StatusPrinting
+	case drivers.JobPaused:
+		to = models.StatusPaused
+	case drivers.JobCompleted:
+		to = models.StatusCompleted
+	case drivers.JobFailed:
+		to = models.StatusFailed
+	case drivers.JobCanceled:
+		to = models.StatusCanceled
+	default:
+		return nil
+	}
+
+	if to == print.Status {
+		return nil
+	}
+
+	if to == models.StatusCompleted && print.Progress != 100 {
+		if err := s.prints.UpdatePrint(print.ID, map[string]any{"progress": 100}); err != nil {
+			return err
+		}
+	}
+
+	return s.prints.UpdateStatus(print.ID, StatusChange{To: to, Reason: status.Message})
+}
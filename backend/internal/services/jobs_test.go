@@ -0,0 +1,79 @@
+package services
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/torbenconto/spooler/internal/models"
+)
+
+func TestJobUpdateStatusSendsCommandAfterChecks(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   models.PrintStatus
+		slice    models.SliceStatus
+		to       models.PrintStatus
+		accepted bool
+		want     error
+		commands int32
+	}{
+		{"pause accepted", models.StatusPrinting, models.SliceNone, models.StatusPaused, true, nil, 1},
+		{"pause refused", models.StatusPrinting, models.SliceNone, models.StatusPaused, false, ErrPrinterCommand, 1},
+		{"invalid transition", models.StatusPaused, models.SliceNone, models.StatusPaused, true, &InvalidTransitionError{}, 0},
+		{"start while slicing", models.StatusPendingPrint, models.SliceRunning, models.StatusPrinting, true, ErrPrintSlicing, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var commands atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				commands.Add(1)
+				if !tt.accepted {
+					w.WriteHeader(http.StatusConflict)
+					return
+				}
+				w.WriteHeader(http.StatusNoContent)
+			}))
+			defer server.Close()
+
+			db, fake := newFakeDB(t)
+			fake.OnQuery(`FROM "prints"`, []string{"id", "status", "slice_status", "printer_id", "stored_file_name"},
+				[]driver.Value{int64(7), string(tt.status), string(tt.slice), int64(2), "a.gcode"})
+			fake.OnQuery(`FROM "printers"`, []string{"id", "name", "driver", "address", "api_key"},
+				[]driver.Value{int64(2), "mk4", string(models.DriverOctoPrint), server.URL, "key"})
+
+			printSvc := NewPrintService(db)
+			jobSvc := NewPrintJobService(printSvc, NewPrinterService(db), nil)
+			err := jobSvc.UpdateStatus(context.Background(), 7, StatusChange{To: tt.to})
+
+			var transitionErr *InvalidTransitionError
+			switch want := tt.want.(type) {
+			case nil:
+				if err != nil {
+					t.Fatalf("UpdateStatus: %v", err)
+				}
+			case *InvalidTransitionError:
+				if !errors.As(err, &transitionErr) {
+					t.Fatalf("UpdateStatus = %v, want InvalidTransitionError", err)
+				}
+			default:
+				if !errors.Is(err, want) {
+					t.Fatalf("UpdateStatus = %v, want %v", err, want)
+				}
+			}
+
+			if got := commands.Load(); got != tt.commands {
+				t.Errorf("sent %d printer commands, want %d", got, tt.commands)
+			}
+			committed := len(fake.Calls("COMMIT")) > 0
+			if committed != (tt.want == nil) {
+				t.Errorf("committed = %v, want %v", committed, tt.want == nil)
+			}
+		})
+	}
+}
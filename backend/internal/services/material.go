@@ -0,0 +1,227 @@
+package services
+
+import (
+	"errors"
+	"math"
+	"strings"
+
+	"github.com/torbenconto/spooler/internal/models"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrMaterialNotFound   = errors.New("material not found")
+	ErrMaterialNameExists = errors.New("material name already in use")
+)
+
+const (
+	// filamentDiameter is used to turn a filament length into a volume, in millimeters
+	filamentDiameter = 1.75
+	// modelFillFactor is the share of an unsliced model's volume that is printed. Models are printed with a few walls and
+	// sparse infill, which usually comes to about a third of their volume.
+	modelFillFactor = 0.35
+)
+
+type MaterialService struct {
+	db *gorm.DB
+}
+
+func NewMaterialService(db *gorm.DB) *MaterialService {
+	return &MaterialService{db: db}
+}
+
+// findMaterial looks a material up by name, ignoring case. It returns nil when there is no such material.
+func findMaterial(db *gorm.DB, name string) (*models.Material, error) {
+	var materials []models.Material
+	if err := db.Where("LOWER(name) = LOWER(?)", name).Limit(1).Find(&materials).Error; err != nil {
+		return nil, err
+	}
+	if len(materials) == 0 {
+		return nil, nil
+	}
+	return &materials[0], nil
+}
+
+// materialDensities returns the density of every material with one by lowercase name
+func materialDensities(db *gorm.DB) (map[string]float64, error) {
+	var materials []models.Material
+	if err := db.Where("density > 0").Find(&materials).Error; err != nil {
+		return nil, err
+	}
+
+	densities := make(map[string]float64, len(materials))
+	for _, material := range materials {
+		densities[strings.ToLower(material.Name)] = material.Density
+	}
+	return densities, nil
+}
+
+func (s *MaterialService) CreateMaterial(material *models.Material) error {
+	existing, err := findMaterial(s.db, material.Name)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return ErrMaterialNameExists
+	}
+
+	return s.db.Create(material).Error
+}
+
+func (s *MaterialService) ListMaterials() ([]models.Material, error) {
+	var materials []models.Material
+	if err := s.db.Order("name asc").Find(&materials).Error; err != nil {
+		return nil, err
+	}
+	return materials, nil
+}
+
+func (s *MaterialService) GetMaterialByID(id uint) (*models.Material, error) {
+	var material models.Material
+	if err := s.db.First(&material, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrMaterialNotFound
+		}
+		return nil, err
+	}
+	return &material, nil
+}
+
+// UpdateMaterial changes a material and re-estimates the cost of every print using it that is not completed yet
+func (s *MaterialService) UpdateMaterial(id uint, updates map[string]any) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		var material models.Material
+		if err := tx.First(&material, id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrMaterialNotFound
+			}
+			return err
+		}
+
+		name, renamed := updates["name"].(string)
+		if renamed {
+			existing, err := findMaterial(tx, name)
+			if err != nil {
+				return err
+			}
+			if existing != nil && existing.ID != id {
+				return ErrMaterialNameExists
+			}
+		}
+
+		if err := tx.Model(&models.Material{}).Where("id = ?", id).Updates(updates).Error; err != nil {
+			return err
+		}
+
+		// Prints of a renamed material lose their price, prints already using the new name gain one
+		if err := recalculateCosts(tx, material.Name); err != nil {
+			return err
+		}
+		if renamed && !strings.EqualFold(name, material.Name) {
+			return recalculateCosts(tx, name)
+		}
+		return nil
+	})
+}
+
+// DeleteMaterial removes a material, prints using it keep their last estimate
+func (s *MaterialService) DeleteMaterial(id uint) error {
+	result := s.db.Delete(&models.Material{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrMaterialNotFound
+	}
+	return nil
+}
+
+// recalculateBatchSize keeps the statement writing recalculated costs within the bind parameter limit of postgres
+const recalculateBatchSize = 1000
+
+// recalculateCosts re-estimates the cost of every print using a material that has not finished printing. The material
+// is looked up once and the costs are written back with one statement per batch of prints.
+func recalculateCosts(tx *gorm.DB, material string) error {
+	var prints []models.Print
+	if err := tx.Select("id", "filament_weight", "filament_length", "volume").
+		Where("LOWER(requested_filament_material) = LOWER(?) AND status <> ?", material, models.StatusCompleted).
+		Find(&prints).Error; err != nil {
+		return err
+	}
+	if len(prints) == 0 {
+		return nil
+	}
+
+	m, err := findMaterial(tx, material)
+	if err != nil {
+		return err
+	}
+
+	for start := 0; start < len(prints); start += recalculateBatchSize {
+		batch := prints[start:min(start+recalculateBatchSize, len(prints))]
+		rows := make([]string, len(batch))
+		args := make([]any, 0, 2*len(batch))
+		for i := range batch {
+			rows[i] = "(?::bigint, ?::numeric)"
+			args = append(args, batch[i].ID, materialCost(&batch[i], m))
+		}
+
+		if err := tx.Exec(`UPDATE prints SET estimated_cost = costs.cost FROM (VALUES `+strings.Join(rows, ", ")+`) AS costs(id, cost) WHERE prints.id = costs.id`,
+			args...).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// estimateCost prices a print with its requested material
+func estimateCost(db *gorm.DB, print *models.Print) (*float64, error) {
+	material, err := findMaterial(db, print.RequestedFilamentMaterial)
+	if err != nil {
+		return nil, err
+	}
+	return materialCost(print, material), nil
+}
+
+// materialCost prices a print with a material. The weight the slicer reported is used when there is one, otherwise it is
+// derived from the filament length or, for unsliced models, from the share of the model volume that is printed.
+// It is nil when there is no material, the material has no price or nothing is known about the model.
+func materialCost(print *models.Print, material *models.Material) *float64 {
+	if material == nil {
+		return nil
+	}
+
+	weight := FilamentWeight(print, material.Density)
+	if weight <= 0 || material.PricePerGram <= 0 {
+		return nil
+	}
+
+	cost := math.Round(weight*material.PricePerGram*100) / 100
+	return &cost
+}
+
+// printWeight returns the filament a print uses in grams with the density of its requested material
+func printWeight(db *gorm.DB, print *models.Print) (float64, error) {
+	density := models.DefaultMaterialDensity
+	material, err := findMaterial(db, print.RequestedFilamentMaterial)
+	if err != nil {
+		return 0, err
+	}
+	if material != nil && material.Density > 0 {
+		density = material.Density
+	}
+	return FilamentWeight(print, density), nil
+}
+
+// FilamentWeight returns the filament a print uses in grams, zero when nothing is known about the model
+func FilamentWeight(print *models.Print, density float64) float64 {
+	switch {
+	case print.FilamentWeight > 0:
+		return print.FilamentWeight
+	case print.FilamentLength > 0:
+		radius := filamentDiameter / 2
+		return print.FilamentLength * math.Pi * radius * radius / 1000 * density
+	default:
+		return print.Volume * modelFillFactor / 1000 * density
+	}
+}
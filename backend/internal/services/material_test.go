@@ -0,0 +1,67 @@
+package services
+
+import (
+	"database/sql/driver"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/torbenconto/spooler/internal/models"
+)
+
+func TestFilamentWeight(t *testing.T) {
+	tests := []struct {
+		name  string
+		print models.Print
+		want  float64
+	}{
+		{"slicer weight", models.Print{FilamentWeight: 12.5, FilamentLength: 9000, Volume: 50000}, 12.5},
+		// 1m of 1.75mm filament is 2.405cm³
+		{"filament length", models.Print{FilamentLength: 1000, Volume: 50000}, 2.405 * models.DefaultMaterialDensity},
+		// A 50cm³ model is not printed solid
+		{"unsliced model", models.Print{Volume: 50000}, 50 * modelFillFactor * models.DefaultMaterialDensity},
+		{"nothing known", models.Print{}, 0},
+	}
+
+	for _, tt := range tests {
+		if got := FilamentWeight(&tt.print, models.DefaultMaterialDensity); math.Abs(got-tt.want) > 0.01 {
+			t.Errorf("%s: FilamentWeight = %.3f, want %.3f", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRecalculateCostsWritesOneStatement(t *testing.T) {
+	db, fake := newFakeDB(t)
+	fake.OnQuery(`FROM "prints"`, []string{"id", "filament_weight", "filament_length", "volume"},
+		[]driver.Value{int64(1), 10.0, 0.0, 0.0},
+		[]driver.Value{int64(2), 0.0, 0.0, 0.0},
+		[]driver.Value{int64(3), 20.0, 0.0, 0.0})
+	fake.OnQuery(`FROM "materials"`, []string{"id", "name", "price_per_gram", "density"},
+		[]driver.Value{int64(1), "PLA", 0.05, models.DefaultMaterialDensity})
+
+	if err := recalculateCosts(db, "pla"); err != nil {
+		t.Fatalf("recalculateCosts: %v", err)
+	}
+
+	if queries := fake.Calls(`FROM "materials"`); len(queries) != 1 {
+		t.Errorf("looked the material up %d times, want once", len(queries))
+	}
+	updates := fake.Calls("UPDATE prints")
+	if len(updates) != 1 {
+		t.Fatalf("ran %d cost updates, want 1", len(updates))
+	}
+	if !strings.Contains(updates[0].Query, "FROM (VALUES") {
+		t.Errorf("costs are not written from a value list: %s", updates[0].Query)
+	}
+	// The print nothing is known about loses its estimate
+	want := []driver.Value{int64(1), 0.5, int64(2), nil, int64(3), 1.0}
+	if len(updates[0].Args) != len(want) {
+		t.Fatalf("update args = %v, want %v", updates[0].Args, want)
+	}
+	for i := range want {
+		if updates[0].Args[i] != want[i] {
+			t.Errorf("update args = %v, want %v", updates[0].Args, want)
+			break
+		}
+	}
+}
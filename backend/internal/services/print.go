@@ -1,10 +1,60 @@
 package services
 
 import (
+	"errors"
+	"fmt"
+
+	"github.com/torbenconto/spooler/internal/drivers"
 	"github.com/torbenconto/spooler/internal/models"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrPrintNotFound         = errors.New("print not found")
+	ErrDenialReasonRequired  = errors.New("denial reason is required when denying a print")
+	ErrDenialReasonNotDenied = errors.New("a denial reason can only be given to denied prints")
+	ErrPrinterAssignment     = errors.New("a printer can only be assigned when a print starts printing")
+	ErrPrintNotSliced        = errors.New("the model has to be sliced before it can be sent to a printer")
+	ErrPrinterFileType       = errors.New("the printer cannot start this file type")
+	ErrInvalidSort           = errors.New("invalid sort option")
+	ErrCostNotCompleted      = errors.New("actual cost can only be recorded for completed prints")
+	ErrPrintFilePurged       = errors.New("the file of this print was deleted by the retention policy")
 )
 
+// InvalidTransitionError is returned when a status change is not allowed by the print state machine
+type InvalidTransitionError struct {
+	From models.PrintStatus
+	To   models.PrintStatus
+}
+
+func (e *InvalidTransitionError) Error() string {
+	return fmt.Sprintf("cannot transition print from %s to %s", e.From, e.To)
+}
+
+// printTransitions lists every status a print is allowed to move to from a given status.
+// completed is terminal, a denied print can only be sent back for review.
+var printTransitions = map[models.PrintStatus][]models.PrintStatus{
+	models.StatusApprovalPending: {models.StatusPendingPrint, models.StatusDenied},
+	models.StatusPendingPrint:    {models.StatusPrinting, models.StatusDenied, models.StatusCanceled},
+	models.StatusPrinting:        {models.StatusPaused, models.StatusCompleted, models.StatusFailed, models.StatusCanceled},
+	models.StatusPaused:          {models.StatusPrinting, models.StatusFailed, models.StatusCanceled},
+	models.StatusFailed:          {models.StatusPendingPrint},
+	models.StatusCanceled:        {models.StatusPendingPrint},
+	models.StatusDenied:          {models.StatusApprovalPending},
+	models.StatusCompleted:       {},
+}
+
+// CanTransition reports whether a print may move from one status to another
+func CanTransition(from, to models.PrintStatus) bool {
+	for _, allowed := range printTransitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
 type PrintService struct {
 	db *gorm.DB
 }
@@ -15,44 +65,352 @@ func NewPrintService(db *gorm.DB) *PrintService {
 	}
 }
 
+// StatusChange describes a requested status transition for a print
+type StatusChange struct {
+	To     models.PrintStatus
+	Reason string
+	// ActorID is the user making the change, nil for changes made by the system
+	ActorID *uint
+	// PrinterID assigns the print to a printer, only valid when moving to printing
+	PrinterID *uint
+}
+
 func (s *PrintService) CreatePrint(print *models.Print) error {
-	if err := s.db.Create(print).Error; err != nil {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		return createPrint(tx, print)
+	})
+}
+
+// createPrint inserts a print along with the event of its initial status
+func createPrint(tx *gorm.DB, print *models.Print) error {
+	if print.Status == "" {
+		print.Status = models.StatusApprovalPending
+	}
+
+	if err := tx.Create(print).Error; err != nil {
 		return err
 	}
-	return nil
+
+	return tx.Create(&models.PrintStatusEvent{
+		PrintID:  print.ID,
+		ActorID:  &print.UserID,
+		ToStatus: print.Status,
+	}).Error
 }
 
 func (s *PrintService) GetUserPrintsByID(id uint) ([]models.Print, error) {
 	var prints []models.Print
-	if err := s.db.Where("user_id = ?", id).Find(&prints).Error; err != nil {
+	if err := s.db.Preload("Objects").Where("user_id = ?", id).Find(&prints).Error; err != nil {
 		return nil, err
 	}
 	return prints, nil
 }
 
-func (s *PrintService) AllPrints() ([]models.Print, error) {
+// printOrders maps the sort options of the print list to their order clauses
+var printOrders = map[string]string{
+	"":               "id asc",
+	"created_at":     "created_at desc, id desc",
+	"priority":       "priority desc, created_at asc, id asc",
+	"estimated_time": "estimated_print_seconds asc, id asc",
+	"filament":       "filament_weight asc, id asc",
+}
+
+// AllPrints lists every print ordered by one of the printOrders options
+func (s *PrintService) AllPrints(sort string) ([]models.Print, error) {
+	order, ok := printOrders[sort]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidSort, sort)
+	}
+
 	var prints []models.Print
-	if err := s.db.Find(&prints).Error; err != nil {
+	if err := s.db.Preload("Objects").Order(order).Find(&prints).Error; err != nil {
+		return nil, err
+	}
+
+	if err := s.attachPreviousDenials(prints); err != nil {
 		return nil, err
 	}
 
 	return prints, nil
 }
 
+// attachPreviousDenials fills in the earlier denied prints of the same file for every print awaiting approval,
+// so reviewers see when a file is resubmitted after being denied
+func (s *PrintService) attachPreviousDenials(prints []models.Print) error {
+	var hashes []string
+	for _, print := range prints {
+		if print.FileHash != "" && print.Status == models.StatusApprovalPending {
+			hashes = append(hashes, print.FileHash)
+		}
+	}
+	if len(hashes) == 0 {
+		return nil
+	}
+
+	var denied []models.Print
+	if err := s.db.Where("file_hash IN ? AND status = ?", hashes, models.StatusDenied).Order("created_at desc").Find(&denied).Error; err != nil {
+		return err
+	}
+
+	for i := range prints {
+		if prints[i].Status != models.StatusApprovalPending {
+			continue
+		}
+		for _, d := range denied {
+			if d.FileHash == prints[i].FileHash && d.CreatedAt.Before(prints[i].CreatedAt) {
+				prints[i].PreviousDenials = append(prints[i].PreviousDenials, models.PreviousDenial{
+					PrintID:      d.ID,
+					UserID:       d.UserID,
+					DenialReason: d.DenialReason,
+					SubmittedAt:  d.CreatedAt,
+				})
+			}
+		}
+	}
+	return nil
+}
+
 func (s *PrintService) DeletePrint(printID uint) error {
-	return s.db.Delete(&models.Print{}, printID).Error
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		return deletePrint(tx, printID)
+	})
+}
+
+// deletePrint removes a print and everything recorded about it
+func deletePrint(tx *gorm.DB, printID uint) error {
+	if err := tx.Where("print_id = ?", printID).Delete(&models.PrintStatusEvent{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("print_id = ?", printID).Delete(&models.PrintObject{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("print_id = ?", printID).Delete(&models.SliceJob{}).Error; err != nil {
+		return err
+	}
+	return tx.Delete(&models.Print{}, printID).Error
 }
 
 func (s *PrintService) GetPrintByID(id uint) (*models.Print, error) {
 	var print models.Print
-	if err := s.db.First(&print, id).Error; err != nil {
+	if err := s.db.Preload("Objects").First(&print, id).Error; err != nil {
 		return nil, err
 	}
 	return &print, nil
 }
 
+// GetQueuedPrints returns the prints waiting for a printer, highest priority first and oldest first within a priority
+func (s *PrintService) GetQueuedPrints() ([]models.Print, error) {
+	var prints []models.Print
+	if err := s.db.Where("status = ?", models.StatusPendingPrint).
+		Order("priority desc, created_at asc, id asc").
+		Find(&prints).Error; err != nil {
+		return nil, err
+	}
+	return prints, nil
+}
+
+// BusyPrinterIDs returns the ids of every printer currently running or holding a paused print
+func (s *PrintService) BusyPrinterIDs() (map[uint]bool, error) {
+	var ids []uint
+	if err := s.db.Model(&models.Print{}).
+		Where("printer_id IS NOT NULL AND status IN ?", []models.PrintStatus{models.StatusPrinting, models.StatusPaused}).
+		Distinct().
+		Pluck("printer_id", &ids).Error; err != nil {
+		return nil, err
+	}
+
+	busy := make(map[uint]bool, len(ids))
+	for _, id := range ids {
+		busy[id] = true
+	}
+	return busy, nil
+}
+
+// GetActivePrintForPrinter returns the print currently printing or paused on a printer, nil if the printer is free
+func (s *PrintService) GetActivePrintForPrinter(printerID uint) (*models.Print, error) {
+	var prints []models.Print
+	if err := s.db.Where("printer_id = ? AND status IN ?", printerID, []models.PrintStatus{models.StatusPrinting, models.StatusPaused}).
+		Order("updated_at desc").
+		Limit(1).
+		Find(&prints).Error; err != nil {
+		return nil, err
+	}
+	if len(prints) == 0 {
+		return nil, nil
+	}
+	return &prints[0], nil
+}
+
+// UpdatePrint applies raw column updates to a print. Status changes must go through UpdateStatus so the state machine is enforced.
 func (s *PrintService) UpdatePrint(printID uint, updates map[string]any) error {
+	if _, ok := updates["status"]; ok {
+		return errors.New("status must be updated through UpdateStatus")
+	}
+
 	return s.db.Model(&models.Print{}).
 		Where("id = ?", printID).
 		Updates(updates).Error
 }
+
+// UpdateStatus moves a print to a new status, rejecting any move not present in the transition table.
+// A denial reason is required when the target status is denied. Completing a print deducts its filament from stock. Every accepted transition is recorded as a PrintStatusEvent in the same transaction.
+func (s *PrintService) UpdateStatus(printID uint, change StatusChange) error {
+	return s.updateStatus(printID, change, nil)
+}
+
+// updateStatus applies a status change under a lock on the print. commit is called with the print as it was before the
+// change once every check passed and the change is written, an error from it rolls the change back.
+func (s *PrintService) updateStatus(printID uint, change StatusChange, commit func(models.Print) error) error {
+	if change.To == models.StatusDenied && change.Reason == "" {
+		return ErrDenialReasonRequired
+	}
+	if change.PrinterID != nil && change.To != models.StatusPrinting {
+		return ErrPrinterAssignment
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		var print models.Print
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&print, printID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrPrintNotFound
+			}
+			return err
+		}
+
+		if !CanTransition(print.Status, change.To) {
+			return &InvalidTransitionError{From: print.Status, To: change.To}
+		}
+		// A denied print cannot be reopened once there is no file left to print
+		if print.FilePurgedAt != nil {
+			return ErrPrintFilePurged
+		}
+
+		// Updates writes the new values back into print, the print as it was has to be kept for the event and commit
+		before := print
+		from := print.Status
+		updates := map[string]any{"status": change.To}
+		if change.To == models.StatusDenied {
+			updates["denial_reason"] = change.Reason
+		}
+		// The reason of an earlier denial no longer applies once the print is back under review
+		if from == models.StatusDenied {
+			updates["denial_reason"] = ""
+		}
+
+		if change.PrinterID != nil {
+			var printer models.Printer
+			if err := tx.First(&printer, *change.PrinterID).Error; err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					return ErrPrinterNotFound
+				}
+				return err
+			}
+			if !drivers.Printable(print.JobFileName()) {
+				if print.SliceStatus == models.SliceRunning {
+					return ErrPrintSlicing
+				}
+				return ErrPrintNotSliced
+			}
+			if !drivers.Accepts(printer.Driver, print.JobFileName()) {
+				return ErrPrinterFileType
+			}
+
+			var active int64
+			if err := tx.Model(&models.Print{}).
+				Where("printer_id = ? AND id <> ? AND status IN ?", *change.PrinterID, print.ID, []models.PrintStatus{models.StatusPrinting, models.StatusPaused}).
+				Count(&active).Error; err != nil {
+				return err
+			}
+			if active > 0 {
+				return ErrPrinterBusy
+			}
+
+			updates["printer_id"] = *change.PrinterID
+		}
+
+		if err := tx.Model(&print).Updates(updates).Error; err != nil {
+			return err
+		}
+
+		if change.To == models.StatusCompleted {
+			if err := deductFilament(tx, &print); err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Create(&models.PrintStatusEvent{
+			PrintID:    print.ID,
+			ActorID:    change.ActorID,
+			FromStatus: from,
+			ToStatus:   change.To,
+			Reason:     change.Reason,
+		}).Error; err != nil {
+			return err
+		}
+
+		if commit != nil {
+			return commit(before)
+		}
+		return nil
+	})
+}
+
+// SetDenialReason rewrites the reason given for a denied print
+func (s *PrintService) SetDenialReason(printID uint, reason string) error {
+	var print models.Print
+	if err := s.db.First(&print, printID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrPrintNotFound
+		}
+		return err
+	}
+	if print.Status != models.StatusDenied {
+		return ErrDenialReasonNotDenied
+	}
+
+	return s.db.Model(&print).Update("denial_reason", reason).Error
+}
+
+// EstimateCost prices a print with the current price of its requested material, nil when it cannot be priced
+func (s *PrintService) EstimateCost(print *models.Print) (*float64, error) {
+	return estimateCost(s.db, print)
+}
+
+// RefreshEstimatedCost re-estimates the cost of a stored print, used once its G-code has been analyzed
+func (s *PrintService) RefreshEstimatedCost(printID uint) error {
+	print, err := s.GetPrintByID(printID)
+	if err != nil {
+		return err
+	}
+
+	cost, err := s.EstimateCost(print)
+	if err != nil {
+		return err
+	}
+	return s.UpdatePrint(printID, map[string]any{"estimated_cost": cost})
+}
+
+// SetActualCost records what a completed print really cost
+func (s *PrintService) SetActualCost(printID uint, cost float64) error {
+	var print models.Print
+	if err := s.db.First(&print, printID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrPrintNotFound
+		}
+		return err
+	}
+	if print.Status != models.StatusCompleted {
+		return ErrCostNotCompleted
+	}
+
+	return s.db.Model(&print).Update("actual_cost", cost).Error
+}
+
+// GetPrintHistory returns every recorded status transition of a print, oldest first
+func (s *PrintService) GetPrintHistory(printID uint) ([]models.PrintStatusEvent, error) {
+	var events []models.PrintStatusEvent
+	if err := s.db.Where("print_id = ?", printID).Order("created_at asc, id asc").Find(&events).Error; err != nil {
+		return nil, err
+	}
+	return events, nil
+}
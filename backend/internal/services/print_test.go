@@ -0,0 +1,125 @@
+package services
+
+import (
+	"database/sql/driver"
+	"testing"
+
+	"github.com/torbenconto/spooler/internal/models"
+)
+
+func TestUpdateStatusRecordsTransition(t *testing.T) {
+	db, fake := newFakeDB(t)
+	fake.OnQuery(`FROM "prints"`, []string{"id", "status"}, []driver.Value{int64(7), string(models.StatusApprovalPending)})
+
+	actor := uint(3)
+	if err := NewPrintService(db).UpdateStatus(7, StatusChange{To: models.StatusPendingPrint, ActorID: &actor}); err != nil {
+		t.Fatalf("UpdateStatus: %v", err)
+	}
+
+	events := fake.Calls(`INSERT INTO "print_status_events"`)
+	if len(events) != 1 {
+		t.Fatalf("recorded %d status events, want 1", len(events))
+	}
+	for column, want := range map[string]string{
+		"from_status": string(models.StatusApprovalPending),
+		"to_status":   string(models.StatusPendingPrint),
+	} {
+		if got, _ := events[0].Arg(column); got != want {
+			t.Errorf("%s = %v, want %s", column, got, want)
+		}
+	}
+	if got, _ := events[0].Arg("print_id"); got != int64(7) {
+		t.Errorf("print_id = %v, want 7", got)
+	}
+}
+
+func TestUpdateStatusRejectsInvalidTransition(t *testing.T) {
+	db, fake := newFakeDB(t)
+	fake.OnQuery(`FROM "prints"`, []string{"id", "status"}, []driver.Value{int64(7), string(models.StatusCompleted)})
+
+	err := NewPrintService(db).UpdateStatus(7, StatusChange{To: models.StatusPrinting})
+	if _, ok := err.(*InvalidTransitionError); !ok {
+		t.Fatalf("UpdateStatus = %v, want InvalidTransitionError", err)
+	}
+	if events := fake.Calls(`INSERT INTO "print_status_events"`); len(events) != 0 {
+		t.Errorf("recorded %d status events for a rejected transition", len(events))
+	}
+}
+
+func TestUpdateStatusClearsDenialReasonOnReview(t *testing.T) {
+	db, fake := newFakeDB(t)
+	fake.OnQuery(`FROM "prints"`, []string{"id", "status", "denial_reason"},
+		[]driver.Value{int64(7), string(models.StatusDenied), "wrong material"})
+
+	if err := NewPrintService(db).UpdateStatus(7, StatusChange{To: models.StatusApprovalPending}); err != nil {
+		t.Fatalf("UpdateStatus: %v", err)
+	}
+
+	updates := fake.Calls(`UPDATE "prints"`)
+	if len(updates) != 1 {
+		t.Fatalf("ran %d print updates, want 1", len(updates))
+	}
+	if got, ok := updates[0].Arg("denial_reason"); !ok || got != "" {
+		t.Errorf("denial_reason = %v (set %v), want it cleared", got, ok)
+	}
+}
+
+func TestSetDenialReason(t *testing.T) {
+	tests := []struct {
+		status models.PrintStatus
+		want   error
+	}{
+		{models.StatusDenied, nil},
+		{models.StatusApprovalPending, ErrDenialReasonNotDenied},
+		{models.StatusCompleted, ErrDenialReasonNotDenied},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.status), func(t *testing.T) {
+			db, fake := newFakeDB(t)
+			fake.OnQuery(`FROM "prints"`, []string{"id", "status"}, []driver.Value{int64(7), string(tt.status)})
+
+			if err := NewPrintService(db).SetDenialReason(7, "too large"); err != tt.want {
+				t.Fatalf("SetDenialReason = %v, want %v", err, tt.want)
+			}
+			if updated := len(fake.Calls(`UPDATE "prints"`)) > 0; updated != (tt.want == nil) {
+				t.Errorf("updated the print = %v, want %v", updated, tt.want == nil)
+			}
+		})
+	}
+}
+
+func TestUpdateStatusChecksAssignedPrinterFileType(t *testing.T) {
+	tests := []struct {
+		name   string
+		file   string
+		slice  models.SliceStatus
+		driver models.PrinterDriver
+		want   error
+	}{
+		{"gcode on octoprint", "a.gcode", models.SliceNone, models.DriverOctoPrint, nil},
+		{"project on bambu", "a.gcode.3mf", models.SliceNone, models.DriverBambu, nil},
+		{"gcode on bambu", "a.gcode", models.SliceNone, models.DriverBambu, ErrPrinterFileType},
+		{"raw model on a manual printer", "a.stl", models.SliceNone, models.DriverNone, ErrPrintNotSliced},
+		{"raw model being sliced", "a.stl", models.SliceRunning, models.DriverOctoPrint, ErrPrintSlicing},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, fake := newFakeDB(t)
+			fake.OnQuery(`SELECT count(*)`, []string{"count"}, []driver.Value{int64(0)})
+			fake.OnQuery(`FROM "prints"`, []string{"id", "status", "stored_file_name", "slice_status"},
+				[]driver.Value{int64(7), string(models.StatusPendingPrint), tt.file, string(tt.slice)})
+			fake.OnQuery(`FROM "printers"`, []string{"id", "name", "driver"}, []driver.Value{int64(2), "mk4", string(tt.driver)})
+
+			printerID := uint(2)
+			err := NewPrintService(db).UpdateStatus(7, StatusChange{To: models.StatusPrinting, PrinterID: &printerID})
+			if err != tt.want {
+				t.Fatalf("UpdateStatus = %v, want %v", err, tt.want)
+			}
+			if assigned := len(fake.Calls(`UPDATE "prints"`)) > 0; assigned != (tt.want == nil) {
+				t.Errorf("assigned the printer = %v, want %v", assigned, tt.want == nil)
+			}
+		})
+	}
+}
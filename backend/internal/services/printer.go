@@ -0,0 +1,139 @@
+package services
+
+import (
+	"errors"
+
+	"github.com/torbenconto/spooler/internal/models"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrPrinterNotFound   = errors.New("printer not found")
+	ErrPrinterNameExists = errors.New("printer name already in use")
+	ErrPrinterBusy       = errors.New("printer is already running a print")
+)
+
+type PrinterService struct {
+	db *gorm.DB
+}
+
+func NewPrinterService(db *gorm.DB) *PrinterService {
+	return &PrinterService{db: db}
+}
+
+func (s *PrinterService) CreatePrinter(printer *models.Printer) error {
+	var existing models.Printer
+	if err := s.db.Where("name = ?", printer.Name).First(&existing).Error; err == nil {
+		return ErrPrinterNameExists
+	}
+
+	return s.db.Create(printer).Error
+}
+
+func (s *PrinterService) ListPrinters() ([]models.Printer, error) {
+	var printers []models.Printer
+	if err := s.db.Order("name asc").Find(&printers).Error; err != nil {
+		return nil, err
+	}
+	if err := withLoadedFilament(s.db, printers); err != nil {
+		return nil, err
+	}
+	return printers, nil
+}
+
+func (s *PrinterService) GetPrinterByID(id uint) (*models.Printer, error) {
+	var printer models.Printer
+	if err := s.db.First(&printer, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrPrinterNotFound
+		}
+		return nil, err
+	}
+
+	printers := []models.Printer{printer}
+	if err := withLoadedFilament(s.db, printers); err != nil {
+		return nil, err
+	}
+	return &printers[0], nil
+}
+
+// withLoadedFilament fills in the material and color of the spool loaded on each printer
+func withLoadedFilament(db *gorm.DB, printers []models.Printer) error {
+	if len(printers) == 0 {
+		return nil
+	}
+
+	ids := make([]uint, len(printers))
+	for i := range printers {
+		ids[i] = printers[i].ID
+	}
+	var spools []models.Filament
+	if err := db.Where("printer_id IN ?", ids).Find(&spools).Error; err != nil {
+		return err
+	}
+
+	loaded := make(map[uint]*models.Filament, len(spools))
+	for i := range spools {
+		loaded[*spools[i].PrinterID] = &spools[i]
+	}
+	for i := range printers {
+		if spool, ok := loaded[printers[i].ID]; ok {
+			printers[i].LoadedFilamentColor = spool.Color
+			printers[i].LoadedFilamentMaterial = spool.Material
+		}
+	}
+	return nil
+}
+
+func (s *PrinterService) UpdatePrinter(id uint, updates map[string]any) error {
+	if name, ok := updates["name"]; ok {
+		var existing models.Printer
+		if err := s.db.Where("name = ? AND id <> ?", name, id).First(&existing).Error; err == nil {
+			return ErrPrinterNameExists
+		}
+	}
+
+	result := s.db.Model(&models.Printer{}).Where("id = ?", id).Updates(updates)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrPrinterNotFound
+	}
+	return nil
+}
+
+// DeletePrinter removes a printer, refusing to do so while it is running a print.
+// Open prints assigned to the printer and the spool loaded on it are detached from it, finished prints keep its id.
+func (s *PrinterService) DeletePrinter(id uint) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		var printer models.Printer
+		if err := tx.First(&printer, id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrPrinterNotFound
+			}
+			return err
+		}
+
+		var active int64
+		if err := tx.Model(&models.Print{}).
+			Where("printer_id = ? AND status IN ?", id, []models.PrintStatus{models.StatusPrinting, models.StatusPaused}).
+			Count(&active).Error; err != nil {
+			return err
+		}
+		if active > 0 {
+			return ErrPrinterBusy
+		}
+
+		if err := tx.Model(&models.Print{}).
+			Where("printer_id = ? AND status IN ?", id, []models.PrintStatus{models.StatusApprovalPending, models.StatusPendingPrint}).
+			Update("printer_id", nil).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.Filament{}).Where("printer_id = ?", id).Update("printer_id", nil).Error; err != nil {
+			return err
+		}
+
+		return tx.Delete(&printer).Error
+	})
+}
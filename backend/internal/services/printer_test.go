@@ -0,0 +1,50 @@
+package services
+
+import (
+	"database/sql/driver"
+	"testing"
+)
+
+func TestDeletePrinterOnlyReleasesOpenPrints(t *testing.T) {
+	db, fake := newFakeDB(t)
+	fake.OnQuery(`SELECT count(*)`, []string{"count"}, []driver.Value{int64(0)})
+	fake.OnQuery(`FROM "printers"`, []string{"id", "name"}, []driver.Value{int64(2), "mk4"})
+
+	if err := NewPrinterService(db).DeletePrinter(2); err != nil {
+		t.Fatalf("DeletePrinter: %v", err)
+	}
+
+	releases := fake.Calls(`UPDATE "prints" SET "printer_id"`)
+	if len(releases) != 1 {
+		t.Fatalf("ran %d print releases, want 1", len(releases))
+	}
+	var statuses []string
+	for _, arg := range releases[0].Args {
+		if status, ok := arg.(string); ok {
+			statuses = append(statuses, status)
+		}
+	}
+	if len(statuses) != 2 || statuses[0] != "approval_pending" || statuses[1] != "pending_print" {
+		t.Errorf("released prints with statuses %v, want approval_pending and pending_print", statuses)
+	}
+	if deletes := fake.Calls(`DELETE FROM "printers"`); len(deletes) != 1 {
+		t.Errorf("ran %d printer deletes, want 1", len(deletes))
+	}
+}
+
+func TestListPrintersTakesLoadedFilamentFromSpools(t *testing.T) {
+	db, fake := newFakeDB(t)
+	fake.OnQuery(`FROM "printers"`, []string{"id", "name"}, []driver.Value{int64(1), "mk4"}, []driver.Value{int64(2), "x1c"})
+	fake.OnQuery(`FROM "filaments"`, []string{"id", "material", "color", "printer_id"}, []driver.Value{int64(5), "PETG", "#ff0000", int64(2)})
+
+	printers, err := NewPrinterService(db).ListPrinters()
+	if err != nil {
+		t.Fatalf("ListPrinters: %v", err)
+	}
+	if printers[0].LoadedFilamentColor != "" || printers[0].LoadedFilamentMaterial != "" {
+		t.Errorf("printer without a spool has %s %s loaded", printers[0].LoadedFilamentMaterial, printers[0].LoadedFilamentColor)
+	}
+	if printers[1].LoadedFilamentColor != "#ff0000" || printers[1].LoadedFilamentMaterial != "PETG" {
+		t.Errorf("printer has %s %s loaded, want the PETG #ff0000 spool", printers[1].LoadedFilamentMaterial, printers[1].LoadedFilamentColor)
+	}
+}
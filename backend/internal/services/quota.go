@@ -0,0 +1,194 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/torbenconto/spooler/internal/models"
+	"github.com/torbenconto/spooler/internal/types"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// openStatuses are the statuses of prints that count towards MaxOpenPrints
+var openStatuses = []models.PrintStatus{
+	models.StatusApprovalPending,
+	models.StatusPendingPrint,
+	models.StatusPrinting,
+	models.StatusPaused,
+}
+
+// QuotaUsage is what a user has submitted against their quota
+type QuotaUsage struct {
+	OpenPrints     int     `json:"open_prints"`
+	GramsThisWeek  float64 `json:"grams_this_week"`
+	GramsThisMonth float64 `json:"grams_this_month"`
+}
+
+// QuotaStatus is the quota that applies to a user along with their current usage
+type QuotaStatus struct {
+	Quota types.Quota `json:"quota"`
+	Usage QuotaUsage  `json:"usage"`
+	// Override is set when an admin gave the user a quota of their own
+	Override *models.QuotaOverride `json:"override"`
+}
+
+// Allows checks a new submission of fileSize bytes using grams of filament against the quota
+func (q *QuotaStatus) Allows(fileSize int64, grams float64) error {
+	switch {
+	case q.Quota.MaxFileSize > 0 && fileSize > q.Quota.MaxFileSize:
+		return fmt.Errorf("%w: files may be at most %d bytes", ErrQuotaExceeded, q.Quota.MaxFileSize)
+	case q.Quota.MaxOpenPrints > 0 && q.Usage.OpenPrints >= q.Quota.MaxOpenPrints:
+		return fmt.Errorf("%w: at most %d prints may be open at once", ErrQuotaExceeded, q.Quota.MaxOpenPrints)
+	case q.Quota.MaxGramsPerWeek > 0 && q.Usage.GramsThisWeek+grams > q.Quota.MaxGramsPerWeek:
+		return fmt.Errorf("%w: %.0fg of %.0fg weekly filament used, this print needs %.0fg", ErrQuotaExceeded, q.Usage.GramsThisWeek, q.Quota.MaxGramsPerWeek, grams)
+	case q.Quota.MaxGramsPerMonth > 0 && q.Usage.GramsThisMonth+grams > q.Quota.MaxGramsPerMonth:
+		return fmt.Errorf("%w: %.0fg of %.0fg monthly filament used, this print needs %.0fg", ErrQuotaExceeded, q.Usage.GramsThisMonth, q.Quota.MaxGramsPerMonth, grams)
+	default:
+		return nil
+	}
+}
+
+type QuotaService struct {
+	db *gorm.DB
+	// defaults are the quotas of each role
+	defaults map[models.Role]types.Quota
+}
+
+func NewQuotaService(db *gorm.DB, defaults map[models.Role]types.Quota) *QuotaService {
+	return &QuotaService{db: db, defaults: defaults}
+}
+
+// GetQuotaStatus returns the quota of a user with the given role and what they have used of it
+func (s *QuotaService) GetQuotaStatus(userID uint, role models.Role) (*QuotaStatus, error) {
+	status := &QuotaStatus{Quota: s.defaults[role]}
+
+	var overrides []models.QuotaOverride
+	if err := s.db.Where("user_id = ?", userID).Limit(1).Find(&overrides).Error; err != nil {
+		return nil, err
+	}
+	if len(overrides) > 0 {
+		status.Override = &overrides[0]
+		applyOverride(&status.Quota, status.Override)
+	}
+
+	usage, err := s.usage(userID)
+	if err != nil {
+		return nil, err
+	}
+	status.Usage = *usage
+
+	return status, nil
+}
+
+func applyOverride(quota *types.Quota, override *models.QuotaOverride) {
+	if override.MaxOpenPrints != nil {
+		quota.MaxOpenPrints = *override.MaxOpenPrints
+	}
+	if override.MaxGramsPerWeek != nil {
+		quota.MaxGramsPerWeek = *override.MaxGramsPerWeek
+	}
+	if override.MaxGramsPerMonth != nil {
+		quota.MaxGramsPerMonth = *override.MaxGramsPerMonth
+	}
+	if override.MaxFileSize != nil {
+		quota.MaxFileSize = *override.MaxFileSize
+	}
+}
+
+// usage counts the open prints of a user and the filament of everything they submitted in the last 7 and 30 days.
+// Denied and canceled prints do not count, completed prints count with the filament that was actually deducted.
+func (s *QuotaService) usage(userID uint) (*QuotaUsage, error) {
+	var usage QuotaUsage
+
+	var open int64
+	if err := s.db.Model(&models.Print{}).Where("user_id = ? AND status IN ?", userID, openStatuses).Count(&open).Error; err != nil {
+		return nil, err
+	}
+	usage.OpenPrints = int(open)
+
+	now := time.Now()
+	weekStart := now.AddDate(0, 0, -7)
+
+	var prints []models.Print
+	if err := s.db.Where("user_id = ? AND created_at >= ? AND status NOT IN ?", userID, now.AddDate(0, 0, -30),
+		[]models.PrintStatus{models.StatusDenied, models.StatusCanceled}).
+		Find(&prints).Error; err != nil {
+		return nil, err
+	}
+
+	densities, err := materialDensities(s.db)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range prints {
+		grams := prints[i].FilamentUsed
+		if grams <= 0 {
+			density, ok := densities[strings.ToLower(prints[i].RequestedFilamentMaterial)]
+			if !ok {
+				density = models.DefaultMaterialDensity
+			}
+			grams = FilamentWeight(&prints[i], density)
+		}
+
+		usage.GramsThisMonth += grams
+		if prints[i].CreatedAt.After(weekStart) {
+			usage.GramsThisWeek += grams
+		}
+	}
+
+	return &usage, nil
+}
+
+// checkOpenPrints returns ErrQuotaExceeded when a user already has max open prints, 0 is no limit. The user row is locked
+// until tx ends, so concurrent submissions of the same user are counted one after another.
+func checkOpenPrints(tx *gorm.DB, userID uint, max int) error {
+	if max <= 0 {
+		return nil
+	}
+
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&models.User{}, userID).Error; err != nil {
+		return err
+	}
+
+	var open int64
+	if err := tx.Model(&models.Print{}).Where("user_id = ? AND status IN ?", userID, openStatuses).Count(&open).Error; err != nil {
+		return err
+	}
+	if int(open) >= max {
+		return fmt.Errorf("%w: at most %d prints may be open at once", ErrQuotaExceeded, max)
+	}
+	return nil
+}
+
+// EstimateGrams returns the filament a new print is expected to use
+func (s *QuotaService) EstimateGrams(print *models.Print) (float64, error) {
+	return printWeight(s.db, print)
+}
+
+// SetOverride replaces the quota override of a user, nil fields use the role default
+func (s *QuotaService) SetOverride(override *models.QuotaOverride) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&models.User{}, override.UserID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrUserNotFound
+			}
+			return err
+		}
+
+		if err := tx.Where("user_id = ?", override.UserID).Delete(&models.QuotaOverride{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(override).Error
+	})
+}
+
+// DeleteOverride puts a user back on the quota of their role
+func (s *QuotaService) DeleteOverride(userID uint) error {
+	return s.db.Where("user_id = ?", userID).Delete(&models.QuotaOverride{}).Error
+}
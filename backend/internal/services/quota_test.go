@@ -0,0 +1,67 @@
+package services
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/torbenconto/spooler/internal/models"
+	"github.com/torbenconto/spooler/internal/types"
+)
+
+func TestQuotaUsageLoadsMaterialsOnce(t *testing.T) {
+	db, fake := newFakeDB(t)
+	now := time.Now()
+	fake.OnQuery("count(*)", []string{"count"}, []driver.Value{int64(2)})
+	fake.OnQuery(`FROM "prints"`, []string{"id", "status", "requested_filament_material", "filament_weight", "filament_used", "created_at"},
+		[]driver.Value{int64(1), string(models.StatusPendingPrint), "PETG", 10.0, 0.0, now},
+		[]driver.Value{int64(2), string(models.StatusCompleted), "PLA", 20.0, 15.0, now},
+		[]driver.Value{int64(3), string(models.StatusApprovalPending), "petg", 5.0, 0.0, now.AddDate(0, 0, -10)},
+	)
+	fake.OnQuery(`FROM "materials"`, []string{"id", "name", "density"}, []driver.Value{int64(1), "PETG", 1.27})
+
+	quotaSvc := NewQuotaService(db, map[models.Role]types.Quota{models.RoleUser: {MaxOpenPrints: 3}})
+	status, err := quotaSvc.GetQuotaStatus(3, models.RoleUser)
+	if err != nil {
+		t.Fatalf("GetQuotaStatus: %v", err)
+	}
+
+	if queries := fake.Calls(`FROM "materials"`); len(queries) != 1 {
+		t.Errorf("queried materials %d times, want once", len(queries))
+	}
+	// Completed prints count with what was deducted, the rest with their estimate
+	want := QuotaUsage{OpenPrints: 2, GramsThisWeek: 25, GramsThisMonth: 30}
+	if status.Usage.OpenPrints != want.OpenPrints || math.Abs(status.Usage.GramsThisWeek-want.GramsThisWeek) > 0.01 ||
+		math.Abs(status.Usage.GramsThisMonth-want.GramsThisMonth) > 0.01 {
+		t.Errorf("usage = %+v, want %+v", status.Usage, want)
+	}
+}
+
+func TestCreatePrintRechecksOpenPrints(t *testing.T) {
+	db, fake := newFakeDB(t)
+	fake.OnQuery(`SELECT count(*) FROM "blobs"`, []string{"count"}, []driver.Value{int64(1)})
+	fake.OnQuery(`FROM "users"`, []string{"id"}, []driver.Value{int64(3)})
+	// A concurrent submission was created after the handler checked the quota
+	fake.OnQuery("count(*)", []string{"count"}, []driver.Value{int64(2)})
+
+	print := &models.Print{UserID: 3, StoredFileName: "abc.stl", FileHash: "abc"}
+	err := NewBlobService(db, newMemStorage()).CreatePrint(context.Background(), print, strings.NewReader("solid model"), 11, 2)
+	if !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("CreatePrint = %v, want %v", err, ErrQuotaExceeded)
+	}
+
+	locks := fake.Calls(`FROM "users"`)
+	if len(locks) != 1 || !strings.Contains(locks[0].Query, "FOR UPDATE") {
+		t.Errorf("user queries = %v, want the user row locked", locks)
+	}
+	if inserts := fake.Calls(`INSERT INTO "prints"`); len(inserts) != 0 {
+		t.Error("created a print over the open print limit")
+	}
+	if rollbacks := fake.Calls("ROLLBACK"); len(rollbacks) != 1 {
+		t.Error("the transaction was not rolled back")
+	}
+}
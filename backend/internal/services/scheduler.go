@@ -0,0 +1,217 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+
+	"github.com/torbenconto/spooler/internal/drivers"
+	"github.com/torbenconto/spooler/internal/models"
+	"github.com/torbenconto/spooler/internal/types"
+)
+
+var ErrNoPrinterProposed = errors.New("no printer is available for this print")
+
+// QueueEntry is a print waiting in the queue along with the printer the scheduler would run it on
+type QueueEntry struct {
+	Position int          `json:"position"`
+	Print    models.Print `json:"print"`
+
+	ProposedPrinterID   *uint  `json:"proposed_printer_id,omitempty"`
+	ProposedPrinterName string `json:"proposed_printer_name,omitempty"`
+	// Reason explains why no printer was proposed
+	Reason string `json:"reason,omitempty"`
+}
+
+// SchedulerService orders the pending_print queue and matches prints to idle printers
+type SchedulerService struct {
+	prints   *PrintService
+	printers *PrinterService
+	jobs     *PrintJobService
+
+	Mode types.SchedulerMode
+}
+
+func NewSchedulerService(printSvc *PrintService, printerSvc *PrinterService, jobSvc *PrintJobService, mode types.SchedulerMode) *SchedulerService {
+	return &SchedulerService{
+		prints:   printSvc,
+		printers: printerSvc,
+		jobs:     jobSvc,
+		Mode:     mode,
+	}
+}
+
+// fitsBuildVolume reports whether a model fits on a printer, allowing the model to be rotated on the bed.
+// Unknown model or printer dimensions are assumed to fit.
+func fitsBuildVolume(print *models.Print, printer *models.Printer) bool {
+	if print.SizeX == 0 || print.SizeY == 0 || print.SizeZ == 0 {
+		return true
+	}
+	if printer.BuildVolumeX == 0 || printer.BuildVolumeY == 0 || printer.BuildVolumeZ == 0 {
+		return true
+	}
+
+	if print.SizeZ > printer.BuildVolumeZ {
+		return false
+	}
+	return (print.SizeX <= printer.BuildVolumeX && print.SizeY <= printer.BuildVolumeY) ||
+		(print.SizeY <= printer.BuildVolumeX && print.SizeX <= printer.BuildVolumeY)
+}
+
+func filamentMatches(print *models.Print, printer *models.Printer) bool {
+	return strings.EqualFold(print.RequestedFilamentColor, printer.LoadedFilamentColor)
+}
+
+// Plan returns the queue in the order it will be printed. Outside of manual mode every print that can run right now is paired with an idle printer,
+// each printer being proposed at most once.
+func (s *SchedulerService) Plan() ([]QueueEntry, error) {
+	queue, err := s.prints.GetQueuedPrints()
+	if err != nil {
+		return nil, err
+	}
+
+	printers, err := s.printers.ListPrinters()
+	if err != nil {
+		return nil, err
+	}
+
+	busy, err := s.prints.BusyPrinterIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	var idle []models.Printer
+	for _, printer := range printers {
+		// Nobody would start a print dispatched to a printer without a driver, only a confirming officer can run one
+		if s.Mode == types.SchedulerAuto && printer.Driver == models.DriverNone {
+			continue
+		}
+		if printer.Online && !busy[printer.ID] {
+			idle = append(idle, printer)
+		}
+	}
+
+	claimed := make(map[uint]bool)
+	entries := make([]QueueEntry, 0, len(queue))
+	for i := range queue {
+		entry := QueueEntry{Position: i + 1, Print: queue[i]}
+		if s.Mode != types.SchedulerManual {
+			s.propose(&entry, idle, claimed)
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+func (s *SchedulerService) propose(entry *QueueEntry, idle []models.Printer, claimed map[uint]bool) {
+	switch entry.Print.SliceStatus {
+	case models.SliceRunning:
+		entry.Reason = "print is still being sliced"
+		return
+	case models.SliceFailed:
+		entry.Reason = "slicing failed"
+		return
+	}
+	if !drivers.Printable(entry.Print.JobFileName()) {
+		entry.Reason = "waiting for slicing"
+		return
+	}
+	if len(idle) == 0 {
+		entry.Reason = "no printer is idle"
+		return
+	}
+
+	runnable, colorMatched, fits := false, false, false
+	for i := range idle {
+		printer := &idle[i]
+		if !drivers.Accepts(printer.Driver, entry.Print.JobFileName()) {
+			continue
+		}
+		runnable = true
+		if !filamentMatches(&entry.Print, printer) {
+			continue
+		}
+		colorMatched = true
+		if !fitsBuildVolume(&entry.Print, printer) {
+			continue
+		}
+		fits = true
+		if claimed[printer.ID] {
+			continue
+		}
+
+		claimed[printer.ID] = true
+		entry.ProposedPrinterID = &printer.ID
+		entry.ProposedPrinterName = printer.Name
+		return
+	}
+
+	switch {
+	case !runnable:
+		entry.Reason = "no idle printer can run this file type"
+	case !colorMatched:
+		entry.Reason = "no idle printer has the requested filament loaded"
+	case !fits:
+		entry.Reason = "no matching idle printer can fit the model"
+	default:
+		entry.Reason = "matching printers are taken by prints ahead in the queue"
+	}
+}
+
+// Confirm starts a print on the printer the scheduler proposed for it
+func (s *SchedulerService) Confirm(ctx context.Context, printID uint, actorID uint) error {
+	entries, err := s.Plan()
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.Print.ID != printID {
+			continue
+		}
+		if entry.ProposedPrinterID == nil {
+			return ErrNoPrinterProposed
+		}
+		return s.jobs.UpdateStatus(ctx, printID, StatusChange{
+			To:        models.StatusPrinting,
+			Reason:    "confirmed scheduler proposal",
+			ActorID:   &actorID,
+			PrinterID: entry.ProposedPrinterID,
+		})
+	}
+
+	return ErrPrintNotFound
+}
+
+// Run dispatches every proposed print, it does nothing unless the scheduler is in auto mode
+func (s *SchedulerService) Run(ctx context.Context) error {
+	if s.Mode != types.SchedulerAuto {
+		return nil
+	}
+
+	entries, err := s.Plan()
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if entry.ProposedPrinterID == nil {
+			continue
+		}
+
+		if err := s.jobs.UpdateStatus(ctx, entry.Print.ID, StatusChange{
+			To:        models.StatusPrinting,
+			Reason:    "dispatched by scheduler",
+			PrinterID: entry.ProposedPrinterID,
+		}); err != nil {
+			log.Printf("scheduler failed to start print %d on printer %s: %v", entry.Print.ID, entry.ProposedPrinterName, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,45 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/torbenconto/spooler/internal/models"
+	"github.com/torbenconto/spooler/internal/types"
+)
+
+func TestProposeMatchesDriverFileTypes(t *testing.T) {
+	bambu := models.Printer{ID: 1, Name: "bambu", Driver: models.DriverBambu, LoadedFilamentColor: "#ffffff"}
+	octoprint := models.Printer{ID: 2, Name: "octoprint", Driver: models.DriverOctoPrint, LoadedFilamentColor: "#ffffff"}
+	manual := models.Printer{ID: 3, Name: "manual", Driver: models.DriverNone, LoadedFilamentColor: "#ffffff"}
+
+	tests := []struct {
+		name    string
+		print   models.Print
+		idle    []models.Printer
+		printer uint
+		reason  string
+	}{
+		{"project on bambu", models.Print{StoredFileName: "a.gcode.3mf"}, []models.Printer{octoprint, bambu}, 1, ""},
+		{"gcode on octoprint", models.Print{StoredFileName: "a.gcode"}, []models.Printer{bambu, octoprint}, 2, ""},
+		{"sliced model on octoprint", models.Print{StoredFileName: "a.stl", SlicedFileName: "a.4.gcode"}, []models.Printer{bambu, octoprint}, 2, ""},
+		{"gcode only idle on bambu", models.Print{StoredFileName: "a.gcode"}, []models.Printer{bambu}, 0, "no idle printer can run this file type"},
+		{"raw model", models.Print{StoredFileName: "a.stl"}, []models.Printer{bambu, octoprint}, 0, "waiting for slicing"},
+		{"raw model on a manual printer", models.Print{StoredFileName: "a.3mf"}, []models.Printer{manual}, 0, "waiting for slicing"},
+		{"gcode on a manual printer", models.Print{StoredFileName: "a.gcode"}, []models.Printer{manual}, 3, ""},
+	}
+
+	s := &SchedulerService{Mode: types.SchedulerAuto}
+	for _, tt := range tests {
+		tt.print.RequestedFilamentColor = "#FFFFFF"
+		entry := QueueEntry{Print: tt.print}
+		s.propose(&entry, tt.idle, map[uint]bool{})
+
+		var got uint
+		if entry.ProposedPrinterID != nil {
+			got = *entry.ProposedPrinterID
+		}
+		if got != tt.printer || entry.Reason != tt.reason {
+			t.Errorf("%s: proposed printer %d (%q), want %d (%q)", tt.name, got, entry.Reason, tt.printer, tt.reason)
+		}
+	}
+}
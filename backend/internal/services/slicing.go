@@ -0,0 +1,346 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/torbenconto/spooler/internal/gcode"
+	"github.com/torbenconto/spooler/internal/models"
+	"github.com/torbenconto/spooler/internal/slicer"
+	"github.com/torbenconto/spooler/internal/storage"
+	"github.com/torbenconto/spooler/internal/worker"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrSlicingDisabled  = errors.New("server side slicing is not configured")
+	ErrNotSliceable     = errors.New("only stl and 3mf models can be sliced")
+	ErrNoSlicerProfile  = errors.New("no slicer profile is configured for any printer")
+	ErrPrintSlicing     = errors.New("print is still being sliced")
+	ErrSliceNotQueued   = errors.New("only prints that have not started printing can be sliced")
+	ErrSliceInterrupted = errors.New("slicing was interrupted by a server restart")
+)
+
+// SlicingService slices raw models on the server once they are approved, storing the G-code next to the model
+type SlicingService struct {
+	db            *gorm.DB
+	prints        *PrintService
+	printers      *PrinterService
+	storageClient storage.StorageClient
+	slicer        *slicer.Slicer
+
+	// DefaultProfile is used when no printer has a slicer profile of its own
+	DefaultProfile string
+
+	// Background runs the slicer for SliceInBackground, main hands it the worker supervisor so runs are awaited on shutdown
+	Background func(name string, task worker.Task)
+
+	// sem limits how many slicer processes run at once
+	sem chan struct{}
+}
+
+// NewSlicingService returns a service that slices with s, a nil slicer disables slicing
+func NewSlicingService(db *gorm.DB, printSvc *PrintService, printerSvc *PrinterService, storageClient storage.StorageClient, s *slicer.Slicer, concurrency int) *SlicingService {
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	return &SlicingService{
+		db:            db,
+		prints:        printSvc,
+		printers:      printerSvc,
+		storageClient: storageClient,
+		slicer:        s,
+		Background:    runDetached,
+		sem:           make(chan struct{}, concurrency),
+	}
+}
+
+func (s *SlicingService) Enabled() bool {
+	return s != nil && s.slicer != nil
+}
+
+// sliceExtension returns the extension of a raw model that can be sliced, or an empty string for anything else
+func sliceExtension(print *models.Print) string {
+	name := strings.ToLower(print.StoredFileName)
+	switch {
+	case strings.HasSuffix(name, ".gcode.3mf"):
+		return ""
+	case strings.HasSuffix(name, ".stl"):
+		return ".stl"
+	case strings.HasSuffix(name, ".3mf"):
+		return ".3mf"
+	default:
+		return ""
+	}
+}
+
+// CanSlice reports why a print cannot be sliced right now, nil when it can
+func (s *SlicingService) CanSlice(print *models.Print) error {
+	switch {
+	case !s.Enabled():
+		return ErrSlicingDisabled
+	case sliceExtension(print) == "":
+		return ErrNotSliceable
+	case print.Status != models.StatusApprovalPending && print.Status != models.StatusPendingPrint:
+		return ErrSliceNotQueued
+	case print.SliceStatus == models.SliceRunning:
+		return ErrPrintSlicing
+	default:
+		return nil
+	}
+}
+
+// NeedsSlicing reports whether a print is a raw model that has to be sliced before it can be printed
+func (s *SlicingService) NeedsSlicing(print *models.Print) bool {
+	return s.Enabled() && sliceExtension(print) != "" && print.SlicedFileName == ""
+}
+
+// profileFor picks the profile of the printer the print is most likely to run on. That is its assigned printer,
+// otherwise the first printer with a profile that has the requested filament loaded and fits the model, otherwise any printer with a profile.
+func (s *SlicingService) profileFor(print *models.Print) (*uint, string, error) {
+	if print.PrinterID != nil {
+		printer, err := s.printers.GetPrinterByID(*print.PrinterID)
+		if err != nil && !errors.Is(err, ErrPrinterNotFound) {
+			return nil, "", err
+		}
+		if printer != nil && printer.SlicerProfile != "" {
+			return &printer.ID, printer.SlicerProfile, nil
+		}
+	}
+
+	printers, err := s.printers.ListPrinters()
+	if err != nil {
+		return nil, "", err
+	}
+
+	var fallback *models.Printer
+	for i := range printers {
+		printer := &printers[i]
+		if printer.SlicerProfile == "" {
+			continue
+		}
+		if filamentMatches(print, printer) && fitsBuildVolume(print, printer) {
+			return &printer.ID, printer.SlicerProfile, nil
+		}
+		if fallback == nil {
+			fallback = printer
+		}
+	}
+
+	if fallback != nil {
+		return &fallback.ID, fallback.SlicerProfile, nil
+	}
+	if s.DefaultProfile != "" {
+		return nil, s.DefaultProfile, nil
+	}
+	return nil, "", ErrNoSlicerProfile
+}
+
+// SliceInBackground claims a print for slicing and slices it without blocking the caller, failures are recorded on the
+// print and its slice jobs
+func (s *SlicingService) SliceInBackground(printID uint) error {
+	print, err := s.claim(printID)
+	if err != nil {
+		return err
+	}
+
+	s.Background(fmt.Sprintf("slicing of print %d", printID), func(ctx context.Context) error {
+		return s.slice(ctx, print)
+	})
+	return nil
+}
+
+// Slice runs the slicer on a print's model, stores the produced G-code and records the run as a SliceJob
+func (s *SlicingService) Slice(ctx context.Context, printID uint) error {
+	print, err := s.claim(printID)
+	if err != nil {
+		return err
+	}
+	return s.slice(ctx, print)
+}
+
+// claim marks a print as slicing. The update only matches a print that is not being sliced yet, so of two concurrent
+// requests only one gets to run the slicer.
+func (s *SlicingService) claim(printID uint) (*models.Print, error) {
+	if !s.Enabled() {
+		return nil, ErrSlicingDisabled
+	}
+
+	print, err := s.prints.GetPrintByID(printID)
+	if err != nil {
+		return nil, ErrPrintNotFound
+	}
+	if err := s.CanSlice(print); err != nil {
+		return nil, err
+	}
+
+	result := s.db.Model(&models.Print{}).
+		Where("id = ? AND slice_status <> ? AND status IN ?", printID, models.SliceRunning, []models.PrintStatus{models.StatusApprovalPending, models.StatusPendingPrint}).
+		Update("slice_status", models.SliceRunning)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrPrintSlicing
+	}
+
+	print.SliceStatus = models.SliceRunning
+	return print, nil
+}
+
+func (s *SlicingService) slice(ctx context.Context, print *models.Print) error {
+	extension := sliceExtension(print)
+
+	select {
+	case s.sem <- struct{}{}:
+		defer func() { <-s.sem }()
+	case <-ctx.Done():
+		return s.fail(print.ID, &models.SliceJob{PrintID: print.ID, Status: models.SliceRunning}, ctx.Err(), "")
+	}
+
+	printerID, profile, err := s.profileFor(print)
+	if err != nil {
+		return s.fail(print.ID, &models.SliceJob{PrintID: print.ID, Status: models.SliceRunning}, err, "")
+	}
+
+	job := &models.SliceJob{
+		PrintID:   print.ID,
+		PrinterID: printerID,
+		Profile:   profile,
+		Status:    models.SliceRunning,
+	}
+	if err := s.db.Create(job).Error; err != nil {
+		return s.fail(print.ID, job, err, "")
+	}
+
+	model, err := s.storageClient.GetFile(ctx, print.StoredFileName)
+	if err != nil {
+		return s.fail(print.ID, job, fmt.Errorf("failed to open stored file: %w", err), "")
+	}
+	result, err := s.slicer.Slice(ctx, model, extension, profile)
+	model.Close()
+	if err != nil {
+		var sliceErr *slicer.Error
+		if errors.As(err, &sliceErr) {
+			return s.fail(print.ID, job, err, sliceErr.Log)
+		}
+		return s.fail(print.ID, job, err, "")
+	}
+	defer result.Close()
+
+	updates, err := s.storeGCode(ctx, print, result.GCode)
+	if err != nil {
+		return s.fail(print.ID, job, err, result.Log)
+	}
+
+	fileName := updates["sliced_file_name"].(string)
+	if err := s.db.Model(job).Updates(map[string]any{
+		"status":           models.SliceSucceeded,
+		"stored_file_name": fileName,
+		"output":           result.Log,
+	}).Error; err != nil {
+		s.discardGCode(print, fileName)
+		return s.fail(print.ID, job, err, result.Log)
+	}
+
+	if err := s.prints.UpdatePrint(print.ID, updates); err != nil {
+		s.discardGCode(print, fileName)
+		return s.fail(print.ID, job, err, result.Log)
+	}
+	if err := s.prints.RefreshEstimatedCost(print.ID); err != nil {
+		log.Printf("failed to estimate cost of print %d: %v", print.ID, err)
+	}
+	return nil
+}
+
+// storeGCode analyzes the sliced G-code and stores it, returning the print fields to update
+func (s *SlicingService) storeGCode(ctx context.Context, print *models.Print, path string) (map[string]any, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	stats, err := gcode.Analyze(f)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	// Prints of the same file share their model, but each is sliced for its own printer
+	fileName := fmt.Sprintf("%s.%d.gcode", strings.TrimSuffix(print.StoredFileName, sliceExtension(print)), print.ID)
+	if err := s.storageClient.StoreFile(ctx, fileName, f); err != nil {
+		return nil, fmt.Errorf("failed to store gcode: %w", err)
+	}
+
+	return map[string]any{
+		"slice_status":            models.SliceSucceeded,
+		"sliced_file_name":        fileName,
+		"slicer":                  stats.Slicer,
+		"printer_profile":         stats.PrinterProfile,
+		"estimated_print_seconds": int(stats.EstimatedTime.Seconds()),
+		"filament_length":         stats.FilamentLength,
+		"filament_weight":         stats.FilamentWeight,
+		"layer_height":            stats.LayerHeight,
+		"layer_count":             stats.LayerCount,
+		"nozzle_temperature":      stats.NozzleTemp,
+		"bed_temperature":         stats.BedTemp,
+	}, nil
+}
+
+// discardGCode deletes G-code that was stored for a run that could not be recorded, unless the print still uses the file
+// of an earlier run under the same name
+func (s *SlicingService) discardGCode(print *models.Print, fileName string) {
+	if print.SlicedFileName == fileName {
+		return
+	}
+	if err := s.storageClient.DeleteFile(context.Background(), fileName); err != nil && !storage.IsNotExist(err) {
+		log.Printf("failed to delete G-code %s of print %d: %v", fileName, print.ID, err)
+	}
+}
+
+func (s *SlicingService) fail(printID uint, job *models.SliceJob, cause error, output string) error {
+	job.Status = models.SliceFailed
+	job.Error = cause.Error()
+	job.Output = output
+	if err := s.db.Save(job).Error; err != nil {
+		log.Printf("failed to record slice job for print %d: %v", printID, err)
+	}
+
+	if err := s.prints.UpdatePrint(printID, map[string]any{"slice_status": models.SliceFailed}); err != nil {
+		log.Printf("failed to mark print %d as failed to slice: %v", printID, err)
+	}
+
+	return cause
+}
+
+// FailInterrupted marks slicer runs left unfinished by an earlier server process as failed. Nothing finishes them after
+// a restart, and prints marked as slicing cannot be started, scheduled or sliced again.
+func (s *SlicingService) FailInterrupted() error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.SliceJob{}).Where("status = ?", models.SliceRunning).Updates(map[string]any{
+			"status": models.SliceFailed,
+			"error":  ErrSliceInterrupted.Error(),
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.Print{}).Where("slice_status = ?", models.SliceRunning).Update("slice_status", models.SliceFailed).Error
+	})
+}
+
+// GetSliceJobs returns every slicer run of a print, newest first
+func (s *SlicingService) GetSliceJobs(printID uint) ([]models.SliceJob, error) {
+	var jobs []models.SliceJob
+	if err := s.db.Where("print_id = ?", printID).Order("created_at desc, id desc").Find(&jobs).Error; err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
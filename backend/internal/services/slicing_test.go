@@ -0,0 +1,156 @@
+package services
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/torbenconto/spooler/internal/models"
+	"github.com/torbenconto/spooler/internal/slicer"
+	"github.com/torbenconto/spooler/internal/storage"
+	"github.com/torbenconto/spooler/internal/types"
+)
+
+// fakeSlicerScript stands in for PrusaSlicer, writing fixed G-code to the --output path
+const fakeSlicerScript = `#!/bin/sh
+while [ $# -gt 0 ]; do
+	[ "$1" = "--output" ] && out="$2"
+	shift
+done
+printf '; generated by FakeSlicer 1.0\nG28\nG1 X10 Y10 E5\n' > "$out"
+`
+
+func newTestSlicingService(t *testing.T) (*SlicingService, *fakeSQL, *storage.LocalStorageClient) {
+	t.Helper()
+	db, fake := newFakeDB(t)
+	fake.OnQuery(`FROM "prints"`, []string{"id", "status", "stored_file_name", "slice_status"},
+		[]driver.Value{int64(7), string(models.StatusPendingPrint), "abc.stl", ""})
+
+	dir := t.TempDir()
+	command := filepath.Join(dir, "fake-slicer")
+	if err := os.WriteFile(command, []byte(fakeSlicerScript), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	s, err := slicer.New(types.SlicerPrusaSlicer, command, 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	storageClient, err := storage.NewLocalStorageClient(filepath.Join(dir, "storage"), "secret", "http://localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storageClient.StoreFile(context.Background(), "abc.stl", strings.NewReader("solid model")); err != nil {
+		t.Fatal(err)
+	}
+
+	slicingSvc := NewSlicingService(db, NewPrintService(db), NewPrinterService(db), storageClient, s, 1)
+	slicingSvc.DefaultProfile = "profile.ini"
+	return slicingSvc, fake, storageClient
+}
+
+func TestSliceStoresGCode(t *testing.T) {
+	slicingSvc, fake, storageClient := newTestSlicingService(t)
+
+	if err := slicingSvc.Slice(context.Background(), 7); err != nil {
+		t.Fatalf("Slice: %v", err)
+	}
+
+	claims := fake.Calls("slice_status <>")
+	if len(claims) != 1 {
+		t.Fatalf("claimed the print %d times, want once", len(claims))
+	}
+	if got, _ := claims[0].Arg("slice_status"); got != string(models.SliceRunning) {
+		t.Errorf("claim sets slice_status = %v, want %s", got, models.SliceRunning)
+	}
+
+	file, err := storageClient.GetFile(context.Background(), "abc.7.gcode")
+	if err != nil {
+		t.Fatalf("sliced G-code was not stored: %v", err)
+	}
+	file.Close()
+
+	var recorded bool
+	for _, call := range fake.Calls(`UPDATE "prints"`) {
+		if name, _ := call.Arg("sliced_file_name"); name == "abc.7.gcode" {
+			recorded = true
+			if status, _ := call.Arg("slice_status"); status != string(models.SliceSucceeded) {
+				t.Errorf("slice_status = %v, want %s", status, models.SliceSucceeded)
+			}
+			if slicerName, _ := call.Arg("slicer"); slicerName != "FakeSlicer 1.0" {
+				t.Errorf("slicer = %v, want the one named in the G-code", slicerName)
+			}
+		}
+	}
+	if !recorded {
+		t.Error("the sliced file was not recorded on the print")
+	}
+}
+
+func TestSliceInBackgroundLosesClaim(t *testing.T) {
+	slicingSvc, fake, _ := newTestSlicingService(t)
+	// Another request marked the print as slicing after it was read
+	fake.OnExec("slice_status <>", 0)
+
+	if err := slicingSvc.SliceInBackground(7); !errors.Is(err, ErrPrintSlicing) {
+		t.Fatalf("SliceInBackground = %v, want %v", err, ErrPrintSlicing)
+	}
+	if jobs := fake.Calls(`INSERT INTO "slice_jobs"`); len(jobs) != 0 {
+		t.Errorf("started %d slice jobs for a print that is already being sliced", len(jobs))
+	}
+}
+
+func TestSliceFailsWhenResultCannotBeRecorded(t *testing.T) {
+	for _, match := range []string{`UPDATE "slice_jobs"`, `"sliced_file_name"=`} {
+		t.Run(match, func(t *testing.T) {
+			slicingSvc, fake, storageClient := newTestSlicingService(t)
+			fake.OnExecError(match, errors.New("connection reset"))
+
+			if err := slicingSvc.Slice(context.Background(), 7); err == nil {
+				t.Fatal("Slice succeeded although its result could not be recorded")
+			}
+
+			var failed bool
+			for _, call := range fake.Calls(`UPDATE "prints"`) {
+				if status, _ := call.Arg("slice_status"); status == string(models.SliceFailed) {
+					failed = true
+				}
+			}
+			if !failed {
+				t.Error("the print was not marked as failed to slice")
+			}
+			if _, err := storageClient.Stat(context.Background(), "abc.7.gcode"); !storage.IsNotExist(err) {
+				t.Errorf("the stored G-code was left behind: %v", err)
+			}
+		})
+	}
+}
+
+func TestFailInterrupted(t *testing.T) {
+	slicingSvc, fake, _ := newTestSlicingService(t)
+
+	if err := slicingSvc.FailInterrupted(); err != nil {
+		t.Fatalf("FailInterrupted: %v", err)
+	}
+
+	for _, table := range []string{`UPDATE "slice_jobs"`, `UPDATE "prints"`} {
+		calls := fake.Calls(table)
+		if len(calls) != 1 {
+			t.Fatalf("ran %d %s statements, want 1", len(calls), table)
+		}
+		if !strings.Contains(calls[0].Query, "WHERE") || calls[0].Args[len(calls[0].Args)-1] != string(models.SliceRunning) {
+			t.Errorf("%s only has to touch runs that are slicing: %s %v", table, calls[0].Query, calls[0].Args)
+		}
+	}
+	if got, _ := fake.Calls(`UPDATE "prints"`)[0].Arg("slice_status"); got != string(models.SliceFailed) {
+		t.Errorf("slice_status = %v, want %s", got, models.SliceFailed)
+	}
+	if got, _ := fake.Calls(`UPDATE "slice_jobs"`)[0].Arg("error"); got != ErrSliceInterrupted.Error() {
+		t.Errorf("slice job error = %v, want %q", got, ErrSliceInterrupted)
+	}
+}
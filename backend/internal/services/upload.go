@@ -0,0 +1,235 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/torbenconto/spooler/internal/models"
+	"github.com/torbenconto/spooler/internal/storage"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrUploadNotFound   = errors.New("upload not found")
+	ErrUploadOffset     = errors.New("chunk does not start at the upload offset")
+	ErrUploadChunk      = errors.New("chunk is empty or larger than allowed")
+	ErrUploadIncomplete = errors.New("upload is not complete")
+	ErrUploadChecksum   = errors.New("uploaded file does not match its checksum")
+)
+
+// UploadService stages resumable uploads: the file is sent in chunks that are stored as objects of their own, a chunk
+// that fails is sent again from the last offset the server confirmed
+type UploadService struct {
+	db            *gorm.DB
+	storageClient storage.StorageClient
+
+	// Expiry is how long after its last chunk an upload is kept
+	Expiry time.Duration
+	// MaxChunkSize caps the size of a single chunk, 0 is unlimited
+	MaxChunkSize int64
+}
+
+func NewUploadService(db *gorm.DB, storageClient storage.StorageClient) *UploadService {
+	return &UploadService{db: db, storageClient: storageClient, Expiry: 24 * time.Hour}
+}
+
+// chunkObjectPath is the stored name of the chunk of an upload starting at offset
+func chunkObjectPath(uploadID string, offset int64) string {
+	return fmt.Sprintf("upload-%s-%d.part", uploadID, offset)
+}
+
+// CreateUpload starts an upload, the ID and expiry are set on it
+func (s *UploadService) CreateUpload(upload *models.Upload) error {
+	upload.ID = uuid.New().String()
+	upload.Offset = 0
+	upload.ExpiresAt = time.Now().Add(s.Expiry)
+	return s.db.Create(upload).Error
+}
+
+// GetUpload returns an upload of a user that has not expired
+func (s *UploadService) GetUpload(id string, userID uint) (*models.Upload, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrUploadNotFound
+	}
+
+	var upload models.Upload
+	err := s.db.Where("id = ? AND user_id = ? AND expires_at > ?", id, userID, time.Now()).First(&upload).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrUploadNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &upload, nil
+}
+
+// AppendChunk stores the chunk of an upload starting at offset, which must be the offset of the upload. The upload row
+// stays locked while the chunk is stored, so a chunk sent twice at once is only appended once.
+func (s *UploadService) AppendChunk(ctx context.Context, id string, userID uint, offset int64, chunk io.Reader) (*models.Upload, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrUploadNotFound
+	}
+
+	var upload models.Upload
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ? AND user_id = ? AND expires_at > ?", id, userID, time.Now()).First(&upload).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrUploadNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if offset != upload.Offset {
+			return ErrUploadOffset
+		}
+
+		limit := upload.FileSize - upload.Offset
+		if s.MaxChunkSize > 0 {
+			limit = min(limit, s.MaxChunkSize)
+		}
+
+		// One byte past the limit is read to tell a chunk that is too large from one that fits exactly
+		objectPath := chunkObjectPath(upload.ID, offset)
+		counter := &countingReader{r: io.LimitReader(chunk, limit+1)}
+		if err := s.storageClient.StoreFile(ctx, objectPath, counter); err != nil {
+			return fmt.Errorf("failed to store chunk: %w", err)
+		}
+		if counter.n == 0 || counter.n > limit {
+			s.deleteObject(objectPath)
+			return ErrUploadChunk
+		}
+
+		if err := tx.Create(&models.UploadChunk{
+			UploadID:   upload.ID,
+			Offset:     offset,
+			Size:       counter.n,
+			ObjectPath: objectPath,
+		}).Error; err != nil {
+			return err
+		}
+
+		upload.Offset += counter.n
+		upload.ExpiresAt = time.Now().Add(s.Expiry)
+		return tx.Model(&upload).Updates(map[string]any{"offset": upload.Offset, "expires_at": upload.ExpiresAt}).Error
+	})
+	if err != nil {
+		if errors.Is(err, ErrUploadOffset) {
+			return &upload, err
+		}
+		return nil, err
+	}
+	return &upload, nil
+}
+
+// Assemble writes the chunks of a complete upload to w in order and verifies the result against the declared checksum
+func (s *UploadService) Assemble(ctx context.Context, upload *models.Upload, w io.Writer) error {
+	if upload.Offset != upload.FileSize {
+		return ErrUploadIncomplete
+	}
+
+	var chunks []models.UploadChunk
+	if err := s.db.Where("upload_id = ?", upload.ID).Order("\"offset\"").Find(&chunks).Error; err != nil {
+		return err
+	}
+
+	hash := sha256.New()
+	var written int64
+	for _, chunk := range chunks {
+		if chunk.Offset != written {
+			return fmt.Errorf("chunk at %d is missing", written)
+		}
+
+		reader, err := s.storageClient.GetFile(ctx, chunk.ObjectPath)
+		if err != nil {
+			return fmt.Errorf("failed to read chunk at %d: %w", chunk.Offset, err)
+		}
+		n, err := io.Copy(io.MultiWriter(w, hash), reader)
+		reader.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read chunk at %d: %w", chunk.Offset, err)
+		}
+		if n != chunk.Size {
+			return fmt.Errorf("%w: chunk at %d is %d bytes, %d were received", ErrUploadChecksum, chunk.Offset, n, chunk.Size)
+		}
+		written += n
+	}
+
+	if written != upload.FileSize {
+		return ErrUploadIncomplete
+	}
+	if hex.EncodeToString(hash.Sum(nil)) != upload.SHA256 {
+		return ErrUploadChecksum
+	}
+	return nil
+}
+
+// DeleteUpload deletes an upload along with its stored chunks
+func (s *UploadService) DeleteUpload(ctx context.Context, upload *models.Upload) error {
+	var chunks []models.UploadChunk
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("upload_id = ?", upload.ID).Find(&chunks).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("upload_id = ?", upload.ID).Delete(&models.UploadChunk{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&models.Upload{}, "id = ?", upload.ID).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, chunk := range chunks {
+		s.deleteObject(chunk.ObjectPath)
+	}
+	return nil
+}
+
+// ExpireUploads deletes uploads that received no chunk within Expiry
+func (s *UploadService) ExpireUploads(ctx context.Context) error {
+	var expired []models.Upload
+	if err := s.db.Where("expires_at <= ?", time.Now()).Find(&expired).Error; err != nil {
+		return err
+	}
+
+	for i := range expired {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := s.DeleteUpload(ctx, &expired[i]); err != nil {
+			return fmt.Errorf("failed to delete upload %s: %w", expired[i].ID, err)
+		}
+	}
+	if len(expired) > 0 {
+		log.Printf("deleted %d expired uploads", len(expired))
+	}
+	return nil
+}
+
+// deleteObject removes a chunk, one that cannot be deleted is left to BlobService.Collect
+func (s *UploadService) deleteObject(objectPath string) {
+	if err := s.storageClient.DeleteFile(context.Background(), objectPath); err != nil && !storage.IsNotExist(err) {
+		log.Printf("failed to delete chunk %s: %v", objectPath, err)
+	}
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
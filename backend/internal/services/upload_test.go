@@ -0,0 +1,133 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql/driver"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/torbenconto/spooler/internal/models"
+)
+
+const testUploadID = "5f0c7a52-3a8e-4c43-9d55-3f0f5b1d6a10"
+
+func TestAppendChunk(t *testing.T) {
+	// The upload is 10 bytes long and has received the first 4
+	tests := []struct {
+		name     string
+		offset   int64
+		chunk    string
+		maxChunk int64
+		want     error
+	}{
+		{"chunk at the offset", 4, "abcdef", 0, nil},
+		{"chunk filling MaxChunkSize", 4, "abcdef", 6, nil},
+		{"chunk before the offset", 0, "abcdef", 0, ErrUploadOffset},
+		{"chunk after the offset", 6, "abcd", 0, ErrUploadOffset},
+		{"chunk past the file size", 4, "abcdefg", 0, ErrUploadChunk},
+		{"chunk larger than MaxChunkSize", 4, "abcdef", 4, ErrUploadChunk},
+		{"empty chunk", 4, "", 0, ErrUploadChunk},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, fake := newFakeDB(t)
+			fake.OnQuery(`FROM "uploads"`, []string{"id", "user_id", "file_size", "offset"},
+				[]driver.Value{testUploadID, int64(3), int64(10), int64(4)})
+			storageClient := newMemStorage()
+			svc := NewUploadService(db, storageClient)
+			svc.MaxChunkSize = tt.maxChunk
+
+			upload, err := svc.AppendChunk(context.Background(), testUploadID, 3, tt.offset, strings.NewReader(tt.chunk))
+			if err != tt.want {
+				t.Fatalf("AppendChunk = %v, want %v", err, tt.want)
+			}
+
+			objectPath := chunkObjectPath(testUploadID, tt.offset)
+			_, stored := storageClient.file(objectPath)
+			chunks := fake.Calls(`INSERT INTO "upload_chunks"`)
+			switch tt.want {
+			case nil:
+				if !stored || len(chunks) != 1 {
+					t.Fatalf("stored the chunk = %v with %d rows, want it stored once", stored, len(chunks))
+				}
+				if size, _ := chunks[0].Arg("size"); size != int64(len(tt.chunk)) {
+					t.Errorf("chunk size = %v, want %d", size, len(tt.chunk))
+				}
+				if upload.Offset != 10 {
+					t.Errorf("Offset = %d, want 10", upload.Offset)
+				}
+			case ErrUploadOffset:
+				// The client resumes from the offset the upload is at
+				if upload == nil || upload.Offset != 4 {
+					t.Errorf("upload = %+v, want the upload at offset 4", upload)
+				}
+				fallthrough
+			default:
+				if stored || len(chunks) != 0 {
+					t.Errorf("kept the chunk = %v with %d rows, want it discarded", stored, len(chunks))
+				}
+				if updates := fake.Calls(`UPDATE "uploads"`); len(updates) != 0 {
+					t.Errorf("moved the upload offset %d times", len(updates))
+				}
+			}
+		})
+	}
+}
+
+func TestAssemble(t *testing.T) {
+	content := "hello world"
+	sum := sha256.Sum256([]byte(content))
+	checksum := hex.EncodeToString(sum[:])
+
+	tests := []struct {
+		name   string
+		sha256 string
+		offset int64
+		// chunks are the offsets and sizes recorded for the stored "hello " and "world" chunks
+		chunks [][2]int64
+		// want is part of the expected error, empty when the upload assembles
+		want string
+	}{
+		{"complete upload", checksum, 11, [][2]int64{{0, 6}, {6, 5}}, ""},
+		{"wrong checksum", strings.Repeat("0", 64), 11, [][2]int64{{0, 6}, {6, 5}}, ErrUploadChecksum.Error()},
+		{"incomplete upload", checksum, 6, [][2]int64{{0, 6}}, ErrUploadIncomplete.Error()},
+		{"missing chunk", checksum, 11, [][2]int64{{6, 5}}, "chunk at 0 is missing"},
+		{"chunk shorter than recorded", checksum, 11, [][2]int64{{0, 7}, {6, 5}}, ErrUploadChecksum.Error()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, fake := newFakeDB(t)
+			storageClient := newMemStorage()
+			storageClient.files[chunkObjectPath(testUploadID, 0)] = []byte("hello ")
+			storageClient.files[chunkObjectPath(testUploadID, 6)] = []byte("world")
+
+			rows := make([][]driver.Value, len(tt.chunks))
+			for i, chunk := range tt.chunks {
+				rows[i] = []driver.Value{testUploadID, chunk[0], chunk[1], chunkObjectPath(testUploadID, chunk[0])}
+			}
+			fake.OnQuery(`FROM "upload_chunks"`, []string{"upload_id", "offset", "size", "object_path"}, rows...)
+
+			upload := &models.Upload{ID: testUploadID, FileSize: 11, Offset: tt.offset, SHA256: tt.sha256}
+			var out bytes.Buffer
+			err := NewUploadService(db, storageClient).Assemble(context.Background(), upload, &out)
+
+			if tt.want == "" {
+				if err != nil {
+					t.Fatalf("Assemble: %v", err)
+				}
+				if out.String() != content {
+					t.Errorf("assembled %q, want %q", out.String(), content)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("Assemble = %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,206 @@
+package slicer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/torbenconto/spooler/internal/types"
+)
+
+var ErrNoOutput = errors.New("slicer produced no gcode")
+
+const (
+	// Only the end of the slicer output is kept, that is where errors are printed
+	maxLogSize = 64 * 1024
+	waitDelay  = 5 * time.Second
+)
+
+// Error is returned when a slicer run does not produce G-code, Log holds what the slicer printed
+type Error struct {
+	Err error
+	Log string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("slicing failed: %v", e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Slicer runs a slicer CLI on a model. Every run gets its own temporary directory which doubles as the slicer's home directory,
+// so slicers cannot pick up or leave behind configuration outside of it.
+type Slicer struct {
+	Kind    types.SlicerKind
+	Command string
+	Timeout time.Duration
+}
+
+func New(kind types.SlicerKind, command string, timeout time.Duration) (*Slicer, error) {
+	if kind == types.SlicerNone || !kind.IsValid() {
+		return nil, fmt.Errorf("invalid slicer kind: %q", kind)
+	}
+	if command == "" {
+		return nil, errors.New("slicer command is empty")
+	}
+
+	path, err := exec.LookPath(command)
+	if err != nil {
+		return nil, fmt.Errorf("slicer command not found: %w", err)
+	}
+
+	return &Slicer{Kind: kind, Command: path, Timeout: timeout}, nil
+}
+
+// Result is the output of a successful run. GCode points into the run's temporary directory which is removed by Close.
+type Result struct {
+	GCode string
+	Log   string
+
+	dir string
+}
+
+func (r *Result) Close() error {
+	return os.RemoveAll(r.dir)
+}
+
+func (s *Slicer) args(model string, profile string, outputDir string) []string {
+	switch s.Kind {
+	case types.SlicerOrcaSlicer:
+		return []string{"--slice", "0", "--load-settings", profile, "--outputdir", outputDir, model}
+	case types.SlicerCuraEngine:
+		return []string{"slice", "-j", profile, "-l", model, "-o", filepath.Join(outputDir, "model.gcode")}
+	default:
+		return []string{"--export-gcode", "--load", profile, "--output", filepath.Join(outputDir, "model.gcode"), model}
+	}
+}
+
+// Slice writes the model to a fresh temporary directory and runs the slicer on it with the given profile.
+// extension is the model's file extension, slicers pick the file format from it.
+func (s *Slicer) Slice(ctx context.Context, model io.Reader, extension string, profile string) (*Result, error) {
+	dir, err := os.MkdirTemp("", "spooler-slice-*")
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := s.run(ctx, dir, model, extension, profile)
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		return nil, err
+	}
+	return result, nil
+}
+
+func (s *Slicer) run(ctx context.Context, dir string, model io.Reader, extension string, profile string) (*Result, error) {
+	modelPath := filepath.Join(dir, "model"+extension)
+	outputDir := filepath.Join(dir, "output")
+	if err := os.Mkdir(outputDir, 0o700); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(modelPath, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(f, model); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+
+	if s.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.Timeout)
+		defer cancel()
+	}
+
+	output := &tailBuffer{limit: maxLogSize}
+	cmd := exec.CommandContext(ctx, s.Command, s.args(modelPath, profile, outputDir)...)
+	cmd.Dir = dir
+	cmd.Env = []string{
+		"HOME=" + dir,
+		"TMPDIR=" + dir,
+		"PATH=" + os.Getenv("PATH"),
+		"LANG=C.UTF-8",
+	}
+	cmd.Stdout = output
+	cmd.Stderr = output
+	cmd.WaitDelay = waitDelay
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return nil, &Error{Err: err, Log: output.String()}
+	}
+
+	gcode, err := findGCode(outputDir)
+	if err != nil {
+		return nil, &Error{Err: err, Log: output.String()}
+	}
+
+	return &Result{GCode: gcode, Log: output.String(), dir: dir}, nil
+}
+
+// findGCode returns the first G-code file in dir, slicers name their output differently
+func findGCode(dir string) (string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && strings.EqualFold(filepath.Ext(entry.Name()), ".gcode") {
+			names = append(names, entry.Name())
+		}
+	}
+	if len(names) == 0 {
+		return "", ErrNoOutput
+	}
+
+	sort.Strings(names)
+	return filepath.Join(dir, names[0]), nil
+}
+
+// tailBuffer keeps the last limit bytes written to it
+type tailBuffer struct {
+	mu        sync.Mutex
+	limit     int
+	buf       []byte
+	truncated bool
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.buf = append(b.buf, p...)
+	if len(b.buf) > b.limit {
+		b.buf = append(b.buf[:0], b.buf[len(b.buf)-b.limit:]...)
+		b.truncated = true
+	}
+	return len(p), nil
+}
+
+func (b *tailBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.truncated {
+		return "[output truncated]\n" + string(b.buf)
+	}
+	return string(b.buf)
+}
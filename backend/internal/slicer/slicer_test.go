@@ -0,0 +1,124 @@
+package slicer
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/torbenconto/spooler/internal/types"
+)
+
+// fakeSlicerScript understands the output arguments of every supported slicer and writes fixed G-code there.
+// A mode file next to the script switches it to failing, writing no G-code or hanging.
+const fakeSlicerScript = `#!/bin/sh
+mode=$(cat "$(dirname "$0")/mode" 2>/dev/null)
+out=""
+while [ $# -gt 0 ]; do
+	case "$1" in
+		--output|-o) out="$2"; shift ;;
+		--outputdir) out="$2/plate_1.gcode"; shift ;;
+	esac
+	shift
+done
+echo "home=$HOME"
+case "$mode" in
+	fail) echo "error: model is not manifold"; exit 1 ;;
+	empty) exit 0 ;;
+	hang) exec sleep 5 ;;
+esac
+printf 'G28\nG1 X10 Y10 E5\n' > "$out"
+`
+
+func newFakeSlicer(t *testing.T, kind types.SlicerKind, mode string) *Slicer {
+	t.Helper()
+	dir := t.TempDir()
+	command := filepath.Join(dir, "fake-slicer")
+	if err := os.WriteFile(command, []byte(fakeSlicerScript), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "mode"), []byte(mode), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := New(kind, command, 10*time.Second)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return s
+}
+
+func TestSlice(t *testing.T) {
+	for _, kind := range []types.SlicerKind{types.SlicerPrusaSlicer, types.SlicerOrcaSlicer, types.SlicerCuraEngine} {
+		s := newFakeSlicer(t, kind, "")
+
+		result, err := s.Slice(context.Background(), strings.NewReader("solid model"), ".stl", "profile.ini")
+		if err != nil {
+			t.Fatalf("%s: Slice: %v", kind, err)
+		}
+		gcode, err := os.ReadFile(result.GCode)
+		if err != nil || !strings.HasPrefix(string(gcode), "G28") {
+			t.Errorf("%s: G-code = %q, %v", kind, gcode, err)
+		}
+		// The run's directory is the slicer's home, Close removes it
+		if !strings.Contains(result.Log, "home="+result.dir) {
+			t.Errorf("%s: log = %q, want the run directory as home", kind, result.Log)
+		}
+		if err := result.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(result.dir); !os.IsNotExist(err) {
+			t.Errorf("%s: run directory still exists after Close", kind)
+		}
+	}
+}
+
+func TestSliceFailure(t *testing.T) {
+	tests := []struct {
+		mode string
+		want error
+		log  string
+	}{
+		{"fail", nil, "model is not manifold"},
+		{"empty", ErrNoOutput, "home="},
+	}
+
+	for _, tt := range tests {
+		s := newFakeSlicer(t, types.SlicerPrusaSlicer, tt.mode)
+		_, err := s.Slice(context.Background(), strings.NewReader("solid model"), ".stl", "profile.ini")
+
+		var sliceErr *Error
+		if !errors.As(err, &sliceErr) {
+			t.Fatalf("%s: Slice = %v, want a slicer error", tt.mode, err)
+		}
+		if tt.want != nil && !errors.Is(err, tt.want) {
+			t.Errorf("%s: Slice = %v, want %v", tt.mode, err, tt.want)
+		}
+		if !strings.Contains(sliceErr.Log, tt.log) {
+			t.Errorf("%s: log = %q, want it to contain %q", tt.mode, sliceErr.Log, tt.log)
+		}
+	}
+}
+
+func TestSliceTimeout(t *testing.T) {
+	s := newFakeSlicer(t, types.SlicerPrusaSlicer, "hang")
+	s.Timeout = 100 * time.Millisecond
+
+	_, err := s.Slice(context.Background(), strings.NewReader("solid model"), ".stl", "profile.ini")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Slice of a hanging slicer = %v, want a deadline error", err)
+	}
+}
+
+func TestTailBuffer(t *testing.T) {
+	b := &tailBuffer{limit: 8}
+	_, _ = b.Write([]byte("0123456789"))
+	_, _ = b.Write([]byte("ab"))
+
+	if got := b.String(); got != "[output truncated]\n456789ab" {
+		t.Errorf("String() = %q", got)
+	}
+}
@@ -2,11 +2,14 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"time"
 
 	"cloud.google.com/go/storage"
 	"github.com/torbenconto/spooler/internal/util"
+	"google.golang.org/api/iterator"
 )
 
 type GoogleCloudStorageClient struct {
@@ -63,3 +66,54 @@ func (g *GoogleCloudStorageClient) GetFile(ctx context.Context, objectPath strin
 
 	return r, nil
 }
+
+func (g *GoogleCloudStorageClient) Stat(ctx context.Context, objectPath string) (*ObjectInfo, error) {
+	attrs, err := g.client.Bucket(g.bucketName).Object(objectPath).Attrs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ObjectInfo{
+		Path:         objectPath,
+		Size:         attrs.Size,
+		ContentType:  attrs.ContentType,
+		ETag:         quoteETag(attrs.Etag),
+		LastModified: attrs.Updated,
+	}, nil
+}
+
+func (g *GoogleCloudStorageClient) GetRange(ctx context.Context, objectPath string, offset int64, length int64) (io.ReadCloser, error) {
+	return g.client.Bucket(g.bucketName).Object(objectPath).NewRangeReader(ctx, offset, length)
+}
+
+func (g *GoogleCloudStorageClient) List(ctx context.Context, fn func(ObjectInfo) error) error {
+	it := g.client.Bucket(g.bucketName).Objects(ctx, nil)
+	for {
+		attrs, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := fn(ObjectInfo{
+			Path:         attrs.Name,
+			Size:         attrs.Size,
+			ContentType:  attrs.ContentType,
+			ETag:         quoteETag(attrs.Etag),
+			LastModified: attrs.Updated,
+		}); err != nil {
+			return err
+		}
+	}
+}
+
+// SignedURL returns a V4 signed URL for an object, signed with the credentials the client was created with
+func (g *GoogleCloudStorageClient) SignedURL(ctx context.Context, objectPath string, method string, expires time.Duration) (string, error) {
+	return g.client.Bucket(g.bucketName).SignedURL(objectPath, &storage.SignedURLOptions{
+		Scheme:  storage.SigningSchemeV4,
+		Method:  method,
+		Expires: time.Now().Add(expires),
+	})
+}
@@ -2,18 +2,37 @@ package storage
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/torbenconto/spooler/internal/util"
+)
+
+var (
+	ErrInvalidSignature = errors.New("invalid signature")
+	ErrSignatureExpired = errors.New("signed url has expired")
 )
 
 type LocalStorageClient struct {
 	basePath string
+
+	// secret signs the URLs handed out by SignedURL, publicURL is the server they point to
+	secret    []byte
+	publicURL string
 }
 
-func NewLocalStorageClient(basePath string) (*LocalStorageClient, error) {
+func NewLocalStorageClient(basePath string, secret string, publicURL string) (*LocalStorageClient, error) {
 	absPath, err := filepath.Abs(basePath)
 	if err != nil {
 		return nil, fmt.Errorf("invalid base path: %w", err)
@@ -24,7 +43,9 @@ func NewLocalStorageClient(basePath string) (*LocalStorageClient, error) {
 	}
 
 	return &LocalStorageClient{
-		basePath: absPath,
+		basePath:  absPath,
+		secret:    []byte(secret),
+		publicURL: strings.TrimSuffix(publicURL, "/"),
 	}, nil
 }
 
@@ -80,7 +101,95 @@ func (l *LocalStorageClient) validatePath(objectPath string) error {
 }
 
 func (l *LocalStorageClient) GetFile(ctx context.Context, objectPath string) (io.ReadCloser, error) {
-	return os.Open(filepath.Join(l.basePath, objectPath))
+	if err := l.validatePath(objectPath); err != nil {
+		return nil, fmt.Errorf("invalid path: %w", err)
+	}
+
+	fullPath := filepath.Join(l.basePath, objectPath)
+
+	if !strings.HasPrefix(fullPath, l.basePath) {
+		return nil, fmt.Errorf("path traversal attempt detected")
+	}
+
+	return os.Open(fullPath)
+}
+
+// Stat detects the content type from the first bytes of the file, the version is its size and modification time
+func (l *LocalStorageClient) Stat(ctx context.Context, objectPath string) (*ObjectInfo, error) {
+	if err := l.validatePath(objectPath); err != nil {
+		return nil, fmt.Errorf("invalid path: %w", err)
+	}
+
+	f, err := os.Open(filepath.Join(l.basePath, objectPath))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	contentType, _, err := util.DetectContentType(f)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ObjectInfo{
+		Path:         objectPath,
+		Size:         stat.Size(),
+		ContentType:  contentType,
+		ETag:         fmt.Sprintf(`"%x-%x"`, stat.Size(), stat.ModTime().UnixNano()),
+		LastModified: stat.ModTime(),
+	}, nil
+}
+
+func (l *LocalStorageClient) GetRange(ctx context.Context, objectPath string, offset int64, length int64) (io.ReadCloser, error) {
+	if err := l.validatePath(objectPath); err != nil {
+		return nil, fmt.Errorf("invalid path: %w", err)
+	}
+
+	f, err := os.Open(filepath.Join(l.basePath, objectPath))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if length < 0 {
+		return f, nil
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(f, length), f}, nil
+}
+
+func (l *LocalStorageClient) List(ctx context.Context, fn func(ObjectInfo) error) error {
+	return filepath.WalkDir(l.basePath, func(fullPath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return ctx.Err()
+		}
+
+		stat, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		objectPath, err := filepath.Rel(l.basePath, fullPath)
+		if err != nil {
+			return err
+		}
+
+		return fn(ObjectInfo{
+			Path:         filepath.ToSlash(objectPath),
+			Size:         stat.Size(),
+			LastModified: stat.ModTime(),
+		})
+	})
 }
 
 func (l *LocalStorageClient) DeleteFile(ctx context.Context, objectPath string) error {
@@ -96,3 +205,45 @@ func (l *LocalStorageClient) DeleteFile(ctx context.Context, objectPath string)
 
 	return os.Remove(fullPath)
 }
+
+// SignedURL returns a URL to the /files route of this server carrying an HMAC of the method, object and expiry time
+func (l *LocalStorageClient) SignedURL(ctx context.Context, objectPath string, method string, expires time.Duration) (string, error) {
+	if err := l.validatePath(objectPath); err != nil {
+		return "", fmt.Errorf("invalid path: %w", err)
+	}
+	if len(l.secret) == 0 {
+		return "", errors.New("no secret to sign urls with")
+	}
+
+	expiresAt := time.Now().Add(expires).Unix()
+	query := url.Values{
+		"expires":   {strconv.FormatInt(expiresAt, 10)},
+		"signature": {l.signature(method, objectPath, expiresAt)},
+	}
+	return fmt.Sprintf("%s/files/%s?%s", l.publicURL, url.PathEscape(objectPath), query.Encode()), nil
+}
+
+// VerifySignedURL checks the expiry time and signature of a URL handed out by SignedURL
+func (l *LocalStorageClient) VerifySignedURL(objectPath string, method string, expires string, signature string) error {
+	if len(l.secret) == 0 {
+		return ErrInvalidSignature
+	}
+
+	expiresAt, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	if !hmac.Equal([]byte(signature), []byte(l.signature(method, objectPath, expiresAt))) {
+		return ErrInvalidSignature
+	}
+	if time.Now().Unix() > expiresAt {
+		return ErrSignatureExpired
+	}
+	return nil
+}
+
+func (l *LocalStorageClient) signature(method string, objectPath string, expiresAt int64) string {
+	mac := hmac.New(sha256.New, l.secret)
+	fmt.Fprintf(mac, "%s\n%s\n%d", method, objectPath, expiresAt)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,182 @@
+package storage
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+// MigrationReport is the outcome of a Migration run
+type MigrationReport struct {
+	Copied []string
+	// Skipped were copied by an earlier run
+	Skipped []string
+	// Missing are referenced but do not exist in the source
+	Missing []string
+	Failed  map[string]error
+	// Orphaned exist in the source but are not referenced
+	Orphaned []string
+}
+
+// Migration copies objects from one storage client to another. Every copy is read back from the destination and compared
+// by SHA-256 before it is recorded in the state file. Objects recorded there are skipped when the migration is run again,
+// as long as the destination still holds them with the recorded SHA-256.
+type Migration struct {
+	From StorageClient
+	To   StorageClient
+	// StatePath is the file completed copies are recorded in, one "<sha256> <object path>" line each
+	StatePath string
+}
+
+// Run copies the given objects, the source is left untouched
+func (m *Migration) Run(ctx context.Context, objects []string) (*MigrationReport, error) {
+	done, err := m.loadState()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state file: %w", err)
+	}
+
+	state, err := os.OpenFile(m.StatePath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open state file: %w", err)
+	}
+	defer state.Close()
+
+	referenced := make(map[string]bool, len(objects))
+	for _, objectPath := range objects {
+		if objectPath != "" {
+			referenced[objectPath] = true
+		}
+	}
+	paths := make([]string, 0, len(referenced))
+	for objectPath := range referenced {
+		paths = append(paths, objectPath)
+	}
+	sort.Strings(paths)
+
+	report := &MigrationReport{Failed: make(map[string]error)}
+	for i, objectPath := range paths {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		if hash, ok := done[objectPath]; ok {
+			err := m.verify(ctx, objectPath, hash)
+			if err == nil {
+				report.Skipped = append(report.Skipped, objectPath)
+				continue
+			}
+			log.Printf("[%d/%d] copying %s again: %v", i+1, len(paths), objectPath, err)
+		}
+
+		hash, err := m.copy(ctx, objectPath)
+		switch {
+		case IsNotExist(err):
+			log.Printf("[%d/%d] %s is missing from the source", i+1, len(paths), objectPath)
+			report.Missing = append(report.Missing, objectPath)
+		case err != nil:
+			log.Printf("[%d/%d] failed to copy %s: %v", i+1, len(paths), objectPath, err)
+			report.Failed[objectPath] = err
+		default:
+			if _, err := fmt.Fprintf(state, "%s %s\n", hash, objectPath); err != nil {
+				return report, fmt.Errorf("failed to record %s in state file: %w", objectPath, err)
+			}
+			log.Printf("[%d/%d] copied %s", i+1, len(paths), objectPath)
+			report.Copied = append(report.Copied, objectPath)
+		}
+	}
+
+	if err := m.From.List(ctx, func(info ObjectInfo) error {
+		if !referenced[info.Path] {
+			report.Orphaned = append(report.Orphaned, info.Path)
+		}
+		return nil
+	}); err != nil {
+		return report, fmt.Errorf("failed to list source objects: %w", err)
+	}
+	sort.Strings(report.Orphaned)
+
+	return report, nil
+}
+
+// copy streams an object to the destination and verifies what was stored, returning its SHA-256
+func (m *Migration) copy(ctx context.Context, objectPath string) (string, error) {
+	src, err := m.From.GetFile(ctx, objectPath)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	hash := sha256.New()
+	if err := m.To.StoreFile(ctx, objectPath, io.TeeReader(src, hash)); err != nil {
+		return "", fmt.Errorf("failed to store copy: %w", err)
+	}
+	want := hex.EncodeToString(hash.Sum(nil))
+
+	got, err := hashObject(ctx, m.To, objectPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read back copy: %w", err)
+	}
+	if got != want {
+		return "", fmt.Errorf("checksum mismatch: copied %s, stored %s", want, got)
+	}
+
+	return want, nil
+}
+
+// verify checks that the destination holds an object with the given SHA-256
+func (m *Migration) verify(ctx context.Context, objectPath string, want string) error {
+	got, err := hashObject(ctx, m.To, objectPath)
+	if IsNotExist(err) {
+		return errors.New("copy is missing from the destination")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read copy: %w", err)
+	}
+	if got != want {
+		return fmt.Errorf("checksum mismatch: recorded %s, stored %s", want, got)
+	}
+	return nil
+}
+
+func hashObject(ctx context.Context, storageClient StorageClient, objectPath string) (string, error) {
+	f, err := storageClient.GetFile(ctx, objectPath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// loadState returns the recorded SHA-256 of every copied object, the last record of an object wins
+func (m *Migration) loadState() (map[string]string, error) {
+	done := make(map[string]string)
+
+	f, err := os.Open(m.StatePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return done, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if hash, objectPath, ok := strings.Cut(scanner.Text(), " "); ok {
+			done[objectPath] = hash
+		}
+	}
+	return done, scanner.Err()
+}
@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestMigration(t *testing.T) *Migration {
+	t.Helper()
+	dir := t.TempDir()
+	from, err := NewLocalStorageClient(filepath.Join(dir, "from"), "secret", "http://localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	to, err := NewLocalStorageClient(filepath.Join(dir, "to"), "secret", "http://localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Migration{From: from, To: to, StatePath: filepath.Join(dir, "migrate.state")}
+}
+
+func store(t *testing.T, storageClient StorageClient, objectPath string, content string) {
+	t.Helper()
+	if err := storageClient.StoreFile(context.Background(), objectPath, strings.NewReader(content)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMigrationResume(t *testing.T) {
+	m := newTestMigration(t)
+	ctx := context.Background()
+	store(t, m.From, "a.stl", "solid a")
+	store(t, m.From, "b.gcode", "G28")
+	store(t, m.From, "c.3mf", "PK")
+	store(t, m.From, "orphan.stl", "solid orphan")
+	objects := []string{"a.stl", "b.gcode", "c.3mf", "missing.stl"}
+
+	report, err := m.Run(ctx, objects)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if strings.Join(report.Copied, " ") != "a.stl b.gcode c.3mf" || strings.Join(report.Missing, " ") != "missing.stl" ||
+		strings.Join(report.Orphaned, " ") != "orphan.stl" || len(report.Failed) != 0 {
+		t.Fatalf("first run = %+v", report)
+	}
+
+	// The destination lost one copy and another was overwritten since they were recorded
+	if err := m.To.DeleteFile(ctx, "a.stl"); err != nil {
+		t.Fatal(err)
+	}
+	store(t, m.To, "b.gcode", "truncated")
+
+	report, err = m.Run(ctx, objects)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if strings.Join(report.Copied, " ") != "a.stl b.gcode" || strings.Join(report.Skipped, " ") != "c.3mf" {
+		t.Fatalf("resumed run copied %v and skipped %v, want the changed copies copied again", report.Copied, report.Skipped)
+	}
+	for objectPath, want := range map[string]string{"a.stl": "solid a", "b.gcode": "G28"} {
+		f, err := m.To.GetFile(ctx, objectPath)
+		if err != nil {
+			t.Fatalf("%s was not copied again: %v", objectPath, err)
+		}
+		got, _ := io.ReadAll(f)
+		f.Close()
+		if string(got) != want {
+			t.Errorf("%s = %q, want %q", objectPath, got, want)
+		}
+	}
+
+	report, err = m.Run(ctx, objects)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if len(report.Copied) != 0 || len(report.Skipped) != 3 {
+		t.Errorf("third run copied %v and skipped %v, want everything skipped", report.Copied, report.Skipped)
+	}
+}
@@ -0,0 +1,535 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/torbenconto/spooler/internal/util"
+)
+
+const (
+	// S3 rejects multipart parts smaller than 5 MiB, except for the last one
+	minPartSize     = 5 * 1024 * 1024
+	defaultPartSize = 16 * 1024 * 1024
+	maxParts        = 10000
+
+	maxPresignExpiry = 7 * 24 * time.Hour
+
+	s3TimeFormat = "20060102T150405Z"
+)
+
+// S3Options configures an S3StorageClient
+type S3Options struct {
+	// Endpoint is the base URL of the S3 API, e.g. http://minio:9000. Empty uses AWS in Region.
+	Endpoint string
+	Region   string
+	Bucket   string
+	// UsePathStyle addresses the bucket as a path segment instead of a subdomain, MinIO needs this
+	UsePathStyle bool
+
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+
+	// PartSize is the size of each part of a multipart upload in bytes, files smaller than it are uploaded in one request
+	PartSize int64
+}
+
+// S3Error is an error response of the S3 API
+type S3Error struct {
+	StatusCode int
+	Code       string `xml:"Code"`
+	Message    string `xml:"Message"`
+}
+
+func (e *S3Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("s3 request failed with status %d", e.StatusCode)
+	}
+	return fmt.Sprintf("s3 request failed with status %d: %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// S3StorageClient stores files in a bucket of any S3 compatible service (AWS, MinIO, Backblaze B2, Cloudflare R2).
+// Requests are signed with AWS Signature Version 4.
+type S3StorageClient struct {
+	endpoint  *url.URL
+	region    string
+	bucket    string
+	pathStyle bool
+
+	accessKeyID     string
+	secretAccessKey string
+	sessionToken    string
+
+	partSize int64
+	client   *http.Client
+}
+
+func NewS3StorageClient(opts S3Options) (*S3StorageClient, error) {
+	if opts.Bucket == "" {
+		return nil, errors.New("s3 bucket is required")
+	}
+	if opts.AccessKeyID == "" || opts.SecretAccessKey == "" {
+		return nil, errors.New("s3 credentials are required")
+	}
+	if opts.Region == "" {
+		opts.Region = "us-east-1"
+	}
+	if opts.Endpoint == "" {
+		opts.Endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", opts.Region)
+	}
+
+	endpoint, err := url.Parse(opts.Endpoint)
+	if err != nil || endpoint.Host == "" || (endpoint.Scheme != "http" && endpoint.Scheme != "https") {
+		return nil, fmt.Errorf("invalid s3 endpoint: %q", opts.Endpoint)
+	}
+
+	partSize := opts.PartSize
+	if partSize == 0 {
+		partSize = defaultPartSize
+	}
+	if partSize < minPartSize {
+		return nil, fmt.Errorf("s3 part size must be at least %d bytes", minPartSize)
+	}
+
+	return &S3StorageClient{
+		endpoint:        endpoint,
+		region:          opts.Region,
+		bucket:          opts.Bucket,
+		pathStyle:       opts.UsePathStyle,
+		accessKeyID:     opts.AccessKeyID,
+		secretAccessKey: opts.SecretAccessKey,
+		sessionToken:    opts.SessionToken,
+		partSize:        partSize,
+		client:          &http.Client{},
+	}, nil
+}
+
+// objectURL returns the URL of an object, or of the bucket when objectPath is empty
+func (s *S3StorageClient) objectURL(objectPath string, query url.Values) *url.URL {
+	u := *s.endpoint
+	path := strings.TrimSuffix(u.Path, "/")
+	if s.pathStyle {
+		path += "/" + s.bucket
+	} else {
+		u.Host = s.bucket + "." + u.Host
+	}
+	u.Path = path + "/" + objectPath
+	// SigV4 signs the path with every byte but unreserved characters and slashes escaped, it has to be sent exactly like that
+	u.RawPath = escapePath(u.Path)
+	u.RawQuery = canonicalQuery(query)
+	return &u
+}
+
+func escapePath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = escape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// do signs and sends a request, returning the response when its status is 2xx and an S3Error otherwise
+func (s *S3StorageClient) do(ctx context.Context, method string, objectPath string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	u := s.objectURL(objectPath, query)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = int64(len(body))
+	if body == nil {
+		req.Body = http.NoBody
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+
+	s.sign(req, u, sha256Hex(body), time.Now().UTC())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		return nil, readS3Error(resp)
+	}
+	return resp, nil
+}
+
+func readS3Error(resp *http.Response) error {
+	s3Err := &S3Error{StatusCode: resp.StatusCode}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	_ = xml.Unmarshal(data, s3Err)
+	return s3Err
+}
+
+// sign adds the AWS Signature Version 4 headers to a request
+func (s *S3StorageClient) sign(req *http.Request, u *url.URL, payloadHash string, now time.Time) {
+	req.Header.Set("X-Amz-Date", now.Format(s3TimeFormat))
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if s.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.sessionToken)
+	}
+
+	// Only the host and x-amz-* headers are signed, proxies may rewrite anything else
+	headers := map[string]string{"host": u.Host}
+	for key, values := range req.Header {
+		lower := strings.ToLower(key)
+		if strings.HasPrefix(lower, "x-amz-") {
+			headers[lower] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	signature := s.signature(req.Method, u, canonicalHeaders.String(), signedHeaders, payloadHash, now)
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKeyID, s.scope(now), signedHeaders, signature))
+}
+
+func (s *S3StorageClient) scope(now time.Time) string {
+	return now.Format("20060102") + "/" + s.region + "/s3/aws4_request"
+}
+
+// signature computes the SigV4 signature of a request to u
+func (s *S3StorageClient) signature(method string, u *url.URL, canonicalHeaders string, signedHeaders string, payloadHash string, now time.Time) string {
+	canonicalRequest := strings.Join([]string{
+		method,
+		u.RawPath,
+		u.RawQuery,
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	stringToSign := "AWS4-HMAC-SHA256\n" + now.Format(s3TimeFormat) + "\n" + s.scope(now) + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+s.secretAccessKey), now.Format("20060102"))
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+// SignedURL presigns a request to an object, the signature is in the query so anyone holding the URL can use it until it expires.
+// The payload is not signed, S3 accepts at most a week of validity.
+func (s *S3StorageClient) SignedURL(ctx context.Context, objectPath string, method string, expires time.Duration) (string, error) {
+	if expires <= 0 || expires > maxPresignExpiry {
+		return "", fmt.Errorf("s3 presigned urls must expire within %s", maxPresignExpiry)
+	}
+
+	return s.presign(objectPath, method, expires, time.Now().UTC()), nil
+}
+
+func (s *S3StorageClient) presign(objectPath string, method string, expires time.Duration, now time.Time) string {
+	query := url.Values{
+		"X-Amz-Algorithm":     {"AWS4-HMAC-SHA256"},
+		"X-Amz-Credential":    {s.accessKeyID + "/" + s.scope(now)},
+		"X-Amz-Date":          {now.Format(s3TimeFormat)},
+		"X-Amz-Expires":       {strconv.Itoa(int(expires.Seconds()))},
+		"X-Amz-SignedHeaders": {"host"},
+	}
+	if s.sessionToken != "" {
+		query.Set("X-Amz-Security-Token", s.sessionToken)
+	}
+
+	u := s.objectURL(objectPath, query)
+	signature := s.signature(method, u, "host:"+u.Host+"\n", "host", "UNSIGNED-PAYLOAD", now)
+	u.RawQuery += "&X-Amz-Signature=" + signature
+	return u.String()
+}
+
+// canonicalQuery encodes a query sorted by key with every reserved character escaped, as SigV4 requires
+func canonicalQuery(query url.Values) string {
+	if len(query) == 0 {
+		return ""
+	}
+
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, key := range keys {
+		values := append([]string(nil), query[key]...)
+		sort.Strings(values)
+		for _, value := range values {
+			parts = append(parts, escape(key)+"="+escape(value))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// escape percent encodes everything but the unreserved characters A-Z, a-z, 0-9, '-', '.', '_' and '~'
+func escape(s string) string {
+	const hexDigits = "0123456789ABCDEF"
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '.' || c == '_' || c == '~' {
+			b.WriteByte(c)
+			continue
+		}
+		b.WriteByte('%')
+		b.WriteByte(hexDigits[c>>4])
+		b.WriteByte(hexDigits[c&15])
+	}
+	return b.String()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// StoreFile uploads a file in a single request when it is smaller than the part size and as a multipart upload otherwise.
+// Parts are buffered in memory one at a time so every request can be signed with its payload hash.
+func (s *S3StorageClient) StoreFile(ctx context.Context, objectPath string, file io.Reader) error {
+	contentType, reader, err := util.DetectContentType(file)
+	if err != nil {
+		return err
+	}
+
+	header := http.Header{}
+	header.Set("Content-Type", contentType)
+	header.Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, objectPath))
+
+	buf := make([]byte, s.partSize)
+	n, err := io.ReadFull(reader, buf)
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		resp, err := s.do(ctx, http.MethodPut, objectPath, nil, header, buf[:n])
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return s.multipartUpload(ctx, objectPath, header, reader, buf)
+}
+
+type completedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+// multipartUpload uploads first, a full part, followed by the rest of reader. The upload is aborted when any part fails.
+func (s *S3StorageClient) multipartUpload(ctx context.Context, objectPath string, header http.Header, reader io.Reader, first []byte) error {
+	resp, err := s.do(ctx, http.MethodPost, objectPath, url.Values{"uploads": {""}}, header, nil)
+	if err != nil {
+		return err
+	}
+	var initiated struct {
+		UploadID string `xml:"UploadId"`
+	}
+	err = xml.NewDecoder(resp.Body).Decode(&initiated)
+	resp.Body.Close()
+	if err != nil {
+		return fmt.Errorf("failed to read multipart upload id: %w", err)
+	}
+	if initiated.UploadID == "" {
+		return errors.New("s3 returned an empty multipart upload id")
+	}
+
+	if err := s.uploadParts(ctx, objectPath, initiated.UploadID, reader, first); err != nil {
+		// Use a fresh context, the upload has to be aborted even when ctx was canceled
+		abortCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if resp, abortErr := s.do(abortCtx, http.MethodDelete, objectPath, url.Values{"uploadId": {initiated.UploadID}}, nil, nil); abortErr == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *S3StorageClient) uploadParts(ctx context.Context, objectPath string, uploadID string, reader io.Reader, buf []byte) error {
+	var parts []completedPart
+	data := buf
+
+	for partNumber := 1; ; partNumber++ {
+		if partNumber > maxParts {
+			return fmt.Errorf("file needs more than %d parts, increase the part size", maxParts)
+		}
+
+		resp, err := s.do(ctx, http.MethodPut, objectPath, url.Values{
+			"partNumber": {strconv.Itoa(partNumber)},
+			"uploadId":   {uploadID},
+		}, nil, data)
+		if err != nil {
+			return fmt.Errorf("failed to upload part %d: %w", partNumber, err)
+		}
+		resp.Body.Close()
+		parts = append(parts, completedPart{PartNumber: partNumber, ETag: resp.Header.Get("ETag")})
+
+		n, err := io.ReadFull(reader, buf)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return err
+		}
+		data = buf[:n]
+	}
+
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+		Parts   []completedPart `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.do(ctx, http.MethodPost, objectPath, url.Values{"uploadId": {uploadID}}, nil, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// Completing an upload can fail after the 200 status has been sent, the error is then in the body
+	data, err = io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return err
+	}
+	var result struct {
+		XMLName xml.Name
+		S3Error
+	}
+	if err := xml.Unmarshal(data, &result); err == nil && result.XMLName.Local == "Error" {
+		result.S3Error.StatusCode = resp.StatusCode
+		return &result.S3Error
+	}
+	return nil
+}
+
+func (s *S3StorageClient) GetFile(ctx context.Context, objectPath string) (io.ReadCloser, error) {
+	resp, err := s.do(ctx, http.MethodGet, objectPath, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *S3StorageClient) Stat(ctx context.Context, objectPath string) (*ObjectInfo, error) {
+	resp, err := s.do(ctx, http.MethodHead, objectPath, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	info := &ObjectInfo{
+		Path:        objectPath,
+		Size:        resp.ContentLength,
+		ContentType: resp.Header.Get("Content-Type"),
+		ETag:        quoteETag(resp.Header.Get("ETag")),
+	}
+	if modified, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		info.LastModified = modified
+	}
+	return info, nil
+}
+
+func (s *S3StorageClient) GetRange(ctx context.Context, objectPath string, offset int64, length int64) (io.ReadCloser, error) {
+	if length == 0 {
+		return io.NopCloser(bytes.NewReader(nil)), nil
+	}
+
+	byteRange := fmt.Sprintf("bytes=%d-", offset)
+	if length > 0 {
+		byteRange += strconv.FormatInt(offset+length-1, 10)
+	}
+	resp, err := s.do(ctx, http.MethodGet, objectPath, nil, http.Header{"Range": {byteRange}}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// listBucketResult is a page of a ListObjectsV2 response
+type listBucketResult struct {
+	IsTruncated           bool
+	NextContinuationToken string
+	Contents              []struct {
+		Key          string
+		Size         int64
+		ETag         string
+		LastModified time.Time
+	}
+}
+
+func (s *S3StorageClient) List(ctx context.Context, fn func(ObjectInfo) error) error {
+	query := url.Values{"list-type": {"2"}}
+	for {
+		resp, err := s.do(ctx, http.MethodGet, "", query, nil, nil)
+		if err != nil {
+			return err
+		}
+		var page listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read object list: %w", err)
+		}
+
+		for _, object := range page.Contents {
+			if err := fn(ObjectInfo{
+				Path:         object.Key,
+				Size:         object.Size,
+				ETag:         quoteETag(object.ETag),
+				LastModified: object.LastModified,
+			}); err != nil {
+				return err
+			}
+		}
+
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return nil
+		}
+		query.Set("continuation-token", page.NextContinuationToken)
+	}
+}
+
+func (s *S3StorageClient) DeleteFile(ctx context.Context, objectPath string) error {
+	resp, err := s.do(ctx, http.MethodDelete, objectPath, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
@@ -2,9 +2,15 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"net/http"
+	"strings"
+	"time"
 
+	"cloud.google.com/go/storage"
 	"github.com/torbenconto/spooler/config"
 	"github.com/torbenconto/spooler/internal/types"
 )
@@ -13,16 +19,67 @@ type StorageClient interface {
 	GetFile(ctx context.Context, objectPath string) (io.ReadCloser, error)
 	StoreFile(ctx context.Context, objectPath string, file io.Reader) error
 	DeleteFile(ctx context.Context, objectPath string) error
+	// Stat returns the size, content type and version of an object without reading it
+	Stat(ctx context.Context, objectPath string) (*ObjectInfo, error)
+	// GetRange reads length bytes of an object starting at offset, a negative length reads to the end
+	GetRange(ctx context.Context, objectPath string, offset int64, length int64) (io.ReadCloser, error)
+	// List calls fn for every stored object, stopping at the first error fn returns
+	List(ctx context.Context, fn func(ObjectInfo) error) error
+}
+
+// ObjectInfo describes a stored object
+type ObjectInfo struct {
+	Path         string
+	Size         int64
+	ContentType  string
+	ETag         string
+	LastModified time.Time
+}
+
+// URLSigner is implemented by storage clients that can hand out short lived URLs to read (GET) or write (PUT) an object directly,
+// so large files do not have to pass through the server
+type URLSigner interface {
+	SignedURL(ctx context.Context, objectPath string, method string, expires time.Duration) (string, error)
+}
+
+// IsNotExist reports whether an error of any storage client means the object does not exist
+func IsNotExist(err error) bool {
+	var s3Err *S3Error
+	return errors.Is(err, fs.ErrNotExist) || errors.Is(err, storage.ErrObjectNotExist) ||
+		(errors.As(err, &s3Err) && s3Err.StatusCode == http.StatusNotFound)
+}
+
+// quoteETag puts an entity tag in the quotes HTTP requires, providers differ in whether they return them
+func quoteETag(etag string) string {
+	if etag == "" || strings.HasPrefix(etag, `"`) || strings.HasPrefix(etag, `W/"`) {
+		return etag
+	}
+	return `"` + etag + `"`
 }
 
 func NewStorageClient(ctx context.Context, appConfig *config.Config) (StorageClient, error) {
-	provider := appConfig.Storage.Provider
+	return NewStorageClientFor(ctx, appConfig, appConfig.Storage.Provider)
+}
 
+// NewStorageClientFor creates a client for the given provider from its section of the config, whichever provider is selected
+func NewStorageClientFor(ctx context.Context, appConfig *config.Config, provider types.StorageProvider) (StorageClient, error) {
 	switch provider {
 	case types.GoogleCloudStorage:
 		return NewGoogleCloudStorageClient(ctx, appConfig.Storage.GoogleCloud.BucketName)
 	case types.LocalStorage:
-		return NewLocalStorageClient(appConfig.Storage.Local.BasePath)
+		return NewLocalStorageClient(appConfig.Storage.Local.BasePath, appConfig.SecretKey, appConfig.Storage.Local.PublicURL)
+	case types.S3Storage:
+		s3 := appConfig.Storage.S3
+		return NewS3StorageClient(S3Options{
+			Endpoint:        s3.Endpoint,
+			Region:          s3.Region,
+			Bucket:          s3.Bucket,
+			UsePathStyle:    s3.UsePathStyle,
+			AccessKeyID:     s3.AccessKeyID,
+			SecretAccessKey: s3.SecretAccessKey,
+			SessionToken:    s3.SessionToken,
+			PartSize:        s3.PartSize,
+		})
 	default:
 		return nil, fmt.Errorf("invalid provider: %s", provider)
 	}
@@ -0,0 +1,12 @@
+package types
+
+// Quota limits what a user may submit, a zero limit is unlimited
+type Quota struct {
+	// MaxOpenPrints counts prints that are awaiting approval, queued, printing or paused
+	MaxOpenPrints int `mapstructure:"max_open_prints" json:"max_open_prints"`
+	// Filament limits in grams over the last 7 and 30 days
+	MaxGramsPerWeek  float64 `mapstructure:"max_grams_per_week" json:"max_grams_per_week"`
+	MaxGramsPerMonth float64 `mapstructure:"max_grams_per_month" json:"max_grams_per_month"`
+	// MaxFileSize is the largest model that may be uploaded in bytes
+	MaxFileSize int64 `mapstructure:"max_file_size" json:"max_file_size"`
+}
@@ -0,0 +1,21 @@
+package types
+
+type SchedulerMode string
+
+const (
+	// SchedulerManual only orders the queue, admins pick printers themselves
+	SchedulerManual SchedulerMode = "manual"
+	// SchedulerSemiAuto proposes a printer for each queued print and waits for an admin to confirm it
+	SchedulerSemiAuto SchedulerMode = "semi_auto"
+	// SchedulerAuto dispatches proposed prints without confirmation
+	SchedulerAuto SchedulerMode = "auto"
+)
+
+func (m SchedulerMode) IsValid() bool {
+	switch m {
+	case SchedulerManual, SchedulerSemiAuto, SchedulerAuto:
+		return true
+	default:
+		return false
+	}
+}
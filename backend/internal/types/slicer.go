@@ -0,0 +1,20 @@
+package types
+
+type SlicerKind string
+
+const (
+	// SlicerNone disables server side slicing
+	SlicerNone        SlicerKind = ""
+	SlicerPrusaSlicer SlicerKind = "prusaslicer"
+	SlicerOrcaSlicer  SlicerKind = "orcaslicer"
+	SlicerCuraEngine  SlicerKind = "curaengine"
+)
+
+func (k SlicerKind) IsValid() bool {
+	switch k {
+	case SlicerNone, SlicerPrusaSlicer, SlicerOrcaSlicer, SlicerCuraEngine:
+		return true
+	default:
+		return false
+	}
+}
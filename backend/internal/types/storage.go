@@ -5,11 +5,12 @@ type StorageProvider string
 const (
 	GoogleCloudStorage StorageProvider = "google_cloud"
 	LocalStorage       StorageProvider = "local"
+	S3Storage          StorageProvider = "s3"
 )
 
 func (s StorageProvider) IsValid() bool {
 	switch s {
-	case GoogleCloudStorage, LocalStorage:
+	case GoogleCloudStorage, LocalStorage, S3Storage:
 		return true
 	default:
 		return false
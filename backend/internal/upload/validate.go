@@ -0,0 +1,272 @@
+// Package upload checks that uploaded print files are what their extension claims before they are parsed or stored
+package upload
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Types are the file types that can be printed, by extension without the leading dot
+const (
+	TypeSTL      = "stl"
+	Type3MF      = "3mf"
+	TypeGCode3MF = "gcode.3mf"
+	TypeGCode    = "gcode"
+)
+
+const (
+	stlHeaderSize   = 80
+	stlTriangleSize = 50
+	// sniffSize is how much of a file is looked at to tell its format
+	sniffSize = 4096
+	// maxZipEntries caps the number of parts in a 3MF package
+	maxZipEntries = 10000
+	// Parts smaller than this are not checked for their compression ratio, tiny XML parts compress extremely well
+	minRatioCheckSize = 1 << 20
+
+	modelRelationship = "http://schemas.microsoft.com/3dmanufacturing/2013/01/3dmodel"
+)
+
+var platePartRegex = regexp.MustCompile(`^Metadata/plate_\d+\.gcode$`)
+
+// Rules are the limits uploads are validated against
+type Rules struct {
+	// AllowedTypes are the accepted types, see the Type constants
+	AllowedTypes []string
+	// MaxFileSize caps the size of an upload, 0 is unlimited
+	MaxFileSize int64
+	// MaxUncompressedSize caps the total size a 3MF package unpacks to, 0 is unlimited
+	MaxUncompressedSize int64
+	// MaxCompressionRatio caps how far any part of a 3MF package may be compressed, 0 is unlimited
+	MaxCompressionRatio float64
+}
+
+// Allows reports whether a type is accepted
+func (r *Rules) Allows(fileType string) bool {
+	for _, allowed := range r.AllowedTypes {
+		if strings.EqualFold(strings.TrimPrefix(allowed, "."), fileType) {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidationError lists everything wrong with an upload
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid upload: " + strings.Join(e.Problems, "; ")
+}
+
+func (e *ValidationError) add(format string, args ...any) {
+	e.Problems = append(e.Problems, fmt.Sprintf(format, args...))
+}
+
+func (e *ValidationError) orNil() error {
+	if len(e.Problems) == 0 {
+		return nil
+	}
+	return e
+}
+
+// TypeOf returns the type of a file by its name, keeping the double extension of sliced .gcode.3mf projects
+func TypeOf(fileName string) string {
+	lower := strings.ToLower(fileName)
+	if strings.HasSuffix(lower, "."+TypeGCode3MF) {
+		return TypeGCode3MF
+	}
+	return strings.TrimPrefix(path.Ext(lower), ".")
+}
+
+// CheckName validates the type and declared size of a file before it is uploaded
+func CheckName(fileName string, size int64, rules *Rules) error {
+	problems := &ValidationError{}
+	checkName(problems, fileName, size, rules)
+	return problems.orNil()
+}
+
+func checkName(problems *ValidationError, fileName string, size int64, rules *Rules) {
+	fileType := TypeOf(fileName)
+	if !rules.Allows(fileType) {
+		problems.add("files of type %q are not accepted, allowed are %s", fileType, strings.Join(rules.AllowedTypes, ", "))
+	}
+	if rules.MaxFileSize > 0 && size > rules.MaxFileSize {
+		problems.add("file is %d bytes, at most %d are allowed", size, rules.MaxFileSize)
+	}
+}
+
+// Validate checks that a file has an accepted type and size and that its content matches its extension. Only the parts
+// needed to tell the format are read: the start and end of STL and G-code files and the central directory of 3MF packages.
+func Validate(file io.ReaderAt, size int64, fileName string, rules *Rules) error {
+	problems := &ValidationError{}
+	checkName(problems, fileName, size, rules)
+	if len(problems.Problems) > 0 {
+		return problems
+	}
+
+	head := make([]byte, min(size, sniffSize))
+	if _, err := file.ReadAt(head, 0); err != nil && err != io.EOF {
+		return fmt.Errorf("failed to read file: %w", err)
+	}
+
+	fileType := TypeOf(fileName)
+	isZip := bytes.HasPrefix(head, []byte("PK\x03\x04"))
+
+	switch fileType {
+	case TypeSTL:
+		if isZip {
+			problems.add("file is a zip archive, not an STL file")
+			break
+		}
+		validateSTL(problems, file, size, head)
+	case Type3MF, TypeGCode3MF:
+		if !isZip {
+			problems.add("file is not a zip archive, 3MF files are zip packages")
+			break
+		}
+		validate3MF(problems, file, size, fileType, rules)
+	case TypeGCode:
+		if isZip {
+			problems.add("file is a zip archive, sliced 3MF projects need the .gcode.3mf extension")
+			break
+		}
+		if bytes.IndexByte(head, 0) >= 0 {
+			problems.add("file is binary, G-code files are text")
+		}
+	}
+
+	return problems.orNil()
+}
+
+// validateSTL accepts ASCII files starting with "solid" and ending with "endsolid", and binary files whose size matches
+// the triangle count in their header
+func validateSTL(problems *ValidationError, file io.ReaderAt, size int64, head []byte) {
+	if bytes.HasPrefix(bytes.TrimLeft(head, " \t\r\n"), []byte("solid")) {
+		tail := make([]byte, min(size, 1024))
+		if _, err := file.ReadAt(tail, size-int64(len(tail))); err != nil && err != io.EOF {
+			problems.add("failed to read file: %v", err)
+			return
+		}
+		if bytes.Contains(tail, []byte("endsolid")) {
+			return
+		}
+		// Binary files may start with "solid" too, fall through to the size check
+	}
+
+	if size < stlHeaderSize+4 {
+		problems.add("file is too short to be an STL file")
+		return
+	}
+	count := int64(binary.LittleEndian.Uint32(head[stlHeaderSize:]))
+	if want := stlHeaderSize + 4 + count*stlTriangleSize; want != size {
+		problems.add("binary STL header declares %d triangles, which take %d bytes, but the file is %d bytes", count, want, size)
+	}
+}
+
+type xmlRelationships struct {
+	Relationships []struct {
+		Target string `xml:"Target,attr"`
+		Type   string `xml:"Type,attr"`
+	} `xml:"Relationship"`
+}
+
+// validate3MF checks the zip central directory for the required OPC parts and for signs of a zip bomb. A .3mf needs a
+// 3D model part, a sliced .gcode.3mf the G-code of at least one plate.
+func validate3MF(problems *ValidationError, file io.ReaderAt, size int64, fileType string, rules *Rules) {
+	zr, err := zip.NewReader(file, size)
+	if err != nil {
+		problems.add("file is not a valid zip archive: %v", err)
+		return
+	}
+
+	if len(zr.File) > maxZipEntries {
+		problems.add("package has %d parts, at most %d are allowed", len(zr.File), maxZipEntries)
+		return
+	}
+
+	parts := make(map[string]*zip.File, len(zr.File))
+	var uncompressed uint64
+	hasGCode := false
+	for _, f := range zr.File {
+		name := strings.TrimPrefix(path.Clean("/"+f.Name), "/")
+		parts[name] = f
+		uncompressed += f.UncompressedSize64
+
+		if platePartRegex.MatchString(name) {
+			hasGCode = true
+		}
+		if rules.MaxCompressionRatio > 0 && f.UncompressedSize64 >= minRatioCheckSize {
+			if ratio := float64(f.UncompressedSize64) / float64(max(f.CompressedSize64, 1)); ratio > rules.MaxCompressionRatio {
+				problems.add("part %s is compressed %.0f:1, at most %.0f:1 is allowed", name, ratio, rules.MaxCompressionRatio)
+			}
+		}
+	}
+	if rules.MaxUncompressedSize > 0 && uncompressed > uint64(rules.MaxUncompressedSize) {
+		problems.add("package unpacks to %d bytes, at most %d are allowed", uncompressed, rules.MaxUncompressedSize)
+	}
+
+	if _, ok := parts["[Content_Types].xml"]; !ok {
+		problems.add("package is missing [Content_Types].xml")
+	}
+	rels, ok := parts["_rels/.rels"]
+	if !ok {
+		problems.add("package is missing _rels/.rels")
+	}
+
+	switch fileType {
+	case Type3MF:
+		if rels == nil {
+			return
+		}
+		start, err := startPart(rels)
+		if err != nil {
+			problems.add("failed to read _rels/.rels: %v", err)
+			return
+		}
+		if start == "" {
+			problems.add("_rels/.rels does not point to a 3D model part")
+		} else if _, ok := parts[start]; !ok {
+			if hasGCode {
+				problems.add("package has no 3D model but contains G-code, sliced projects need the .gcode.3mf extension")
+			} else {
+				problems.add("package is missing its 3D model part %s", start)
+			}
+		}
+	case TypeGCode3MF:
+		if !hasGCode {
+			problems.add("package contains no plate G-code, unsliced projects need the .3mf extension")
+		}
+	}
+}
+
+// startPart returns the model part the package relationships point to
+func startPart(rels *zip.File) (string, error) {
+	if rels.UncompressedSize64 > 1<<20 {
+		return "", fmt.Errorf("part is too large")
+	}
+	rc, err := rels.Open()
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+
+	var parsed xmlRelationships
+	if err := xml.NewDecoder(io.LimitReader(rc, 1<<20)).Decode(&parsed); err != nil {
+		return "", err
+	}
+	for _, rel := range parsed.Relationships {
+		if rel.Type == modelRelationship {
+			return strings.TrimPrefix(path.Clean("/"+rel.Target), "/"), nil
+		}
+	}
+	return "", nil
+}
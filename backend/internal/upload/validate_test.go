@@ -0,0 +1,158 @@
+package upload
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+var testRules = &Rules{
+	AllowedTypes:        []string{TypeSTL, Type3MF, TypeGCode3MF, TypeGCode},
+	MaxFileSize:         1 << 20,
+	MaxUncompressedSize: 1 << 28,
+	MaxCompressionRatio: 100,
+}
+
+// binarySTL returns a binary STL with the given header declaring count triangles and holding triangles of them
+func binarySTL(header string, count, triangles uint32) []byte {
+	buf := make([]byte, stlHeaderSize+4+int(triangles)*stlTriangleSize)
+	copy(buf, header)
+	binary.LittleEndian.PutUint32(buf[stlHeaderSize:], count)
+	return buf
+}
+
+type zipPart struct {
+	header  zip.FileHeader
+	content string
+}
+
+// buildZip packs parts into a zip archive, parts with sizes in their header are written raw so their central directory
+// entries can claim anything
+func buildZip(t *testing.T, parts ...zipPart) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, part := range parts {
+		header := part.header
+		var (
+			w   io.Writer
+			err error
+		)
+		if header.UncompressedSize64 > 0 {
+			w, err = zw.CreateRaw(&header)
+		} else {
+			w, err = zw.CreateHeader(&header)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(part.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func part(name, content string) zipPart {
+	return zipPart{header: zip.FileHeader{Name: name, Method: zip.Deflate}, content: content}
+}
+
+var (
+	contentTypesPart = part("[Content_Types].xml", `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"/>`)
+	relsPart         = part("_rels/.rels", `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Target="/3D/3dmodel.model" Id="rel0" Type="`+modelRelationship+`"/></Relationships>`)
+	modelPart = part("3D/3dmodel.model", `<model unit="millimeter"/>`)
+	platePart = part("Metadata/plate_1.gcode", "G28\n")
+)
+
+func TestValidate(t *testing.T) {
+	asciiSTL := "solid cube\nfacet normal 0 0 0\nouter loop\nvertex 0 0 0\nvertex 1 0 0\nvertex 0 1 0\nendloop\nendfacet\nendsolid cube\n"
+
+	validModel := buildZip(t, contentTypesPart, relsPart, modelPart)
+	truncatedDirectory := validModel[:len(validModel)-10]
+
+	tests := []struct {
+		name     string
+		fileName string
+		content  []byte
+		// want is part of the expected problem, empty when the file is valid
+		want string
+	}{
+		{"ascii stl", "cube.stl", []byte(asciiSTL), ""},
+		{"binary stl", "cube.stl", binarySTL("exported", 2, 2), ""},
+		{"binary stl with solid header", "cube.stl", binarySTL("solid cube", 2, 2), ""},
+		{"binary stl missing triangles", "cube.stl", binarySTL("exported", 3, 2), "declares 3 triangles"},
+		{"solid prefixed binary stl missing triangles", "cube.stl", binarySTL("solid cube", 3, 2), "declares 3 triangles"},
+		{"ascii stl without endsolid", "cube.stl", []byte("solid cube\nfacet normal 0 0 0\n"), "too short"},
+		{"zip named stl", "cube.stl", validModel, "zip archive, not an STL"},
+		{"disallowed type", "cube.obj", []byte("v 0 0 0"), `"obj" are not accepted`},
+
+		{"3mf", "cube.3mf", validModel, ""},
+		{"3mf missing its model", "cube.3mf", buildZip(t, contentTypesPart, relsPart), "missing its 3D model part 3D/3dmodel.model"},
+		{"3mf missing content types", "cube.3mf", buildZip(t, relsPart, modelPart), "missing [Content_Types].xml"},
+		{"sliced project named 3mf", "cube.3mf", buildZip(t, contentTypesPart, relsPart, platePart), "need the .gcode.3mf extension"},
+		{"gcode 3mf", "cube.gcode.3mf", buildZip(t, contentTypesPart, relsPart, platePart), ""},
+		{"gcode 3mf without plates", "cube.gcode.3mf", validModel, "no plate G-code"},
+		{"3mf that is not a zip", "cube.3mf", []byte(asciiSTL), "not a zip archive"},
+		{"3mf with truncated central directory", "cube.3mf", truncatedDirectory, "not a valid zip archive"},
+		{"3mf with forged compression ratio", "cube.3mf", buildZip(t, contentTypesPart, relsPart, modelPart, zipPart{
+			header:  zip.FileHeader{Name: "3D/bomb.model", Method: zip.Deflate, CompressedSize64: 16, UncompressedSize64: 1 << 26},
+			content: strings.Repeat("x", 16),
+		}), "compressed 4194304:1"},
+		{"3mf with forged uncompressed size", "cube.3mf", buildZip(t, contentTypesPart, relsPart, modelPart, zipPart{
+			header:  zip.FileHeader{Name: "3D/bomb.model", Method: zip.Store, CompressedSize64: 16, UncompressedSize64: 1 << 30},
+			content: strings.Repeat("x", 16),
+		}), "unpacks to"},
+
+		{"gcode", "cube.gcode", []byte("G28\nG1 X10\n"), ""},
+		{"binary gcode", "cube.gcode", []byte("G28\x00\x01"), "file is binary"},
+		{"zip named gcode", "cube.gcode", validModel, "need the .gcode.3mf extension"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate(bytes.NewReader(tt.content), int64(len(tt.content)), tt.fileName, testRules)
+			if tt.want == "" {
+				if err != nil {
+					t.Fatalf("Validate = %v, want nil", err)
+				}
+				return
+			}
+
+			var problems *ValidationError
+			if !errors.As(err, &problems) {
+				t.Fatalf("Validate = %v, want a ValidationError", err)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Validate = %v, want a problem containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestTypeOf(t *testing.T) {
+	tests := []struct {
+		fileName string
+		want     string
+	}{
+		{"cube.stl", TypeSTL},
+		{"Cube.STL", TypeSTL},
+		{"cube.3mf", Type3MF},
+		{"cube.gcode.3mf", TypeGCode3MF},
+		{"cube.gcode", TypeGCode},
+		{"cube", ""},
+	}
+
+	for _, tt := range tests {
+		if got := TypeOf(tt.fileName); got != tt.want {
+			t.Errorf("TypeOf(%q) = %q, want %q", tt.fileName, got, tt.want)
+		}
+	}
+}
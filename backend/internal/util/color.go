@@ -0,0 +1,25 @@
+package util
+
+import (
+	"regexp"
+	"strconv"
+)
+
+var hexColorRegex = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// ValidateHexColor reports whether color is a 6 digit hex color in the form #rrggbb
+func ValidateHexColor(color string) bool {
+	return hexColorRegex.MatchString(color)
+}
+
+// ParseHexColor returns the red, green and blue components of a #rrggbb color
+func ParseHexColor(color string) (uint8, uint8, uint8, bool) {
+	if !ValidateHexColor(color) {
+		return 0, 0, 0, false
+	}
+	value, err := strconv.ParseUint(color[1:], 16, 32)
+	if err != nil {
+		return 0, 0, 0, false
+	}
+	return uint8(value >> 16), uint8(value >> 8), uint8(value), true
+}
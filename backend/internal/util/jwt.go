@@ -1,6 +1,7 @@
 package util
 
 import (
+	"errors"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -55,3 +56,44 @@ func ParseJWT(tokenString string) (*CustomClaims, error) {
 		return nil, err
 	}
 }
+
+// UploadClaims let a user attach a file they uploaded straight to storage to a new print
+type UploadClaims struct {
+	UserID     uint   `json:"id"`
+	ObjectPath string `json:"object"`
+	FileName   string `json:"file_name"`
+	jwt.RegisteredClaims
+}
+
+// uploadSecret is derived from the secret key so upload tokens and session tokens can never be used in place of each other
+func uploadSecret() []byte {
+	return []byte(config.Cfg.SecretKey + ":upload")
+}
+
+func GenerateUploadToken(userID uint, objectPath string, fileName string, expires time.Duration) (string, error) {
+	claims := UploadClaims{
+		UserID:     userID,
+		ObjectPath: objectPath,
+		FileName:   fileName,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(expires)),
+		},
+	}
+
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(uploadSecret())
+}
+
+func ParseUploadToken(tokenString string) (*UploadClaims, error) {
+	token, err := jwt.ParseWithClaims(tokenString, &UploadClaims{}, func(token *jwt.Token) (interface{}, error) {
+		return uploadSecret(), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(*UploadClaims)
+	if !ok || !token.Valid {
+		return nil, errors.New("invalid upload token")
+	}
+	return claims, nil
+}
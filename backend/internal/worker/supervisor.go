@@ -0,0 +1,75 @@
+package worker
+
+import (
+	"context"
+	"log"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// Task is a unit of background work run periodically by a Supervisor
+type Task func(ctx context.Context) error
+
+// Supervisor runs background tasks on an interval and waits for all of them to stop when their context is canceled
+type Supervisor struct {
+	wg sync.WaitGroup
+}
+
+func NewSupervisor() *Supervisor {
+	return &Supervisor{}
+}
+
+// Every runs task every interval until ctx is done. Errors are logged and panics are recovered so a single bad run never stops the worker.
+// A non positive interval disables the task.
+func (s *Supervisor) Every(ctx context.Context, name string, interval time.Duration, task Task) {
+	if interval <= 0 {
+		log.Printf("worker %s disabled", name)
+		return
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		log.Printf("worker %s started, running every %s", name, interval)
+		for {
+			select {
+			case <-ctx.Done():
+				log.Printf("worker %s stopped", name)
+				return
+			case <-ticker.C:
+				s.run(ctx, name, task)
+			}
+		}
+	}()
+}
+
+func (s *Supervisor) run(ctx context.Context, name string, task Task) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("worker %s panicked: %v\n%s", name, r, debug.Stack())
+		}
+	}()
+
+	if err := task(ctx); err != nil && ctx.Err() == nil {
+		log.Printf("worker %s failed: %v", name, err)
+	}
+}
+
+// Go runs task once in the background. Panics are recovered and Wait waits for it like for the periodic tasks.
+func (s *Supervisor) Go(ctx context.Context, name string, task Task) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.run(ctx, name, task)
+	}()
+}
+
+// Wait blocks until every task started by the supervisor has returned
+func (s *Supervisor) Wait() {
+	s.wg.Wait()
+}
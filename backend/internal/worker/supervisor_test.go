@@ -0,0 +1,30 @@
+package worker
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWaitDrainsOneOffTasks(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := NewSupervisor()
+
+	var finished atomic.Bool
+	s.Go(ctx, "slow", func(ctx context.Context) error {
+		<-ctx.Done()
+		time.Sleep(10 * time.Millisecond)
+		finished.Store(true)
+		return ctx.Err()
+	})
+	s.Go(ctx, "panics", func(context.Context) error {
+		panic("boom")
+	})
+
+	cancel()
+	s.Wait()
+	if !finished.Load() {
+		t.Fatal("Wait returned before the task finished")
+	}
+}
@@ -59,7 +59,7 @@ function Administrator() {
                 setLoadingStates(prev => ({ ...prev, [openDropdown]: { ...prev[openDropdown], model: true } }));
                 
                 fetch(
-                    `${import.meta.env.VITE_SERVER_URL || "http://localhost:8080"}/bucket/${encodeURIComponent(print.StoredFileName)}`,
+                    `${import.meta.env.VITE_SERVER_URL || "http://localhost:8080"}/prints/${print.ID}/file`,
                     { credentials: "include" }
                 )
                     .then(res => res.blob())
@@ -128,6 +128,11 @@ function Administrator() {
 
     const batchUpdateStatus = async (status: PrintStatus) => {
         setOpenDropdown(null);
+        // Denials need a reason, which the deny modal asks for
+        if (status === "denied") {
+            batchDeny();
+            return;
+        }
         setActionLoading(true);
         setError("");
         try {
@@ -139,7 +144,7 @@ function Administrator() {
             setPrints((prev) =>
                 prev.map((p) =>
                     selected.includes(p.ID)
-                        ? { ...p, Status: status, DenialReason: status === "denied" ? "Manually denied" : undefined }
+                        ? { ...p, Status: status, DenialReason: undefined }
                         : p
                 )
             );
@@ -218,7 +223,7 @@ function Administrator() {
             setPrints((prev) =>
                 prev.map((p) =>
                     p.ID === id
-                        ? { ...p, Status: status, DenialReason: status === "denied" ? (denialReason || "Manually denied") : undefined }
+                        ? { ...p, Status: status, DenialReason: status === "denied" ? denialReason : undefined }
                         : p
                 )
             );
@@ -236,6 +241,7 @@ function Administrator() {
     const closeDenyModal = () => setDenyModal({ open: false, id: null });
 
     const handleDeny = async () => {
+        if (!denyReason.trim()) return;
         if (denyModal.id === openDropdown) {
             setOpenDropdown(null);
         }
@@ -251,13 +257,13 @@ function Administrator() {
                 
                 await Promise.all(
                     approvalPendingSelected.map((id) =>
-                        updatePrint(id, {status: "denied", denial_reason: denyReason || "Manually denied"})
+                        updatePrint(id, {status: "denied", denial_reason: denyReason.trim()})
                     )
                 );
                 setPrints((prev) =>
                     prev.map((p) =>
                         approvalPendingSelected.includes(p.ID)
-                            ? { ...p, Status: "denied" as PrintStatus, DenialReason: denyReason || "Manually denied" }
+                            ? { ...p, Status: "denied" as PrintStatus, DenialReason: denyReason.trim() }
                             : p
                     )
                 );
@@ -268,7 +274,7 @@ function Administrator() {
                 setActionLoading(false);
             }
         } else if (denyModal.id !== null) {
-            await updateStatus(denyModal.id, "denied", denyReason || "Manually denied");
+            await updateStatus(denyModal.id, "denied", denyReason.trim());
         }
         closeDenyModal();
     };
@@ -497,6 +503,11 @@ function Administrator() {
                                                 </td>
                                                 <td className="px-4 py-2 border-b text-xs text-gray-700 whitespace-pre-wrap">
                                                     {print.DenialReason || "-"}
+                                                    {print.PreviousDenials?.map(denial => (
+                                                        <div key={denial.PrintID} className="text-amber-700">
+                                                            This file was previously denied for: {denial.DenialReason || "no reason given"}
+                                                        </div>
+                                                    ))}
                                                 </td>
                                                 <td className="px-4 py-2 border-b">
                                                     <select
@@ -559,7 +570,7 @@ function Administrator() {
                                                         </>
                                                     )}
                                                     <a
-                                                        href={`${import.meta.env.VITE_SERVER_URL || "http://localhost:8080"}/bucket/${encodeURIComponent(print.StoredFileName)}`}
+                                                        href={`${import.meta.env.VITE_SERVER_URL || "http://localhost:8080"}/prints/${print.ID}/file`}
                                                         className="w-full text-center px-2 py-1 rounded text-xs bg-blue-500 text-white hover:bg-blue-600 transition-colors cursor-pointer block"
                                                         target="_blank"
                                                         rel="noopener noreferrer"
@@ -61,7 +61,7 @@ function Dashboard() {
                                         <td className="px-4 py-2 border-b text-xs text-gray-700 whitespace-pre-wrap">{print.DenialReason || "-"}</td>
                                         <td className="px-4 py-2 border-b">                
                                                 <a
-                                                    href={`${import.meta.env.VITE_SERVER_URL || "http://localhost:8080"}/bucket/${encodeURIComponent(print.StoredFileName)}`}
+                                                    href={`${import.meta.env.VITE_SERVER_URL || "http://localhost:8080"}/prints/${print.ID}/file`}
                                                     className="ml-2 px-2 py-1 rounded text-xs bg-blue-500 text-white hover:bg-blue-600 transition-colors cursor-pointer"
                                                     target="_blank"
                                                     rel="noopener noreferrer"
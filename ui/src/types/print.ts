@@ -17,10 +17,18 @@ export interface Print {
   StoredFileName: string;
   RequestedFilamentColor: string;
   DenialReason?: string;
+  PreviousDenials?: PreviousDenial[];
   CreatedAt: string;
   UpdatedAt: string;
 }
 
+export interface PreviousDenial {
+  PrintID: number;
+  UserID: number;
+  DenialReason: string;
+  SubmittedAt: string;
+}
+
 export const QUICK_DENY_REASONS = [
     "File contains inappropriate content",
     "Model too large for available printers",
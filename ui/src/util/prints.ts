@@ -7,7 +7,81 @@ export async function getPrints() {
     return res.data;
 }
 
+// Files larger than this are sent in chunks through /uploads, so a dropped connection only loses the current chunk
+const RESUMABLE_THRESHOLD = 8 * 1024 * 1024;
+const CHUNK_RETRIES = 5;
+
+async function sha256Hex(file: File) {
+    const digest = await crypto.subtle.digest("SHA-256", await file.arrayBuffer());
+    return Array.from(new Uint8Array(digest)).map((b) => b.toString(16).padStart(2, "0")).join("");
+}
+
+// uploadResumable sends a file in chunks and returns the upload id to submit in place of the file. The id is kept in
+// localStorage so the same file picks up where it left off after a reload.
+async function uploadResumable(file: File, onProgress?: (progress: number) => void) {
+    const storageKey = `spooler-upload:${file.name}:${file.size}:${file.lastModified}`;
+
+    let upload: { id: string; offset: number; max_chunk_size: number } | null = null;
+    const savedId = localStorage.getItem(storageKey);
+    if (savedId) {
+        try {
+            upload = (await axios.get(`${API_BASE_URL}/uploads/${savedId}`, { withCredentials: true })).data;
+        } catch {
+            localStorage.removeItem(storageKey);
+        }
+    }
+    if (!upload) {
+        const res = await axios.post(`${API_BASE_URL}/uploads`, {
+            file_name: file.name,
+            file_size: file.size,
+            sha256: await sha256Hex(file),
+        }, { withCredentials: true });
+        upload = res.data;
+        localStorage.setItem(storageKey, upload!.id);
+    }
+
+    const { id, max_chunk_size: chunkSize } = upload!;
+    let offset = upload!.offset;
+    let failures = 0;
+    while (offset < file.size) {
+        try {
+            const res = await axios.patch(`${API_BASE_URL}/uploads/${id}`, file.slice(offset, offset + chunkSize), {
+                withCredentials: true,
+                headers: { "Content-Type": "application/offset+octet-stream", "Upload-Offset": String(offset) },
+                timeout: 60_000,
+            });
+            offset = res.data.offset;
+            failures = 0;
+        } catch (err) {
+            if (++failures > CHUNK_RETRIES) throw err;
+            // Ask the server what it received, the failed chunk may have been stored before the connection dropped
+            await new Promise((resolve) => setTimeout(resolve, 1000 * failures));
+            try {
+                offset = (await axios.get(`${API_BASE_URL}/uploads/${id}`, { withCredentials: true })).data.offset;
+            } catch {
+                // Retry the chunk from the known offset
+            }
+        }
+        if (onProgress) onProgress(Math.min((offset / file.size) * 100, 99));
+    }
+
+    return { id, storageKey };
+}
+
 export async function createPrint(file: File, requestedFilamentColor: string, onProgress?: (progress: number) => void) {
+    if (file.size > RESUMABLE_THRESHOLD) {
+        const { id, storageKey } = await uploadResumable(file, onProgress);
+
+        const formData = new FormData();
+        formData.append("upload_id", id);
+        formData.append("requested_filament_color", requestedFilamentColor);
+        const res = await axios.post(`${API_BASE_URL}/prints/new`, formData, { withCredentials: true });
+        localStorage.removeItem(storageKey);
+
+        if (onProgress) onProgress(100);
+        return res.data;
+    }
+
     const formData = new FormData();
     formData.append("file", file);
     formData.append("file_name", file.name);